@@ -47,6 +47,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/proxy"
@@ -72,11 +73,30 @@ func (d *socks4Dialer) Dial(network, addr string) (net.Conn, error) {
 	return d.dialFunc(network, addr)
 }
 
-// Read data from a connection. To be used as a goroutine.
+// readResult is a parsed line, or the read error, passed from readLines to
+// readLoop.
+type readResult struct {
+	event *Event
+	err   error
+}
+
+// Handle data from a connection. To be used as a goroutine.
 func (irc *Connection) readLoop() {
 	defer irc.Done()
-	r := irc.Encoding.NewDecoder().Reader(irc.socket)
-	br := bufio.NewReaderSize(r, 512)
+
+	var d *dispatcher
+	if irc.DispatchWorkers > 0 {
+		d = irc.startDispatcher()
+		defer d.close()
+	}
+	local := irc.startLocalEvents()
+	defer irc.stopLocalEvents(d, local)
+
+	lines := make(chan readResult)
+	stop := make(chan struct{})
+	defer close(stop)
+	irc.Add(1)
+	go irc.readLines(irc.socket, lines, stop)
 
 	errChan := irc.ErrorChan()
 
@@ -84,147 +104,136 @@ func (irc *Connection) readLoop() {
 		select {
 		case <-irc.end:
 			return
-		default:
-			// Set a read deadline based on the combined timeout and ping frequency
-			// We should ALWAYS have received a response from the server within the timeout
-			// after our own pings
-			if irc.socket != nil {
-				irc.socket.SetReadDeadline(time.Now().Add(irc.Timeout + irc.PingFreq))
-			}
-
-			msg, err := br.ReadString('\n')
-
-			// We got past our blocking read, so clear timeout
-			if irc.socket != nil {
-				var zero time.Time
-				irc.socket.SetReadDeadline(zero)
-			}
-
-			if err != nil {
-				errChan <- err
+		case <-local.ready:
+			irc.deliverLocal(d, local)
+		case r := <-lines:
+			if r.err != nil {
+				errChan <- r.err
 				return
 			}
+			// Events raised before this line was read go first
+			irc.deliverLocal(d, local)
 
-			if irc.Debug {
-				irc.Log.Printf("<-- %s\n", strings.TrimSpace(msg))
-			}
+			event := r.event
+			if irc.HandleErrorAsDisconnect && strings.ToUpper(event.Code) == "ERROR" {
+				errorMsg := event.Message()
 
-			irc.lastMessageMutex.Lock()
-			irc.lastMessage = time.Now()
-			irc.lastMessageMutex.Unlock()
-			event, err := parseToEvent(msg)
-			if err == nil {
-				event.Connection = irc
-				if irc.HandleErrorAsDisconnect && strings.ToUpper(event.Code) == "ERROR" {
-					errorMsg := event.Message()
+				// ENHANCED: Smart ERROR handling - analyze message to determine if reconnect should be blocked
+				if irc.SmartErrorHandling {
+					errorType := AnalyzeErrorMessage(errorMsg)
 
-					// ENHANCED: Smart ERROR handling - analyze message to determine if reconnect should be blocked
-					if irc.SmartErrorHandling {
-						errorType := AnalyzeErrorMessage(errorMsg)
+					if irc.Debug {
+						irc.Log.Printf("ERROR analysis: %s -> %s", errorMsg, errorType.String())
+					}
 
+					// Handle different error types appropriately
+					switch errorType {
+					case PermanentError:
+						// Block reconnection for permanent errors
+						errChan <- errors.New("Received permanent ERROR from server: " + errorMsg)
+						return
+
+					case RecoverableError:
+						// Treat recoverable errors as disconnect triggers to allow controlled reconnects
 						if irc.Debug {
-							irc.Log.Printf("ERROR analysis: %s -> %s", errorMsg, errorType.String())
+							irc.Log.Printf("Recoverable ERROR detected, will attempt controlled reconnect")
 						}
+						irc.deliverEvent(d, event)
+						errChan <- errors.New("Received RecoverableError from server: " + errorMsg)
+						return
 
-						// Handle different error types appropriately
-						switch errorType {
-						case PermanentError:
-							// Block reconnection for permanent errors
-							errChan <- errors.New("Received permanent ERROR from server: " + errorMsg)
-							return
-
-						case RecoverableError:
-							// Treat recoverable errors as disconnect triggers to allow controlled reconnects
-							if irc.Debug {
-								irc.Log.Printf("Recoverable ERROR detected, will attempt controlled reconnect")
-							}
-							irc.RunCallbacks(event)
-							errChan <- errors.New("Received RecoverableError from server: " + errorMsg)
-							return
-
-						default:
-							// For server/network errors, signal error but allow reconnection
-							irc.RunCallbacks(event)
-							errChan <- errors.New("Received " + errorType.String() + " from server: " + errorMsg)
-							return
-						}
-					} else {
-						// Original behavior - block all ERROR messages
-						errChan <- errors.New("Received ERROR from server: " + errorMsg)
+					default:
+						// For server/network errors, signal error but allow reconnection
+						irc.deliverEvent(d, event)
+						errChan <- errors.New("Received " + errorType.String() + " from server: " + errorMsg)
 						return
 					}
+				} else {
+					// Original behavior - block all ERROR messages
+					errChan <- errors.New("Received ERROR from server: " + errorMsg)
+					return
 				}
-				irc.RunCallbacks(event)
 			}
+			irc.deliverEvent(d, event)
+			// Events raised by its handlers, e.g. REGISTERED after 001
+			irc.deliverLocal(d, local)
 		}
 	}
 }
 
-// Unescape tag values as defined in the IRCv3.2 message tags spec
-// http://ircv3.net/specs/core/message-tags-3.2.html
-func unescapeTagValue(value string) string {
-	value = strings.Replace(value, "\\:", ";", -1)
-	value = strings.Replace(value, "\\s", " ", -1)
-	value = strings.Replace(value, "\\\\", "\\", -1)
-	value = strings.Replace(value, "\\r", "\r", -1)
-	value = strings.Replace(value, "\\n", "\n", -1)
-	return value
-}
+// Read data from a connection and parse it into events for readLoop until
+// reading fails or stop is closed. To be used as a goroutine.
+func (irc *Connection) readLines(socket net.Conn, lines chan<- readResult, stop <-chan struct{}) {
+	defer irc.Done()
+	r := irc.Encoding.NewDecoder().Reader(socket)
+	br := bufio.NewReaderSize(r, 512)
+	var long []byte
 
-// Parse raw IRC messages
-func parseToEvent(msg string) (*Event, error) {
-	msg = strings.TrimSuffix(msg, "\n") // Remove \r\n
-	msg = strings.TrimSuffix(msg, "\r")
-	event := &Event{Raw: msg}
-	// Minimum valid IRC message is a single command (e.g., "PING")
-	// Changed from 5 to 1 to support all valid IRC messages
-	if len(msg) < 1 {
-		return nil, errors.New("malformed msg from server")
-	}
-
-	if msg[0] == '@' {
-		// IRCv3 Message Tags
-		if i := strings.Index(msg, " "); i > -1 {
-			event.Tags = make(map[string]string)
-			tags := strings.Split(msg[1:i], ";")
-			for _, data := range tags {
-				parts := strings.SplitN(data, "=", 2)
-				if len(parts) == 1 {
-					event.Tags[parts[0]] = ""
-				} else {
-					event.Tags[parts[0]] = unescapeTagValue(parts[1])
-				}
+	for {
+		// Set a read deadline based on the combined timeout and ping frequency
+		// We should ALWAYS have received a response from the server within the timeout
+		// after our own pings
+		socket.SetReadDeadline(time.Now().Add(irc.Timeout + irc.PingFreq))
+
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Lines longer than the buffer, e.g. with many tags
+			long = append(long[:0], line...)
+			for err == bufio.ErrBufferFull {
+				line, err = br.ReadSlice('\n')
+				long = append(long, line...)
 			}
-			msg = msg[i+1:]
-		} else {
-			return nil, errors.New("malformed msg from server")
+			line = long
 		}
-	}
 
-	if msg[0] == ':' {
-		if i := strings.Index(msg, " "); i > -1 {
-			event.Source = msg[1:i]
-			msg = msg[i+1:]
-		} else {
-			return nil, errors.New("malformed msg from server")
+		// We got past our blocking read, so clear timeout
+		var zero time.Time
+		socket.SetReadDeadline(zero)
+
+		var event *Event
+		if err == nil {
+			if irc.Debug {
+				irc.Log.Printf("<-- %s\n", bytes.TrimSpace(line))
+			}
+
+			irc.lastMessageMutex.Lock()
+			irc.lastMessage = time.Now()
+			irc.lastMessageMutex.Unlock()
+
+			event = new(Event)
+			if irc.PoolEvents {
+				event = getEvent()
+			}
+			if parseErr := parseEventLine(event, line, !irc.LazyTags); parseErr != nil {
+				if irc.PoolEvents {
+					releaseEvent(event)
+				}
+				continue
+			}
+			event.Connection = irc
 		}
 
-		if i, j := strings.Index(event.Source, "!"), strings.Index(event.Source, "@"); i > -1 && j > -1 && i < j {
-			event.Nick = event.Source[0:i]
-			event.User = event.Source[i+1 : j]
-			event.Host = event.Source[j+1:]
+		select {
+		case lines <- readResult{event, err}:
+		case <-stop:
+			if event != nil && irc.PoolEvents {
+				releaseEvent(event)
+			}
+			return
+		}
+		if err != nil {
+			return
 		}
 	}
+}
 
-	split := strings.SplitN(msg, " :", 2)
-	args := strings.Split(split[0], " ")
-	event.Code = strings.ToUpper(args[0])
-	event.Arguments = args[1:]
-	if len(split) > 1 {
-		event.Arguments = append(event.Arguments, split[1])
+// Parse raw IRC messages
+func parseToEvent(msg string) (*Event, error) {
+	event := &Event{Raw: strings.TrimRight(msg, "\r\n")}
+	if _, err := event.parseRaw(true); err != nil {
+		return nil, err
 	}
 	return event, nil
-
 }
 
 // Loop to write to a connection. To be used as a goroutine.
@@ -241,27 +250,39 @@ func (irc *Connection) writeLoop() {
 				return
 			}
 
-			if irc.Debug {
-				irc.Log.Printf("--> %s\n", strings.TrimSpace(b))
-			}
-
-			// Set a write deadline based on the timeout
-			irc.socket.SetWriteDeadline(time.Now().Add(irc.Timeout))
+			err := irc.writeLine(b, func(line string) error {
+				if irc.Debug {
+					irc.Log.Printf("--> %s\n", strings.TrimSpace(line))
+				}
 
-			_, err := w.Write([]byte(b))
+				// Set a write deadline based on the timeout
+				irc.socket.SetWriteDeadline(time.Now().Add(irc.Timeout))
 
-			// Clear the write deadline
-			var zero time.Time
-			irc.socket.SetWriteDeadline(zero)
+				_, err := w.Write([]byte(line))
 
+				// Clear the write deadline
+				var zero time.Time
+				irc.socket.SetWriteDeadline(zero)
+				return err
+			})
 			if err != nil {
-				errChan <- err
+				// When the server closed the connection, the read loop
+				// reports it, after any ERROR the server sent first.
+				if !isPeerClosedError(err) {
+					errChan <- err
+				}
 				return
 			}
 		}
 	}
 }
 
+// isPeerClosedError reports whether a write failed because the server has
+// closed the connection.
+func isPeerClosedError(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
 // Pings the server if we have not received any messages for 5 minutes
 // to keep the connection alive. To be used as a goroutine.
 func (irc *Connection) pingLoop() {
@@ -274,12 +295,12 @@ func (irc *Connection) pingLoop() {
 			// Ping if we haven't received anything from the server within the keep-alive period
 			irc.lastMessageMutex.Lock()
 			if time.Since(irc.lastMessage) >= irc.KeepAlive {
-				irc.SendRawf("PING %d", time.Now().UnixNano())
+				irc.send("PING", strconv.FormatInt(time.Now().UnixNano(), 10))
 			}
 			irc.lastMessageMutex.Unlock()
 		case <-ticker2.C:
 			// Ping at the ping frequency
-			irc.SendRawf("PING %d", time.Now().UnixNano())
+			irc.send("PING", strconv.FormatInt(time.Now().UnixNano(), 10))
 		case <-irc.end:
 			ticker.Stop()
 			ticker2.Stop()
@@ -310,6 +331,15 @@ func (irc *Connection) reconnectLimitReached() bool {
 	return irc.reconnectLimitReachedLocked()
 }
 
+// reconnectCounted reports whether a counted reconnect has not led to a
+// registration yet. Whatever ends such a connection, a write error as much
+// as another ERROR, then counts towards MaxRecoverableReconnects too.
+func (irc *Connection) reconnectCounted() bool {
+	irc.Lock()
+	defer irc.Unlock()
+	return irc.recoverableReconnects > 0
+}
+
 func (irc *Connection) noteReconnectAttempt() int {
 	irc.Lock()
 	defer irc.Unlock()
@@ -369,6 +399,7 @@ func (irc *Connection) finishDisconnectedLoop(reason string) {
 	irc.closeEnd()
 	irc.closeSocket()
 	irc.Wait()
+	irc.emitLifecycle(EventGaveUp, reason)
 	irc.emitDisconnected(reason)
 }
 
@@ -387,6 +418,7 @@ func (irc *Connection) Loop() {
 		err := <-errChan
 		// Decide reconnection strategy based on error content
 		errStr := err.Error()
+		limited := isLimitedReconnectError(errStr) || irc.reconnectCounted()
 		if irc.HandleErrorAsDisconnect {
 			// Permanent errors should not reconnect
 			if strings.Contains(errStr, "Received permanent ERROR from server:") {
@@ -395,7 +427,7 @@ func (irc *Connection) Loop() {
 				return
 			}
 			// Limit configured reconnect classes if configured.
-			if isLimitedReconnectError(errStr) && irc.reconnectLimitReached() {
+			if limited && irc.reconnectLimitReached() {
 				irc.Log.Printf("Max reconnect attempts reached (%d); stopping.", irc.MaxRecoverableReconnects)
 				irc.finishDisconnectedLoop(errStr)
 				return
@@ -404,19 +436,28 @@ func (irc *Connection) Loop() {
 		irc.closeEnd()
 		irc.closeSocket()
 		irc.Wait()
-		for !irc.isQuitting() {
+		var delay time.Duration
+		for attempt := 1; !irc.isQuitting(); attempt++ {
 			irc.Log.Printf("Error, disconnected: %s\n", err)
-			if isLimitedReconnectError(errStr) {
+			if limited {
 				if irc.reconnectLimitReached() {
 					irc.Log.Printf("Max reconnect attempts reached (%d); stopping.", irc.MaxRecoverableReconnects)
+					irc.emitLifecycle(EventGaveUp, errStr)
 					irc.emitDisconnected(errStr)
 					return
 				}
 				irc.noteReconnectAttempt()
 			}
+			irc.emitReconnecting(attempt, delay, err)
+			if delay > 0 {
+				time.Sleep(delay)
+				if irc.isQuitting() {
+					break
+				}
+			}
 			if err = irc.Reconnect(); err != nil {
 				irc.Log.Printf("Error while reconnecting: %s\n", err)
-				time.Sleep(60 * time.Second)
+				delay = reconnectDelay
 			} else {
 				errChan = irc.ErrorChan()
 				break
@@ -429,10 +470,11 @@ func (irc *Connection) Loop() {
 // Quit the current connection and disconnect from the server
 // RFC 1459 details: https://tools.ietf.org/html/rfc1459#section-4.1.6
 func (irc *Connection) Quit() {
-	quit := "QUIT"
+	quit := NewMessage("QUIT")
 
 	if irc.QuitMessage != "" {
-		quit = fmt.Sprintf("QUIT :%s", irc.QuitMessage)
+		quit = NewMessage("QUIT", irc.QuitMessage)
+		quit.ForceTrailing = true
 	}
 
 	irc.StopReconnect()
@@ -440,25 +482,36 @@ func (irc *Connection) Quit() {
 	// Keep the existing pacing before sending QUIT to IRC server.
 	time.Sleep(1 * time.Second)
 
-	irc.SendRaw(quit)
+	irc.sendMessage(quit)
 }
 
-// Use the connection to join a given channel.
+// Use the connection to join a given channel. Keys follow the channels
+// after a space, e.g. "#chan key" or "#a,#b keyA,keyB".
 // RFC 1459 details: https://tools.ietf.org/html/rfc1459#section-4.2.1
 func (irc *Connection) Join(channel string) {
-	irc.pwrite <- fmt.Sprintf("JOIN %s\r\n", channel)
+	irc.send("JOIN", strings.Fields(channel)...)
 }
 
-// Leave a given channel.
+// Leave a given channel. A part message may follow the channel after a
+// space, e.g. "#chan :bye".
 // RFC 1459 details: https://tools.ietf.org/html/rfc1459#section-4.2.2
 func (irc *Connection) Part(channel string) {
-	irc.pwrite <- fmt.Sprintf("PART %s\r\n", channel)
+	channel, reason, ok := strings.Cut(strings.TrimSpace(channel), " ")
+	if !ok {
+		irc.send("PART", channel)
+		return
+	}
+	irc.sendText("PART", channel, strings.TrimPrefix(strings.TrimLeft(reason, " "), ":"))
 }
 
 // Send a notification to a nickname. This is similar to Privmsg but must not receive replies.
+// A message that does not fit on one line, e.g. one containing CR or LF,
+// is logged and not sent; use SendMessage to get the error instead.
 // RFC 1459 details: https://tools.ietf.org/html/rfc1459#section-4.4.2
 func (irc *Connection) Notice(target, message string) {
-	irc.pwrite <- fmt.Sprintf("NOTICE %s :%s\r\n", target, message)
+	if irc.sendText("NOTICE", target, message) {
+		irc.emitSelfMessage("NOTICE", target, message)
+	}
 }
 
 // Send a formatted notification to a nickname.
@@ -470,7 +523,10 @@ func (irc *Connection) Noticef(target, format string, a ...interface{}) {
 // Send (action) message to a target (channel or nickname).
 // No clear RFC on this one...
 func (irc *Connection) Action(target, message string) {
-	irc.pwrite <- fmt.Sprintf("PRIVMSG %s :\001ACTION %s\001\r\n", target, message)
+	action := EncodeCTCP("ACTION", message)
+	if irc.sendText("PRIVMSG", target, action) {
+		irc.emitSelfMessage("PRIVMSG", target, action)
+	}
 }
 
 // Send formatted (action) message to a target (channel or nickname).
@@ -479,9 +535,13 @@ func (irc *Connection) Actionf(target, format string, a ...interface{}) {
 }
 
 // Send (private) message to a target (channel or nickname).
+// A message that does not fit on one line, e.g. one containing CR or LF,
+// is logged and not sent; use SendMessage to get the error instead.
 // RFC 1459 details: https://tools.ietf.org/html/rfc1459#section-4.4.1
 func (irc *Connection) Privmsg(target, message string) {
-	irc.pwrite <- fmt.Sprintf("PRIVMSG %s :%s\r\n", target, message)
+	if irc.sendText("PRIVMSG", target, message) {
+		irc.emitSelfMessage("PRIVMSG", target, message)
+	}
 }
 
 // Send formatted string to specified target (channel or nickname).
@@ -491,25 +551,17 @@ func (irc *Connection) Privmsgf(target, format string, a ...interface{}) {
 
 // Kick <user> from <channel> with <msg>. For no message, pass empty string ("")
 func (irc *Connection) Kick(user, channel, msg string) {
-	var cmd bytes.Buffer
-	cmd.WriteString(fmt.Sprintf("KICK %s %s", channel, user))
-	if msg != "" {
-		cmd.WriteString(fmt.Sprintf(" :%s", msg))
-	}
-	cmd.WriteString("\r\n")
-	irc.pwrite <- cmd.String()
+	irc.MultiKick([]string{user}, channel, msg)
 }
 
 // Kick all <users> from <channel> with <msg>. For no message, pass
 // empty string ("")
 func (irc *Connection) MultiKick(users []string, channel string, msg string) {
-	var cmd bytes.Buffer
-	cmd.WriteString(fmt.Sprintf("KICK %s %s", channel, strings.Join(users, ",")))
-	if msg != "" {
-		cmd.WriteString(fmt.Sprintf(" :%s", msg))
+	if msg == "" {
+		irc.send("KICK", channel, strings.Join(users, ","))
+		return
 	}
-	cmd.WriteString("\r\n")
-	irc.pwrite <- cmd.String()
+	irc.sendText("KICK", channel, strings.Join(users, ","), msg)
 }
 
 // Send raw string.
@@ -543,6 +595,12 @@ func (irc *Connection) Nick(n string) {
 	irc.Lock()
 	defer irc.Unlock()
 
+	// An explicit nick request replaces whatever we were trying to regain
+	if irc.regainNick != "" && !ircNickEqual(n, irc.regainNick) {
+		irc.stopRegainLocked()
+	}
+	irc.altNickAttempt = 0
+
 	// ENHANCED: Prevent multiple simultaneous nick changes (race condition fix)
 	if irc.nickChangeInProgress && time.Since(irc.nickChangeTimeout) < 30*time.Second {
 		// Record the latest requested nickname while the current change completes.
@@ -569,7 +627,7 @@ func (irc *Connection) Nick(n string) {
 
 		// Send the NICK command to the server (unlock first to avoid deadlock)
 		irc.Unlock()
-		irc.SendRawf("NICK %s", n)
+		irc.send("NICK", n)
 		irc.Lock()
 
 		if irc.Debug {
@@ -639,30 +697,31 @@ func (irc *Connection) GetNickStatus() *NickStatus {
 		LastChangeTime: lastChangeTime,
 		PendingChange:  !ircNickEqual(irc.nick, irc.nickcurrent),
 		Error:          irc.nickError,
+		RegainNick:     irc.regainNick,
+		RegainState:    irc.regainState,
 	}
 }
 
 // Query information about a particular nickname.
 // RFC 1459: https://tools.ietf.org/html/rfc1459#section-4.5.2
 func (irc *Connection) Whois(nick string) {
-	irc.SendRawf("WHOIS %s", nick)
+	irc.send("WHOIS", nick)
 }
 
 // Query information about a given nickname in the server.
 // RFC 1459 details: https://tools.ietf.org/html/rfc1459#section-4.5.1
 func (irc *Connection) Who(nick string) {
-	irc.SendRawf("WHO %s", nick)
+	irc.send("WHO", nick)
 }
 
 // Set different modes for a target (channel or nickname).
 // RFC 1459 details: https://tools.ietf.org/html/rfc1459#section-4.2.3
 func (irc *Connection) Mode(target string, modestring ...string) {
-	if len(modestring) > 0 {
-		mode := strings.Join(modestring, " ")
-		irc.SendRawf("MODE %s %s", target, mode)
-		return
+	params := []string{target}
+	for _, mode := range modestring {
+		params = append(params, strings.Fields(mode)...)
 	}
-	irc.SendRawf("MODE %s", target)
+	irc.send("MODE", params...)
 }
 
 func (irc *Connection) ErrorChan() chan error {
@@ -683,6 +742,29 @@ func (irc *Connection) resetRegistrationStateLocked() {
 	irc.sentRegistration = false
 	irc.got020 = false
 	irc.last020 = time.Time{}
+	irc.isupport = nil
+	irc.account = ""
+	irc.altNickAttempt = 0
+	irc.capsNegotiated = false
+	irc.regainServicesSent = false
+	if irc.regainNick != "" {
+		irc.regainState = RegainWaiting
+	}
+	if irc.monitor != nil {
+		irc.monitor.resetSession()
+	}
+	if irc.whois != nil {
+		irc.whois.failAll(ErrDisconnected)
+	}
+	if irc.who != nil {
+		irc.who.failAll(ErrDisconnected)
+	}
+	if irc.list != nil {
+		irc.list.failAll(ErrDisconnected)
+	}
+	if irc.ctcp != nil {
+		irc.ctcp.failAll(ErrDisconnected)
+	}
 	irc.registrationGeneration++
 }
 
@@ -692,9 +774,14 @@ func (irc *Connection) registrationSession() uint64 {
 	return irc.registrationGeneration
 }
 
-func (irc *Connection) markFullyConnectedLocked() {
+// markFullyConnectedLocked records a successful registration. It reports
+// whether the connection was not registered before, in which case the
+// caller runs EventRegistered after unlocking.
+func (irc *Connection) markFullyConnectedLocked() bool {
+	registered := !irc.fullyConnected
 	irc.fullyConnected = true
 	irc.recoverableReconnects = 0
+	return registered
 }
 
 func (irc *Connection) sendRegistrationOnce(generation uint64, pwrite chan<- string) bool {
@@ -726,9 +813,12 @@ func (irc *Connection) sendRegistrationOnce(generation uint64, pwrite chan<- str
 		return false
 	}
 
-	pwrite <- "NICK " + nick + "\r\n"
-	pwrite <- "USER " + user + " 0 * :" + realname + "\r\n"
-	return true
+	if !irc.writeMessage(pwrite, NewMessage("NICK", nick)) {
+		return false
+	}
+	userMsg := NewMessage("USER", user, "0", "*", realname)
+	userMsg.ForceTrailing = true
+	return irc.writeMessage(pwrite, userMsg)
 }
 
 // A disconnect sends all buffered messages (if possible),
@@ -858,8 +948,14 @@ func (irc *Connection) Connect(server string) error {
 	if err != nil {
 		return err
 	}
+	irc.emitLifecycle(EventConnected, irc.Server, irc.socket.RemoteAddr().String())
 	if irc.UseTLS {
-		irc.socket = tls.Client(irc.socket, irc.TLSConfig)
+		tlsConn := tls.Client(irc.socket, irc.TLSConfig)
+		if err := irc.handshakeTLS(tlsConn); err != nil {
+			irc.socket.Close()
+			return err
+		}
+		irc.socket = tlsConn
 	}
 
 	if irc.Encoding == nil {
@@ -881,11 +977,11 @@ func (irc *Connection) Connect(server string) error {
 	go irc.pingLoop()
 
 	if len(irc.WebIRC) > 0 {
-		irc.pwrite <- fmt.Sprintf("WEBIRC %s\r\n", irc.WebIRC)
+		irc.writeMessage(irc.pwrite, NewMessage("WEBIRC", strings.Fields(irc.WebIRC)...))
 	}
 
 	if len(irc.Password) > 0 {
-		irc.pwrite <- fmt.Sprintf("PASS %s\r\n", irc.Password)
+		irc.writeMessage(irc.pwrite, NewMessage("PASS", irc.Password))
 	}
 
 	err = irc.negotiateCaps()
@@ -906,10 +1002,18 @@ func (irc *Connection) SetProxy(proxyType, address, username, password string) {
 	}
 }
 
+// ackCap records a capability acknowledged by the server.
+func (irc *Connection) ackCap(name string) {
+	irc.Lock()
+	defer irc.Unlock()
+	irc.AcknowledgedCaps = append(irc.AcknowledgedCaps, name)
+}
+
 // Negotiate IRCv3 capabilities
 func (irc *Connection) negotiateCaps() error {
-	irc.RequestCaps = nil
+	irc.Lock()
 	irc.AcknowledgedCaps = nil
+	irc.Unlock()
 	registrationGeneration := irc.registrationSession()
 	pwrite := irc.pwrite
 
@@ -920,36 +1024,45 @@ func (irc *Connection) negotiateCaps() error {
 		}
 	}()
 
+	requestCaps := irc.capsToRequest()
 	saslResChan := make(chan *SASLResult)
 	if irc.UseSASL {
-		irc.RequestCaps = append(irc.RequestCaps, "sasl")
 		negotiationCallbacks = irc.setupSASLCallbacks(saslResChan)
 	}
 
-	if len(irc.RequestCaps) == 0 {
+	if len(requestCaps) == 0 {
 		// No capabilities to negotiate: send registration automatically
 		irc.sendRegistrationOnce(registrationGeneration, pwrite)
+		irc.emitCapNegotiated()
 		return nil
 	}
 
-	cap_chan := make(chan bool, len(irc.RequestCaps))
-	id := irc.AddCallback("CAP", func(e *Event) {
+	cap_chan := make(chan bool, len(requestCaps))
+	var advertised []string
+	id := irc.addInternalCallback("CAP", func(e *Event) {
 		if len(e.Arguments) < 2 {
 			return
 		}
 		command := e.Arguments[1]
 
 		if command == "LS" {
-			// When we see LS, server is ready; send registration now if not sent yet
-			missing_caps := len(irc.RequestCaps)
+			// CAP 302 may split LS over several lines; wait for the last one
+			// before deciding what to request.
 			if len(e.Arguments) >= 3 {
-				for _, cap_name := range strings.Split(e.Arguments[2], " ") {
-					for _, req_cap := range irc.RequestCaps {
-						if cap_name == req_cap {
-							pwrite <- fmt.Sprintf("CAP REQ :%s\r\n", cap_name)
-							missing_caps--
-						}
-					}
+				advertised = append(advertised, strings.Fields(e.Message())...)
+			}
+			if isCapLSContinuation(e) {
+				return
+			}
+
+			// When we see LS, server is ready; send registration now if not sent yet
+			missing_caps := len(requestCaps)
+			for _, req_cap := range requestCaps {
+				if capListContains(advertised, req_cap) {
+					req := NewMessage("CAP", "REQ", req_cap)
+					req.ForceTrailing = true
+					irc.writeMessage(pwrite, req)
+					missing_caps--
 				}
 			}
 			// try to send registration early once LS seen
@@ -965,7 +1078,7 @@ func (irc *Connection) negotiateCaps() error {
 				}
 
 				if command == "ACK" {
-					irc.AcknowledgedCaps = append(irc.AcknowledgedCaps, cap_name)
+					irc.ackCap(cap_name)
 				}
 				cap_chan <- true
 			}
@@ -975,9 +1088,9 @@ func (irc *Connection) negotiateCaps() error {
 
 	// Send CAP LS once, with configured version
 	if irc.CapVersion != "" {
-		pwrite <- fmt.Sprintf("CAP LS %s\r\n", irc.CapVersion)
+		irc.writeMessage(pwrite, NewMessage("CAP", "LS", irc.CapVersion))
 	} else {
-		pwrite <- "CAP LS\r\n"
+		irc.writeMessage(pwrite, NewMessage("CAP", "LS"))
 	}
 
 	// Fallback: if no CAP LS seen quickly (or handler didn't send registration),
@@ -999,13 +1112,14 @@ func (irc *Connection) negotiateCaps() error {
 		}
 	}
 
-	remaining_caps := len(irc.RequestCaps)
+	remaining_caps := len(requestCaps)
 
 	select {
 	case <-cap_chan:
 		remaining_caps--
 	case <-time.After(CAP_TIMEOUT):
 		// The server probably doesn't implement CAP LS, which is "normal".
+		irc.emitCapNegotiated()
 		return nil
 	}
 
@@ -1015,7 +1129,8 @@ func (irc *Connection) negotiateCaps() error {
 		remaining_caps--
 	}
 
-	pwrite <- "CAP END\r\n"
+	irc.writeMessage(pwrite, NewMessage("CAP", "END"))
+	irc.emitCapNegotiated()
 
 	return nil
 }
@@ -1059,7 +1174,13 @@ func IRC(nick, user string) *Connection {
 		// NEW: Enable smart ERROR handling by default
 		SmartErrorHandling: true, // Analyze ERROR messages intelligently
 
-		DCCManager:              NewDCCManager(), // DCC chat support
+		DCCManager:              NewDCCManager(),     // DCC chat support
+		monitor:                 newMonitorTracker(), // MONITOR/ISON presence tracking
+		whois:                   newWhoisTracker(),   // WhoisSync requests in flight
+		who:                     newWhoTracker(),     // WhoSync/WhoStream queries in flight
+		list:                    newListTracker(),    // List query in flight
+		ctcp:                    newCTCPTracker(),    // CTCP requests awaiting replies
+		ctcpHandlers:            newCTCPRegistry(),   // incoming CTCP responders
 		ProxyConfig:             nil,
 		HandleErrorAsDisconnect: true, // Default to true to not reconnect after ERROR event
 
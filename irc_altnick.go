@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import "strconv"
+
+// AltNickStrategy chooses the nickname to try when the server rejects the
+// one we asked for (433, 436, 437 or 432).
+//
+// desired is the nick the user asked for, rejected the nick the server just
+// refused and attempt counts the fallbacks since the last confirmed nick,
+// starting at 1. maxLen is the server's NICKLEN or, before RPL_ISUPPORT
+// arrives, a conservative estimate.
+//
+// The returned nick is cleaned up to valid RFC 2812 characters and maxLen.
+// An empty result, or the rejected nick itself, makes the library fall back
+// to PatternAltNick.
+type AltNickStrategy interface {
+	AltNick(desired, rejected string, attempt, maxLen int) string
+}
+
+// RFCAltNick is the default strategy. It derives the alternative from the
+// rejected nick by appending or substituting "_", "-" or a digit, always
+// within the RFC 2812 limit of 9 characters.
+type RFCAltNick struct{}
+
+// AltNick implements AltNickStrategy.
+func (RFCAltNick) AltNick(desired, rejected string, attempt, maxLen int) string {
+	return generateAlternativeNick(rejected)
+}
+
+// PatternAltNick appends Suffixes to the desired nick in order and then
+// continues with increasing numbers, shortening the nick when it would not
+// fit in the server's NICKLEN. The default suffixes are "_" and "-".
+type PatternAltNick struct {
+	Suffixes []string
+}
+
+// AltNick implements AltNickStrategy.
+func (p PatternAltNick) AltNick(desired, rejected string, attempt, maxLen int) string {
+	suffixes := p.Suffixes
+	if len(suffixes) == 0 {
+		suffixes = []string{"_", "-"}
+	}
+
+	var suffix string
+	if attempt >= 1 && attempt <= len(suffixes) {
+		suffix = suffixes[attempt-1]
+	} else {
+		suffix = strconv.Itoa(attempt - len(suffixes))
+	}
+	return buildNickCandidate(sanitizeNick(desired, maxLen), suffix, maxLen)
+}
+
+// AltNickList tries the configured Nicks in order and then hands over to
+// Fallback (PatternAltNick when nil).
+type AltNickList struct {
+	Nicks    []string
+	Fallback AltNickStrategy
+}
+
+// AltNick implements AltNickStrategy.
+func (l AltNickList) AltNick(desired, rejected string, attempt, maxLen int) string {
+	if attempt >= 1 && attempt <= len(l.Nicks) {
+		return l.Nicks[attempt-1]
+	}
+
+	fallback := l.Fallback
+	if fallback == nil {
+		fallback = PatternAltNick{}
+	}
+	return fallback.AltNick(desired, rejected, attempt-len(l.Nicks), maxLen)
+}
+
+// nickLenLocked returns the nick length limit passed to AltNickStrategy:
+// NICKLEN once the server advertised it, otherwise the longer of the RFC
+// limit and the desired nick, so that we never try a nick longer than one
+// we already chose to send. The caller must hold irc.Lock.
+func (irc *Connection) nickLenLocked() int {
+	if n := irc.isupportIntLocked("NICKLEN", 0); n > 0 {
+		return n
+	}
+	if len(irc.nick) > maxRFCNickLen {
+		return len(irc.nick)
+	}
+	return maxRFCNickLen
+}
+
+// nextAltNickLocked picks the nick to try after attemptedNick was refused.
+// The caller must hold irc.Lock.
+func (irc *Connection) nextAltNickLocked(attemptedNick string) string {
+	irc.altNickAttempt++
+	attempt := irc.altNickAttempt
+	maxLen := irc.nickLenLocked()
+
+	strategy := irc.AltNick
+	if strategy == nil {
+		strategy = RFCAltNick{}
+	}
+
+	candidate := strategy.AltNick(irc.nick, attemptedNick, attempt, maxLen)
+	if candidate != "" {
+		candidate = sanitizeNick(candidate, maxLen)
+	}
+	if candidate == "" || ircNickEqual(candidate, attemptedNick) {
+		candidate = PatternAltNick{}.AltNick(irc.nick, attemptedNick, attempt, maxLen)
+	}
+	if ircNickEqual(candidate, attemptedNick) {
+		candidate = generateAlternativeNick(attemptedNick)
+	}
+	return candidate
+}
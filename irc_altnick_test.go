@@ -0,0 +1,81 @@
+package irc
+
+import "testing"
+
+func TestPatternAltNickHonoursNickLen(t *testing.T) {
+	p := PatternAltNick{}
+	cases := []struct {
+		desired string
+		attempt int
+		maxLen  int
+		want    string
+	}{
+		{"LongNickname", 1, 30, "LongNickname_"},
+		{"LongNickname", 2, 30, "LongNickname-"},
+		{"LongNickname", 3, 30, "LongNickname1"},
+		{"LongNickname", 14, 30, "LongNickname12"},
+		{"LongNickname", 1, 12, "LongNicknam_"},
+		{"LongNickname", 3, 9, "LongNick1"},
+	}
+	for _, c := range cases {
+		if got := p.AltNick(c.desired, "", c.attempt, c.maxLen); got != c.want {
+			t.Errorf("AltNick(%q, attempt %d, maxLen %d) = %q, want %q", c.desired, c.attempt, c.maxLen, got, c.want)
+		}
+	}
+}
+
+func TestAltNickListThenFallback(t *testing.T) {
+	l := AltNickList{Nicks: []string{"first", "second"}}
+	want := []string{"first", "second", "wanted_", "wanted-", "wanted1"}
+	for i, w := range want {
+		if got := l.AltNick("wanted", "", i+1, 30); got != w {
+			t.Errorf("attempt %d: got %q, want %q", i+1, got, w)
+		}
+	}
+}
+
+func TestAltNickStrategyDrivesNickErrors(t *testing.T) {
+	irccon := IRC("LongNickname", "testuser")
+	irccon.pwrite = make(chan string, 10)
+	irccon.nickcurrent = "LongNickname"
+	irccon.AltNick = AltNickList{Nicks: []string{"Backup"}}
+
+	irccon.RunCallbacks(&Event{Code: "433", Arguments: []string{"*", "LongNickname", "Nickname is already in use"}})
+	if got := nextRawCommand(t, irccon.pwrite); got != "NICK Backup\r\n" {
+		t.Fatalf("expected list nick first, got %q", got)
+	}
+
+	// Before RPL_ISUPPORT the desired nick length bounds the candidates.
+	irccon.RunCallbacks(&Event{Code: "433", Arguments: []string{"*", "Backup", "Nickname is already in use"}})
+	if got := nextRawCommand(t, irccon.pwrite); got != "NICK LongNicknam_\r\n" {
+		t.Fatalf("unexpected fallback before NICKLEN is known: %q", got)
+	}
+
+	irccon.RunCallbacks(&Event{Code: "001", Arguments: []string{"LongNicknam_", "Welcome"}})
+	irccon.RunCallbacks(&Event{Code: "005", Arguments: []string{"LongNicknam_", "NICKLEN=10", "are supported by this server"}})
+
+	irccon.AutoNickRecoveryPostRegistration = true
+	irccon.Nick("AnotherLongNick")
+	if got := nextRawCommand(t, irccon.pwrite); got != "NICK AnotherLongNick\r\n" {
+		t.Fatalf("unexpected nick change: %q", got)
+	}
+	irccon.RunCallbacks(&Event{Code: "433", Arguments: []string{"LongNicknam_", "AnotherLongNick", "Nickname is already in use"}})
+	if got := nextRawCommand(t, irccon.pwrite); got != "NICK Backup\r\n" {
+		t.Fatalf("expected attempts to restart after Nick(), got %q", got)
+	}
+	irccon.RunCallbacks(&Event{Code: "433", Arguments: []string{"LongNicknam_", "Backup", "Nickname is already in use"}})
+	if got := nextRawCommand(t, irccon.pwrite); got != "NICK AnotherLo_\r\n" {
+		t.Fatalf("expected NICKLEN-bounded fallback, got %q", got)
+	}
+}
+
+func TestDefaultAltNickStaysRFC(t *testing.T) {
+	irccon := IRC("LongNickname", "testuser")
+	irccon.pwrite = make(chan string, 1)
+	irccon.nickcurrent = "LongNickname"
+
+	irccon.RunCallbacks(&Event{Code: "433", Arguments: []string{"*", "LongNickname", "Nickname is already in use"}})
+	if got, want := nextRawCommand(t, irccon.pwrite), "NICK "+generateAlternativeNick("LongNickname")+"\r\n"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
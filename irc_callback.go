@@ -26,6 +26,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -41,22 +42,7 @@ type CallbackID struct {
 // To register a callback for all events, pass "*" as the event code.
 // This function returns the ID of the registered callback for later management.
 func (irc *Connection) AddCallback(eventcode string, callback func(*Event)) int {
-	eventcode = strings.ToUpper(eventcode)
-
-	irc.eventsMutex.Lock()
-	defer irc.eventsMutex.Unlock()
-
-	if irc.events == nil {
-		irc.events = make(map[string]map[int]func(*Event))
-	}
-
-	if _, ok := irc.events[eventcode]; !ok {
-		irc.events[eventcode] = make(map[int]func(*Event))
-	}
-	id := irc.idCounter
-	irc.idCounter++
-	irc.events[eventcode][id] = callback
-	return id
+	return irc.addCallback(eventcode, callback, callbackOptions{})
 }
 
 // RemoveCallback removes callback i (ID) from the given event code.
@@ -70,6 +56,9 @@ func (irc *Connection) RemoveCallback(eventcode string, i int) bool {
 	if event, ok := irc.events[eventcode]; ok {
 		if _, ok := event[i]; ok {
 			delete(event, i)
+			delete(irc.callbackOpts, i)
+			delete(irc.callbackPanics, i)
+			irc.callbackCache = nil
 			return true
 		}
 		irc.Log.Printf("Event found, but no callback found at id %d\n", i)
@@ -80,6 +69,16 @@ func (irc *Connection) RemoveCallback(eventcode string, i int) bool {
 	return false
 }
 
+// hasCallback reports whether callback id is still registered for
+// eventcode.
+func (irc *Connection) hasCallback(eventcode string, id int) bool {
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+
+	_, ok := irc.events[eventcode][id]
+	return ok
+}
+
 // ClearCallback removes all callbacks from a given event code.
 // It returns true if the given event code is found and cleared.
 func (irc *Connection) ClearCallback(eventcode string) bool {
@@ -88,8 +87,13 @@ func (irc *Connection) ClearCallback(eventcode string) bool {
 	irc.eventsMutex.Lock()
 	defer irc.eventsMutex.Unlock()
 
-	if _, ok := irc.events[eventcode]; ok {
+	if event, ok := irc.events[eventcode]; ok {
+		for id := range event {
+			delete(irc.callbackOpts, id)
+			delete(irc.callbackPanics, id)
+		}
 		irc.events[eventcode] = make(map[int]func(*Event))
+		irc.callbackCache = nil
 		return true
 	}
 
@@ -107,6 +111,18 @@ func (irc *Connection) ReplaceCallback(eventcode string, i int, callback func(*E
 	if event, ok := irc.events[eventcode]; ok {
 		if _, ok := event[i]; ok {
 			event[i] = callback
+			// The replacement is the caller's code, not a library
+			// handler or pattern matcher
+			if opts, ok := irc.callbackOpts[i]; ok {
+				opts.internal, opts.pattern = false, nil
+				if opts == (callbackOptions{}) {
+					delete(irc.callbackOpts, i)
+				} else {
+					irc.callbackOpts[i] = opts
+				}
+			}
+			delete(irc.callbackPanics, i)
+			irc.callbackCache = nil
 			return
 		}
 		irc.Log.Printf("Event found, but no callback found at id %d\n", i)
@@ -115,100 +131,162 @@ func (irc *Connection) ReplaceCallback(eventcode string, i int, callback func(*E
 	irc.Log.Printf("Event not found. Use AddCallback\n")
 }
 
-// RunCallbacks executes all callbacks associated with a given event.
+// RunCallbacks executes all callbacks associated with a given event,
+// after passing it through the event middleware.
 func (irc *Connection) RunCallbacks(event *Event) {
-	msg := event.Message()
-	if event.Code == "PRIVMSG" && len(msg) > 2 && msg[0] == '\x01' {
-		event.Code = "CTCP" // Unknown CTCP
+	if chain, _ := irc.middleware(); len(chain) > 0 {
+		irc.classifyCTCP(event)
+		runEventChain(chain, event, func(e *Event) { irc.runCallbacks(e) })
+		return
+	}
+	irc.runCallbacks(event)
+}
 
-		if i := strings.LastIndex(msg, "\x01"); i > 0 {
-			msg = msg[1:i]
-		} else {
-			irc.Log.Printf("Invalid CTCP Message: %s\n", strconv.Quote(msg))
-			return
-		}
+// runCallbacks is RunCallbacks reporting whether every callback returned,
+// i.e. whether nothing can still be using the event.
+func (irc *Connection) runCallbacks(event *Event) bool {
+	irc.classifyCTCP(event)
 
-		switch {
-		case msg == "VERSION":
-			event.Code = "CTCP_VERSION"
-		case msg == "TIME":
-			event.Code = "CTCP_TIME"
-		case strings.HasPrefix(msg, "PING"):
-			event.Code = "CTCP_PING"
-		case msg == "USERINFO":
-			event.Code = "CTCP_USERINFO"
-		case msg == "CLIENTINFO":
-			event.Code = "CTCP_CLIENTINFO"
-		case strings.HasPrefix(msg, "ACTION"):
-			event.Code = "CTCP_ACTION"
-			if len(msg) > 6 {
-				msg = msg[7:]
-			} else {
-				msg = ""
-			}
-		}
+	plan := irc.callbacksFor(event.Code)
 
-		event.Arguments[len(event.Arguments)-1] = msg
+	if irc.VerboseCallbackHandler {
+		irc.Log.Printf("%v (%v) >> %#v\n", eventCodeName(event.Code), plan.count, event)
 	}
 
-	irc.eventsMutex.Lock()
-	callbacks := make(map[int]func(*Event))
-	if eventCallbacks, ok := irc.events[event.Code]; ok {
-		for id, callback := range eventCallbacks {
-			callbacks[id] = callback
-		}
+	// Callback contexts derive from one set by middleware
+	parent := event.Ctx
+	if parent == nil {
+		parent = context.Background()
 	}
-	if allCallbacks, ok := irc.events["*"]; ok {
-		for id, callback := range allCallbacks {
-			callbacks[id] = callback
+
+	// Each step has its own CallbackTimeout. A step that timed out does not
+	// hold back the later ones; they get a copy of the event, since the
+	// callbacks still running keep using the original.
+	current, returned, stalled := event, true, false
+	step := func(callbacks []callbackEntry, concurrent bool) {
+		if len(callbacks) == 0 {
+			return
+		}
+		if stalled {
+			current, stalled = event.Clone(), false
+		}
+		ctx, cancel := parent, context.CancelFunc(nil)
+		if irc.CallbackTimeout != 0 {
+			ctx, cancel = context.WithTimeout(parent, irc.CallbackTimeout)
+			defer cancel()
+		}
+		current.Ctx = ctx
+		if !concurrent {
+			for _, callback := range callbacks {
+				irc.invokeCallback(callback, current)
+			}
+		} else if !irc.runConcurrently(current, callbacks) {
+			returned, stalled = false, true
 		}
 	}
-	irc.eventsMutex.Unlock()
+	step(plan.syncBefore, false)
+	step(plan.matching(event, plan.firstWave), true)
+	step(plan.syncAfter, false)
+	step(plan.after, true)
 
-	if irc.VerboseCallbackHandler {
-		irc.Log.Printf("%v (%v) >> %#v\n", event.Code, len(callbacks), event)
+	if !stalled {
+		current.Ctx = parent
 	}
+	return returned
+}
 
-	event.Ctx = context.Background()
-	if irc.CallbackTimeout != 0 {
-		var cancel context.CancelFunc
-		event.Ctx, cancel = context.WithTimeout(event.Ctx, irc.CallbackTimeout)
-		defer cancel()
+// runConcurrently runs callbacks in their own goroutines and waits for them
+// until event.Ctx is done. It reports whether they all returned.
+func (irc *Connection) runConcurrently(event *Event, callbacks []callbackEntry) bool {
+	ctx := event.Ctx
+	if irc.CallbackTimeout == 0 {
+		// Nothing to time out: run the last callback on this goroutine and
+		// the others concurrently.
+		var wg sync.WaitGroup
+		wg.Add(len(callbacks) - 1)
+		for _, callback := range callbacks[:len(callbacks)-1] {
+			go func(c callbackEntry) {
+				defer wg.Done()
+				irc.invokeCallback(c, event)
+			}(callback)
+		}
+		irc.invokeCallback(callbacks[len(callbacks)-1], event)
+		wg.Wait()
+		return true
 	}
 
-	done := make(chan int)
-	for id, callback := range callbacks {
-		go func(id int, done chan<- int, cb func(*Event), event *Event) {
+	done := make(chan int, len(callbacks))
+	for i, callback := range callbacks {
+		go func(i int, c callbackEntry) {
 			start := time.Now()
-			cb(event)
-			select {
-			case done <- id:
-			case <-event.Ctx.Done():
+			irc.invokeCallback(c, event)
+			if ctx.Err() != nil {
 				irc.Log.Printf("Canceled callback %s finished in %s >> %#v\n",
-					getFunctionName(cb),
+					getFunctionName(c.cb),
 					time.Since(start),
 					event,
 				)
 			}
-		}(id, done, callback, event)
+			done <- i
+		}(i, callback)
 	}
 
-	for len(callbacks) > 0 {
+	finished := make([]bool, len(callbacks))
+	for remaining := len(callbacks); remaining > 0; remaining-- {
 		select {
-		case jobID := <-done:
-			delete(callbacks, jobID)
-		case <-event.Ctx.Done():
+		case i := <-done:
+			finished[i] = true
+		case <-ctx.Done():
 			timedOutCallbacks := []string{}
-			for _, cb := range callbacks {
-				timedOutCallbacks = append(timedOutCallbacks, getFunctionName(cb))
+			for i, c := range callbacks {
+				if !finished[i] {
+					timedOutCallbacks = append(timedOutCallbacks, getFunctionName(c.cb))
+				}
 			}
 			irc.Log.Printf("Timeout while waiting for %d callback(s) to finish (%s)\n",
-				len(callbacks),
+				remaining,
 				strings.Join(timedOutCallbacks, ", "),
 			)
-			return
+			return false
+		}
+	}
+	return true
+}
+
+// classifyCTCP turns PRIVMSG and NOTICE events carrying CTCP into CTCP_*
+// and CTCPREPLY_* events, leaving the CTCP command and arguments (or just
+// the text for ACTION) as the last argument.
+func (irc *Connection) classifyCTCP(event *Event) {
+	if event.Code != "PRIVMSG" && event.Code != "NOTICE" {
+		return
+	}
+	command, args, ok := ParseCTCP(event.Message())
+	if !ok {
+		return
+	}
+	msg := command
+	if args != "" {
+		msg += " " + args
+	}
+
+	if event.Code == "NOTICE" {
+		// CTCP replies, e.g. the answer to our VERSION request
+		event.Code = "CTCPREPLY" // Unknown CTCP reply
+		if isCTCPCommandName(command) {
+			event.Code = "CTCPREPLY_" + command
+		}
+	} else {
+		event.Code = "CTCP" // Unknown CTCP
+		switch {
+		case command == "ACTION":
+			event.Code = "CTCP_ACTION"
+			msg = args
+		case irc.isKnownCTCP(command):
+			event.Code = "CTCP_" + command
 		}
 	}
+
+	event.Arguments[len(event.Arguments)-1] = msg
 }
 
 func getFunctionName(f func(*Event)) string {
@@ -217,50 +295,42 @@ func getFunctionName(f func(*Event)) string {
 
 // setupCallbacks sets up some initial callbacks to handle the IRC/CTCP protocol.
 func (irc *Connection) setupCallbacks() {
+	// Everything registered here is one of the library's own handlers
+	irc.eventsMutex.Lock()
 	irc.events = make(map[string]map[int]func(*Event))
+	irc.callbackOpts = nil
+	irc.callbackCache = nil
+	irc.setupInternal = true
+	irc.eventsMutex.Unlock()
+	defer func() {
+		irc.eventsMutex.Lock()
+		irc.setupInternal = false
+		irc.eventsMutex.Unlock()
+	}()
 
 	// Handle PING events
 	irc.AddCallback("PING", func(e *Event) {
-		irc.SendRaw("PONG :" + e.Message())
+		irc.sendText("PONG", e.Message())
 
 		// REMOVED: Activity-based connection detection (caused false positives in mass deployments)
 		// PING events alone don't guarantee full IRC registration completion
 	})
 
-	// Version handler
-	irc.AddCallback("CTCP_VERSION", func(e *Event) {
-		irc.SendRawf("NOTICE %s :\x01VERSION %s\x01", e.Nick, irc.Version)
-	})
-
-	// Userinfo handler
-	irc.AddCallback("CTCP_USERINFO", func(e *Event) {
-		irc.SendRawf("NOTICE %s :\x01USERINFO %s\x01", e.Nick, irc.user)
-	})
-
-	// Clientinfo handler
-	irc.AddCallback("CTCP_CLIENTINFO", func(e *Event) {
-		irc.SendRawf("NOTICE %s :\x01CLIENTINFO PING VERSION TIME USERINFO CLIENTINFO\x01", e.Nick)
-	})
-
-	// Time handler
-	irc.AddCallback("CTCP_TIME", func(e *Event) {
-		ltime := time.Now()
-		irc.SendRawf("NOTICE %s :\x01TIME %s\x01", e.Nick, ltime.String())
-	})
-
-	// Ping handler
-	irc.AddCallback("CTCP_PING", func(e *Event) {
-		irc.SendRawf("NOTICE %s :\x01%s\x01", e.Nick, e.Message())
-	})
+	// CTCP request handlers (VERSION, PING, TIME, ...), see HandleCTCP
+	irc.setupCTCPHandlers()
 
 	// Handle nickname in use (433) - RFC 2812 compliant
-	irc.AddCallback("433", func(e *Event) {
+	irc.AddCallback(ERR_NICKNAMEINUSE, func(e *Event) {
 		irc.Lock()
 		defer irc.Unlock()
 
 		// Track the error regardless of connection state
 		irc.nickError = "Nickname already in use"
 
+		if len(e.Arguments) > 1 && irc.regainRejectedLocked(e.Arguments[1]) {
+			return
+		}
+
 		if irc.fullyConnected && !irc.AutoNickRecoveryPostRegistration {
 			irc.stopNickRetryLocked()
 			return
@@ -274,7 +344,8 @@ func (irc *Connection) setupCallbacks() {
 			// Check if the error is for a nick we're trying to get
 			if ircNickEqual(attemptedNick, irc.nick) || ircNickEqual(attemptedNick, irc.nickPending) {
 				// Generate alternative based on the rejected nickname
-				alternative := generateAlternativeNick(attemptedNick)
+				irc.noteRegainFallbackLocked(attemptedNick)
+				alternative := irc.nextAltNickLocked(attemptedNick)
 				irc.nickPending = alternative
 				irc.nickChangeInProgress = true
 				irc.nickChangeTimeout = time.Now()
@@ -286,7 +357,7 @@ func (irc *Connection) setupCallbacks() {
 					irc.nickcurrent = alternative
 				}
 
-				irc.SendRawf("NICK %s", alternative)
+				irc.send("NICK", alternative)
 
 				if irc.Debug {
 					irc.Log.Printf("NICK 433 error for %s, trying %s (fullyConnected=%v)", attemptedNick, alternative, irc.fullyConnected)
@@ -296,13 +367,17 @@ func (irc *Connection) setupCallbacks() {
 	})
 
 	// Handle unavailable resource (437) - RFC 2812 compliant
-	irc.AddCallback("437", func(e *Event) {
+	irc.AddCallback(ERR_UNAVAILRESOURCE, func(e *Event) {
 		irc.Lock()
 		defer irc.Unlock()
 
 		// Track the error regardless of connection state
 		irc.nickError = "Nickname temporarily unavailable"
 
+		if len(e.Arguments) > 1 && irc.regainRejectedLocked(e.Arguments[1]) {
+			return
+		}
+
 		if irc.fullyConnected && !irc.AutoNickRecoveryPostRegistration {
 			irc.stopNickRetryLocked()
 			return
@@ -312,7 +387,8 @@ func (irc *Connection) setupCallbacks() {
 			attemptedNick := e.Arguments[1]
 
 			if ircNickEqual(attemptedNick, irc.nick) || ircNickEqual(attemptedNick, irc.nickPending) {
-				alternative := generateAlternativeNick(attemptedNick)
+				irc.noteRegainFallbackLocked(attemptedNick)
+				alternative := irc.nextAltNickLocked(attemptedNick)
 				irc.nickPending = alternative
 				irc.nickChangeInProgress = true
 				irc.nickChangeTimeout = time.Now()
@@ -322,7 +398,7 @@ func (irc *Connection) setupCallbacks() {
 					irc.nickcurrent = alternative
 				}
 
-				irc.SendRawf("NICK %s", alternative)
+				irc.send("NICK", alternative)
 
 				if irc.Debug {
 					irc.Log.Printf("NICK 437 error for %s, trying %s (fullyConnected=%v)", attemptedNick, alternative, irc.fullyConnected)
@@ -332,7 +408,7 @@ func (irc *Connection) setupCallbacks() {
 	})
 
 	// Handle no nickname given (431) - RFC 2812 compliant
-	irc.AddCallback("431", func(e *Event) {
+	irc.AddCallback(ERR_NONICKNAMEGIVEN, func(e *Event) {
 		irc.Lock()
 		defer irc.Unlock()
 
@@ -346,7 +422,7 @@ func (irc *Connection) setupCallbacks() {
 			irc.nickChangeInProgress = true
 			irc.nickChangeTimeout = time.Now()
 			irc.lastNickChange = time.Now()
-			irc.SendRawf("NICK %s", irc.nick)
+			irc.send("NICK", irc.nick)
 
 			if irc.Debug {
 				irc.Log.Printf("NICK 431 error, resending nick %s (fullyConnected=%v)", irc.nick, irc.fullyConnected)
@@ -355,7 +431,7 @@ func (irc *Connection) setupCallbacks() {
 	})
 
 	// Handle erroneous nickname (432) - RFC 2812 compliant
-	irc.AddCallback("432", func(e *Event) {
+	irc.AddCallback(ERR_ERRONEUSNICKNAME, func(e *Event) {
 		irc.Lock()
 		defer irc.Unlock()
 
@@ -372,7 +448,10 @@ func (irc *Connection) setupCallbacks() {
 			attemptedNick := e.Arguments[1]
 
 			if ircNickEqual(attemptedNick, irc.nick) || ircNickEqual(attemptedNick, irc.nickPending) || ircNickEqual(attemptedNick, irc.nickcurrent) {
-				alternative := generateAlternativeNick(attemptedNick)
+				if ircNickEqual(attemptedNick, irc.regainNick) {
+					irc.stopRegainLocked()
+				}
+				alternative := irc.nextAltNickLocked(attemptedNick)
 				// An erroneous nickname will never succeed, so stop retrying the invalid desired nick.
 				irc.nick = alternative
 				irc.nickPending = alternative
@@ -384,7 +463,7 @@ func (irc *Connection) setupCallbacks() {
 					irc.nickcurrent = alternative
 				}
 
-				irc.SendRawf("NICK %s", alternative)
+				irc.send("NICK", alternative)
 
 				if irc.Debug {
 					irc.Log.Printf("NICK 432 error for %s, trying %s (fullyConnected=%v)", attemptedNick, alternative, irc.fullyConnected)
@@ -394,13 +473,17 @@ func (irc *Connection) setupCallbacks() {
 	})
 
 	// Handle nickname collision (436) - RFC 2812 compliant
-	irc.AddCallback("436", func(e *Event) {
+	irc.AddCallback(ERR_NICKCOLLISION, func(e *Event) {
 		irc.Lock()
 		defer irc.Unlock()
 
 		// Track the error regardless of connection state
 		irc.nickError = "Nickname collision"
 
+		if len(e.Arguments) > 1 && irc.regainRejectedLocked(e.Arguments[1]) {
+			return
+		}
+
 		if irc.fullyConnected && !irc.AutoNickRecoveryPostRegistration {
 			irc.stopNickRetryLocked()
 			return
@@ -410,7 +493,8 @@ func (irc *Connection) setupCallbacks() {
 			attemptedNick := e.Arguments[1]
 
 			if ircNickEqual(attemptedNick, irc.nick) || ircNickEqual(attemptedNick, irc.nickPending) {
-				alternative := generateAlternativeNick(attemptedNick)
+				irc.noteRegainFallbackLocked(attemptedNick)
+				alternative := irc.nextAltNickLocked(attemptedNick)
 				irc.nickPending = alternative
 				irc.nickChangeInProgress = true
 				irc.nickChangeTimeout = time.Now()
@@ -420,7 +504,7 @@ func (irc *Connection) setupCallbacks() {
 					irc.nickcurrent = alternative
 				}
 
-				irc.SendRawf("NICK %s", alternative)
+				irc.send("NICK", alternative)
 
 				if irc.Debug {
 					irc.Log.Printf("NICK 436 error for %s, trying %s (fullyConnected=%v)", attemptedNick, alternative, irc.fullyConnected)
@@ -430,7 +514,7 @@ func (irc *Connection) setupCallbacks() {
 	})
 
 	// Handle restricted nickname (484) - RFC 2812 compliant
-	irc.AddCallback("484", func(e *Event) {
+	irc.AddCallback(ERR_RESTRICTED, func(e *Event) {
 		irc.Lock()
 		defer irc.Unlock()
 
@@ -490,6 +574,10 @@ func (irc *Connection) setupCallbacks() {
 				irc.lastNickChange = time.Now()
 				// Clear any nickname error since the change was successful
 				irc.nickError = ""
+				irc.altNickAttempt = 0
+				if irc.regainNick != "" && ircNickEqual(newNick, irc.regainNick) {
+					irc.stopRegainLocked()
+				}
 
 				if irc.Debug {
 					irc.Log.Printf("NICK change confirmed: %s -> %s (fullyConnected=%v)", e.Nick, newNick, irc.fullyConnected)
@@ -500,106 +588,77 @@ func (irc *Connection) setupCallbacks() {
 
 	// Set fullyConnected to true on successful connection (001)
 	// This is the server welcome message that confirms our connection and nickname
-	irc.AddCallback("001", func(e *Event) {
-		irc.Lock()
-		// The first argument contains our confirmed nickname
-		irc.nickcurrent = e.Arguments[0]
-		// Also update the desired nickname to match what the server confirmed
-		irc.nick = e.Arguments[0]
-		irc.nickPending = ""
-		irc.nickChangeInProgress = false
-		// Mark the connection as fully established
-		irc.markFullyConnectedLocked()
-		// Update the last nickname change time
-		irc.lastNickChange = time.Now()
-		// Clear any nickname error since we're successfully connected
-		irc.nickError = ""
-		// Start registration process tracking
-		irc.registrationSteps = 1
-		irc.registrationStartTime = time.Now()
-		irc.Unlock()
+	irc.AddCallback(RPL_WELCOME, func(e *Event) {
+		if irc.welcome(e) {
+			irc.emitRegistered()
+		}
 	})
 
 	// Handle server pacing notice (some networks use 020)
-	irc.AddCallback("020", func(e *Event) {
+	irc.AddCallback(RPL_HELLO, func(e *Event) {
 		if irc.Respect020Pacing {
 			irc.Lock()
+			defer irc.Unlock()
 			irc.got020 = true
 			irc.last020 = time.Now()
-			irc.Unlock()
 		}
 	})
 
 	// Handle RPL_YOURHOST (002)
-	irc.AddCallback("002", func(e *Event) {
+	irc.AddCallback(RPL_YOURHOST, func(e *Event) {
 		irc.Lock()
+		defer irc.Unlock()
 		if !irc.fullyConnected && irc.registrationSteps > 0 {
 			irc.registrationSteps++
 		} else if irc.registrationSteps > 0 {
 			// If we're already fully connected, ensure it stays that way
 			irc.markFullyConnectedLocked()
 		}
-		irc.Unlock()
 	})
 
 	// Handle RPL_CREATED (003)
-	irc.AddCallback("003", func(e *Event) {
+	irc.AddCallback(RPL_CREATED, func(e *Event) {
 		irc.Lock()
+		defer irc.Unlock()
 		if !irc.fullyConnected && irc.registrationSteps > 0 {
 			irc.registrationSteps++
 		} else if irc.registrationSteps > 0 {
 			// If we're already fully connected, ensure it stays that way
 			irc.markFullyConnectedLocked()
 		}
-		irc.Unlock()
 	})
 
 	// Handle RPL_MYINFO (004)
-	irc.AddCallback("004", func(e *Event) {
+	irc.AddCallback(RPL_MYINFO, func(e *Event) {
 		irc.Lock()
+		defer irc.Unlock()
 		if !irc.fullyConnected && irc.registrationSteps > 0 {
 			irc.registrationSteps++
 		} else if irc.registrationSteps > 0 {
 			// If we're already fully connected, ensure it stays that way
 			irc.markFullyConnectedLocked()
 		}
-		irc.Unlock()
 	})
 
 	// Handle RPL_ISUPPORT (005)
-	irc.AddCallback("005", func(e *Event) {
-		irc.Lock()
-		if !irc.fullyConnected && irc.registrationSteps > 0 {
-			irc.registrationSteps++
-			// If we've received enough registration messages, mark as fully connected
-			if irc.registrationSteps >= 4 {
-				irc.markFullyConnectedLocked()
-			}
-		} else if irc.registrationSteps > 0 {
-			// If we're already fully connected, ensure it stays that way
-			irc.markFullyConnectedLocked()
+	irc.AddCallback(RPL_ISUPPORT, func(e *Event) {
+		if irc.isupportReceived(e) {
+			irc.emitRegistered()
 		}
-		irc.Unlock()
 	})
 
 	// Handle RPL_ENDOFMOTD (376) - End of MOTD
-	irc.AddCallback("376", func(e *Event) {
-		irc.Lock()
-		// If we've started registration but aren't fully connected yet
-		if !irc.fullyConnected && irc.registrationSteps > 0 {
-			irc.markFullyConnectedLocked()
+	irc.AddCallback(RPL_ENDOFMOTD, func(e *Event) {
+		if irc.motdEnded() {
+			irc.emitRegistered()
 		}
-		irc.Unlock()
 	})
 
 	// Handle ERR_NOMOTD (422) - No MOTD
-	irc.AddCallback("422", func(e *Event) {
-		irc.Lock()
-		// If we've started registration but aren't fully connected yet
-		if !irc.fullyConnected && irc.registrationSteps > 0 {
-			irc.markFullyConnectedLocked()
+	irc.AddCallback(ERR_NOMOTD, func(e *Event) {
+		if irc.motdEnded() {
+			irc.emitRegistered()
 		}
-		irc.Unlock()
 	})
 	// Handle JOIN events
 	irc.AddCallback("JOIN", func(e *Event) {
@@ -625,11 +684,95 @@ func (irc *Connection) setupCallbacks() {
 	// DCC Chat support
 	irc.addDCCChatCallback()
 
+	// MONITOR/ISON presence tracking
+	irc.setupMonitorCallbacks()
+
+	// Nick regain after falling back to an alternative nick
+	irc.setupRegainCallbacks()
+
+	// WhoisSync reply aggregation
+	irc.setupWhoisCallbacks()
+
+	// WhoSync/WhoStream (WHOX) reply routing
+	irc.setupWhoCallbacks()
+
+	// List channel listing
+	irc.setupListCallbacks()
+
+	// CTCP request/reply correlation
+	irc.setupCTCPRequestCallbacks()
+
+	// IRCv3 FAIL replies for pending requests
+	irc.setupStandardReplyCallbacks()
+}
+
+// welcome records our confirmed nickname from RPL_WELCOME (001) and
+// marks the connection as fully established. It reports whether the
+// connection was not registered before.
+func (irc *Connection) welcome(e *Event) bool {
+	irc.Lock()
+	defer irc.Unlock()
+
+	// The first argument contains our confirmed nickname
+	if len(e.Arguments) > 0 && e.Arguments[0] != "" {
+		irc.nickcurrent = e.Arguments[0]
+		// Also update the desired nickname to match what the server confirmed
+		irc.nick = e.Arguments[0]
+	}
+	irc.nickPending = ""
+	irc.nickChangeInProgress = false
+	// Mark the connection as fully established
+	registered := irc.markFullyConnectedLocked()
+	// Update the last nickname change time
+	irc.lastNickChange = time.Now()
+	// Clear any nickname error since we're successfully connected
+	irc.nickError = ""
+	irc.altNickAttempt = 0
+	if irc.regainNick != "" && ircNickEqual(irc.nickcurrent, irc.regainNick) {
+		irc.stopRegainLocked()
+	}
+	// Start registration process tracking
+	irc.registrationSteps = 1
+	irc.registrationStartTime = time.Now()
+	return registered
+}
+
+// isupportReceived applies RPL_ISUPPORT (005) and counts it as a
+// registration step. It reports whether that completed the registration.
+func (irc *Connection) isupportReceived(e *Event) bool {
+	irc.Lock()
+	defer irc.Unlock()
+
+	irc.parseISupportLocked(e)
+	if !irc.fullyConnected && irc.registrationSteps > 0 {
+		irc.registrationSteps++
+		// If we've received enough registration messages, mark as fully connected
+		if irc.registrationSteps >= 4 {
+			return irc.markFullyConnectedLocked()
+		}
+	} else if irc.registrationSteps > 0 {
+		// If we're already fully connected, ensure it stays that way
+		irc.markFullyConnectedLocked()
+	}
+	return false
+}
+
+// motdEnded handles the end of the MOTD (376 or 422). It reports whether
+// that completed the registration.
+func (irc *Connection) motdEnded() bool {
+	irc.Lock()
+	defer irc.Unlock()
+
+	// If we've started registration but aren't fully connected yet
+	if !irc.fullyConnected && irc.registrationSteps > 0 {
+		return irc.markFullyConnectedLocked()
+	}
+	return false
 }
 
 // modifyNick modifies the current nickname to try a different one.
 // DEPRECATED: This function is kept for backward compatibility but should not be used.
-// Use generateAlternativeNick instead.
+// Use nextAltNickLocked instead.
 func (irc *Connection) modifyNick() {
 	if len(irc.nickcurrent) > 8 {
 		irc.nickcurrent = "_" + irc.nickcurrent
@@ -708,10 +851,16 @@ func isRFCNickChar(ch byte) bool {
 }
 
 func sanitizeRFCNick(nick string) string {
+	return sanitizeNick(nick, maxRFCNickLen)
+}
+
+// sanitizeNick drops characters not allowed in RFC 2812 nicknames and cuts
+// the result to maxLen bytes.
+func sanitizeNick(nick string, maxLen int) string {
 	var b strings.Builder
-	b.Grow(maxRFCNickLen)
+	b.Grow(maxLen)
 
-	for i := 0; i < len(nick) && b.Len() < maxRFCNickLen; i++ {
+	for i := 0; i < len(nick) && b.Len() < maxLen; i++ {
 		ch := nick[i]
 
 		if b.Len() == 0 {
@@ -721,7 +870,7 @@ func sanitizeRFCNick(nick string) string {
 			}
 			if isRFCNickChar(ch) {
 				b.WriteByte('_')
-				if b.Len() < maxRFCNickLen {
+				if b.Len() < maxLen {
 					b.WriteByte(ch)
 				}
 			}
@@ -741,17 +890,23 @@ func sanitizeRFCNick(nick string) string {
 }
 
 func buildRFCNickCandidate(baseNick, suffix string) string {
+	return buildNickCandidate(baseNick, suffix, maxRFCNickLen)
+}
+
+// buildNickCandidate appends suffix to baseNick, shortening baseNick so the
+// result fits in maxLen bytes.
+func buildNickCandidate(baseNick, suffix string, maxLen int) string {
 	if suffix == "" {
 		suffix = "_"
 	}
-	if len(suffix) >= maxRFCNickLen {
-		suffix = suffix[:maxRFCNickLen-1]
+	if len(suffix) >= maxLen {
+		suffix = suffix[:maxLen-1]
 	}
 
-	room := maxRFCNickLen - len(suffix)
+	room := maxLen - len(suffix)
 	if room < 1 {
 		room = 1
-		suffix = suffix[:maxRFCNickLen-1]
+		suffix = suffix[:maxLen-1]
 	}
 
 	prefix := baseNick
@@ -762,12 +917,12 @@ func buildRFCNickCandidate(baseNick, suffix string) string {
 	candidate := prefix + suffix
 	if !isRFCNickFirstChar(candidate[0]) {
 		candidate = "_" + candidate
-		if len(candidate) > maxRFCNickLen {
-			candidate = candidate[:maxRFCNickLen]
+		if len(candidate) > maxLen {
+			candidate = candidate[:maxLen]
 		}
 	}
 
-	return sanitizeRFCNick(candidate)
+	return sanitizeNick(candidate, maxLen)
 }
 
 // DCC chat support
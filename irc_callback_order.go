@@ -0,0 +1,185 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"sort"
+	"strings"
+)
+
+// CallbackOptions controls when a callback registered with
+// AddCallbackWithOptions runs relative to the other callbacks of an event.
+//
+// The callbacks of an event run in this order:
+//
+//  1. Sync callbacks, one after another by Priority
+//  2. the library's own handlers and plain callbacks, concurrently
+//  3. Sync callbacks with AfterInternal, one after another by Priority
+//  4. other AfterInternal callbacks, concurrently
+//
+// Each step waits for the previous one, for at most CallbackTimeout: every
+// step has its own timeout and runs even if the previous one timed out.
+type CallbackOptions struct {
+	// Priority orders callbacks that run one after another: Sync callbacks,
+	// and all callbacks handled by a DispatchWorkers worker. Higher values
+	// run first; equal priorities run in registration order.
+	Priority int
+
+	// Sync runs the callback on the goroutine reading from the server,
+	// before the next line is read. A Sync callback must not wait for later
+	// events (WhoisSync, WhoSync, CTCP, ...): they cannot arrive until it
+	// returns.
+	Sync bool
+
+	// AfterInternal runs the callback only after the library's own handlers
+	// for the event have returned, so that e.g. GetNick() in a 001 or NICK
+	// callback already reflects the event.
+	AfterInternal bool
+}
+
+type callbackOptions struct {
+	CallbackOptions
+	internal bool             // registered by the library itself
+	pattern  *patternCallback // registered with AddPatternCallback
+}
+
+// AddCallbackWithOptions is AddCallback with control over ordering, see
+// CallbackOptions.
+func (irc *Connection) AddCallbackWithOptions(eventcode string, callback func(*Event), opts CallbackOptions) int {
+	return irc.addCallback(eventcode, callback, callbackOptions{CallbackOptions: opts})
+}
+
+// addInternalCallback registers one of the library's own handlers outside
+// setupCallbacks, e.g. during CAP negotiation.
+func (irc *Connection) addInternalCallback(eventcode string, callback func(*Event)) int {
+	return irc.addCallback(eventcode, callback, callbackOptions{internal: true})
+}
+
+func (irc *Connection) addCallback(eventcode string, callback func(*Event), opts callbackOptions) int {
+	eventcode = strings.ToUpper(eventcode)
+
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+
+	if irc.events == nil {
+		irc.events = make(map[string]map[int]func(*Event))
+	}
+
+	if _, ok := irc.events[eventcode]; !ok {
+		irc.events[eventcode] = make(map[int]func(*Event))
+	}
+	if irc.setupInternal {
+		opts.internal = true
+	}
+	id := irc.idCounter
+	irc.idCounter++
+	irc.events[eventcode][id] = callback
+	if opts != (callbackOptions{}) {
+		if irc.callbackOpts == nil {
+			irc.callbackOpts = make(map[int]callbackOptions)
+		}
+		irc.callbackOpts[id] = opts
+	}
+	irc.callbackCache = nil
+	return id
+}
+
+// callbackEntry is a registered callback as seen by RunCallbacks.
+type callbackEntry struct {
+	id       int
+	code     string // event code it was registered for, possibly "*"
+	cb       func(*Event)
+	internal bool
+	pattern  *patternCallback // run only for matching events, see matching
+}
+
+// callbackPlan is the callbacks of one event code, including the "*"
+// callbacks, sorted into the steps described at CallbackOptions.
+type callbackPlan struct {
+	count int // all callbacks, for the verbose log
+
+	syncBefore []callbackEntry // Sync
+	internal   []callbackEntry // the library's own handlers
+	plain      []callbackEntry // no options, or only a Priority
+	firstWave  []callbackEntry // internal and plain together
+	syncAfter  []callbackEntry // Sync and AfterInternal
+	after      []callbackEntry // AfterInternal
+
+	patterns bool // plain holds pattern callbacks
+}
+
+// callbacksFor returns the plan for code. It is cached until the callbacks
+// change and must not be modified.
+func (irc *Connection) callbacksFor(code string) *callbackPlan {
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+
+	// Codes without callbacks of their own share the "*" entry, so lines
+	// with arbitrary commands cannot grow the cache.
+	key := code
+	if len(irc.events[code]) == 0 {
+		key = "*"
+	}
+	if plan, ok := irc.callbackCache[key]; ok {
+		return plan
+	}
+
+	var entries []callbackEntry
+	for _, code := range []string{key, "*"} {
+		for id, cb := range irc.events[code] {
+			opts := irc.callbackOpts[id]
+			entries = append(entries, callbackEntry{id, code, cb, opts.internal, opts.pattern})
+		}
+		if key == "*" {
+			break
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		pi, pj := irc.callbackOpts[entries[i].id].Priority, irc.callbackOpts[entries[j].id].Priority
+		if pi != pj {
+			return pi > pj
+		}
+		return entries[i].id < entries[j].id
+	})
+
+	plan := &callbackPlan{count: len(entries)}
+	for _, e := range entries {
+		opts := irc.callbackOpts[e.id]
+		switch {
+		case opts.internal:
+			plan.internal = append(plan.internal, e)
+		case opts.Sync && opts.AfterInternal:
+			plan.syncAfter = append(plan.syncAfter, e)
+		case opts.Sync:
+			plan.syncBefore = append(plan.syncBefore, e)
+		case opts.AfterInternal:
+			plan.after = append(plan.after, e)
+		default:
+			plan.plain = append(plan.plain, e)
+			plan.patterns = plan.patterns || e.pattern != nil
+		}
+	}
+	plan.firstWave = append(append(plan.firstWave, plan.internal...), plan.plain...)
+
+	if irc.callbackCache == nil {
+		irc.callbackCache = make(map[string]*callbackPlan)
+	}
+	irc.callbackCache[key] = plan
+	return plan
+}
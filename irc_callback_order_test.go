@@ -0,0 +1,143 @@
+package irc
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAfterInternalSeesUpdatedState(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 100)
+
+	seen := make(chan string, 1)
+	irccon.AddCallbackWithOptions(RPL_WELCOME, func(e *Event) {
+		seen <- irccon.GetNick()
+	}, CallbackOptions{AfterInternal: true})
+
+	for i := 0; i < 50; i++ {
+		nick := fmt.Sprintf("nick%d", i)
+		feedLines(t, irccon, ":srv 001 "+nick+" :Welcome")
+		if got := <-seen; got != nick {
+			t.Fatalf("AfterInternal 001 callback saw GetNick() = %q, want %q", got, nick)
+		}
+	}
+}
+
+func TestCallbackOptionsOrder(t *testing.T) {
+	irccon := IRC("me", "testuser")
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string, delay time.Duration) func(*Event) {
+		return func(*Event) {
+			time.Sleep(delay)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+
+	irccon.AddCallbackWithOptions("FOO", record("after", 0), CallbackOptions{AfterInternal: true})
+	irccon.AddCallbackWithOptions("FOO", record("sync-after", 0), CallbackOptions{Sync: true, AfterInternal: true})
+	irccon.AddCallback("FOO", record("plain", 20*time.Millisecond))
+	irccon.AddCallbackWithOptions("FOO", record("sync-low", 0), CallbackOptions{Sync: true})
+	irccon.AddCallbackWithOptions("*", record("sync-high", 0), CallbackOptions{Sync: true, Priority: 10})
+	irccon.AddCallbackWithOptions("FOO", record("sync-low-2", 0), CallbackOptions{Sync: true})
+
+	feedLines(t, irccon, ":srv FOO me")
+
+	want := []string{"sync-high", "sync-low", "sync-low-2", "plain", "sync-after", "after"}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+}
+
+func TestCallbackOptionsRemoved(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	id := irccon.AddCallbackWithOptions("FOO", func(*Event) {}, CallbackOptions{Sync: true})
+	irccon.AddCallbackWithOptions("BAR", func(*Event) {}, CallbackOptions{Priority: 1})
+	userOpts := func() int {
+		n := 0
+		for _, opts := range irccon.callbackOpts {
+			if !opts.internal {
+				n++
+			}
+		}
+		return n
+	}
+	if n := userOpts(); n != 2 {
+		t.Fatalf("%d user callback options recorded, want 2", n)
+	}
+
+	irccon.RemoveCallback("FOO", id)
+	irccon.ClearCallback("BAR")
+	if n := userOpts(); n != 0 {
+		t.Fatalf("%d user callback options kept after removal", n)
+	}
+}
+
+func TestDispatcherRunsInternalHandlersInOrder(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 100)
+	irccon.DispatchWorkers = 4
+
+	handled := make(chan struct{}, 100)
+	irccon.AddCallbackWithOptions("NICK", func(*Event) {
+		handled <- struct{}{}
+	}, CallbackOptions{AfterInternal: true})
+	server := startReadLoop(t, irccon)
+
+	lines := []byte(":srv 001 me :Welcome\r\n")
+	prev := "me"
+	for i := 0; i < 20; i++ {
+		next := fmt.Sprintf("n%d", i)
+		lines = fmt.Appendf(lines, ":%s!u@h NICK %s\r\n", prev, next)
+		prev = next
+	}
+	go server.Write(lines)
+
+	for i := 0; i < 20; i++ {
+		select {
+		case <-handled:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d NICK events handled", i)
+		}
+	}
+	// Our own NICK handler ran on the read loop for every line, in order
+	if nick := irccon.GetNick(); nick != "n19" {
+		t.Fatalf("GetNick() = %q after the NICK sequence, want n19", nick)
+	}
+}
+
+func TestLaterStepsRunAfterTimeout(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.CallbackTimeout = 50 * time.Millisecond
+
+	release := make(chan struct{})
+	defer close(release)
+	irccon.AddCallback("FOO", func(*Event) { <-release })
+
+	after := make(chan error, 2)
+	irccon.AddCallbackWithOptions("FOO", func(e *Event) {
+		after <- e.Ctx.Err()
+	}, CallbackOptions{Sync: true, AfterInternal: true})
+	irccon.AddCallbackWithOptions("FOO", func(e *Event) {
+		after <- e.Ctx.Err()
+	}, CallbackOptions{AfterInternal: true})
+
+	feedLines(t, irccon, ":srv FOO")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-after:
+			if err != nil {
+				t.Fatalf("AfterInternal callback got an expired context: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("AfterInternal callbacks were skipped after a timeout")
+		}
+	}
+}
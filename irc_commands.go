@@ -0,0 +1,766 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors passed to CommandRouter.OnError when a command is not run.
+var (
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrCommandCooldown  = errors.New("command on cooldown")
+	ErrCommandUsage     = errors.New("usage")
+)
+
+// Command is a bot command registered with CommandRouter.Register.
+type Command struct {
+	Name    string   // first word after the prefix, matched case-insensitively
+	Aliases []string // other names for the command
+	Usage   string   // argument synopsis for help, e.g. "<nick> [reason]"
+	Help    string   // one-line description for help
+
+	// MinArgs is the number of arguments Run needs; with fewer the command
+	// fails with ErrCommandUsage.
+	MinArgs int
+
+	// Cooldown is the time a user has to wait between two uses of the
+	// command. Users are told apart by account, or by user@host.
+	Cooldown time.Duration
+
+	// Permission decides who may run the command and its subcommands;
+	// nil allows everyone.
+	Permission Permission
+
+	// Run handles the command; an error is passed to CommandRouter.OnError.
+	// A command without Run shows its help, e.g. to list its subcommands.
+	Run func(c *CommandContext) error
+
+	// Subcommands are matched against the first argument. Arguments that
+	// match no subcommand are passed to Run.
+	Subcommands []*Command
+}
+
+// CommandContext is one invocation of a command. The embedded Event is the
+// PRIVMSG carrying it.
+type CommandContext struct {
+	*Event
+	Router  *CommandRouter
+	Command *Command
+	Name    string   // full command name, e.g. "admin ban"
+	Prefix  string   // prefix used, empty for nick highlights and queries
+	Args    []string // arguments, with quotes removed
+	RawArgs string   // arguments as typed
+}
+
+// Permission reports whether the sender of a command may run it.
+type Permission func(c *CommandContext) bool
+
+// AllowHostmask allows senders matching any of the nick!user@host masks.
+func AllowHostmask(masks ...string) Permission {
+	return func(c *CommandContext) bool {
+		for _, mask := range masks {
+			if wildcardMatch(mask, c.Source) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AllowAccount allows senders logged in to any of the services accounts,
+// as reported by the IRCv3 account-tag.
+func AllowAccount(accounts ...string) Permission {
+	return func(c *CommandContext) bool {
+		account := c.Account()
+		if account == "" {
+			return false
+		}
+		for _, allowed := range accounts {
+			if ircNickEqual(account, allowed) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AllowChannelMode allows senders having mode, or a higher membership mode
+// from PREFIX, in the channel the command was sent to: AllowChannelMode('o')
+// allows operators and above. Commands sent in private are refused.
+func AllowChannelMode(mode byte) Permission {
+	return func(c *CommandContext) bool {
+		if !c.IsChannelMessage() {
+			return false
+		}
+		return c.Router.members.hasMode(c.Connection.stripStatusPrefix(c.Target()), c.Nick, mode)
+	}
+}
+
+// AnyPermission allows senders allowed by any of perms.
+func AnyPermission(perms ...Permission) Permission {
+	return func(c *CommandContext) bool {
+		for _, perm := range perms {
+			if perm(c) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// CommandRouter runs the commands sent to the bot in PRIVMSG. Create it
+// before joining channels, so AllowChannelMode sees the NAMES replies:
+//
+//	router := irc.NewCommandRouter(conn)
+//	router.Register(&irc.Command{
+//		Name:    "seen",
+//		Usage:   "<nick>",
+//		Help:    "Tell when nick was last seen",
+//		MinArgs: 1,
+//		Run: func(c *irc.CommandContext) error {
+//			return c.Reply(lastSeen(c.Args[0]))
+//		},
+//	})
+//
+// A help command listing the commands the sender may run is registered
+// by default.
+type CommandRouter struct {
+	// Prefixes start a command in a channel, e.g. "!" for "!seen bob".
+	Prefixes []string
+
+	// NickPrefix accepts commands addressed to the bot, "bot: seen bob".
+	NickPrefix bool
+
+	// Private accepts commands without a prefix in private messages.
+	Private bool
+
+	// OnError is called when a command fails or is refused. The default
+	// sends the error to the sender as a NOTICE, at most one every
+	// errorNoticeInterval per user, and ignores ErrCommandCooldown so
+	// that nobody can make the bot flood.
+	OnError func(c *CommandContext, err error)
+
+	conn      *Connection
+	members   *channelMembers
+	callbacks []CallbackID // removed by Close
+
+	mu        sync.Mutex
+	order     []*commandNode          // top-level commands in registration order
+	commands  map[string]*commandNode // by lowercase name and alias
+	cooldowns map[string]time.Time    // by command and sender, see throttle
+}
+
+// errorNoticeInterval is how often the default OnError notices a user.
+const errorNoticeInterval = 5 * time.Second
+
+type commandNode struct {
+	cmd      *Command
+	order    []*commandNode
+	children map[string]*commandNode
+}
+
+// NewCommandRouter returns a router for the commands received by conn,
+// accepting the "!" prefix, nick highlights and private messages.
+func NewCommandRouter(conn *Connection) *CommandRouter {
+	r := &CommandRouter{
+		Prefixes:   []string{"!"},
+		NickPrefix: true,
+		Private:    true,
+		conn:       conn,
+		members:    newChannelMembers(conn),
+		commands:   make(map[string]*commandNode),
+		cooldowns:  make(map[string]time.Time),
+	}
+	r.Register(&Command{
+		Name:  "help",
+		Usage: "[command]",
+		Help:  "List the commands or describe one",
+		Run:   r.help,
+	})
+	r.callbacks = append([]CallbackID{{EventCode: "PRIVMSG", ID: conn.AddCallback("PRIVMSG", r.handle)}},
+		r.members.callbacks...)
+	return r
+}
+
+// Close removes the router's callbacks from its connection, so that it
+// no longer runs commands or tracks channel members. It can be called
+// more than once.
+func (r *CommandRouter) Close() {
+	r.mu.Lock()
+	callbacks := r.callbacks
+	r.callbacks = nil
+	r.mu.Unlock()
+
+	for _, callback := range callbacks {
+		r.conn.RemoveCallback(callback.EventCode, callback.ID)
+	}
+}
+
+// Register adds cmd and its subcommands, replacing a command with the same
+// name. Later changes to cmd need another Register.
+func (r *CommandRouter) Register(cmd *Command) error {
+	node, err := newCommandNode(cmd)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.order = replaceCommandNode(append([]*commandNode(nil), r.order...), node)
+	r.commands = indexCommandNodes(r.order)
+	return nil
+}
+
+// Unregister removes the command called name. It reports whether there
+// was one.
+func (r *CommandRouter) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, node := range r.order {
+		if strings.EqualFold(node.cmd.Name, name) {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			r.commands = indexCommandNodes(r.order)
+			return true
+		}
+	}
+	return false
+}
+
+func newCommandNode(cmd *Command) (*commandNode, error) {
+	if cmd.Name == "" || strings.ContainsAny(cmd.Name, " \t") {
+		return nil, fmt.Errorf("irc: invalid command name %q", cmd.Name)
+	}
+	node := &commandNode{cmd: cmd}
+	for _, sub := range cmd.Subcommands {
+		child, err := newCommandNode(sub)
+		if err != nil {
+			return nil, err
+		}
+		node.order = replaceCommandNode(node.order, child)
+	}
+	node.children = indexCommandNodes(node.order)
+	return node, nil
+}
+
+func replaceCommandNode(nodes []*commandNode, node *commandNode) []*commandNode {
+	for i, n := range nodes {
+		if strings.EqualFold(n.cmd.Name, node.cmd.Name) {
+			nodes[i] = node
+			return nodes
+		}
+	}
+	return append(nodes, node)
+}
+
+// indexCommandNodes maps names and aliases to commands; names take
+// precedence over aliases.
+func indexCommandNodes(nodes []*commandNode) map[string]*commandNode {
+	index := make(map[string]*commandNode)
+	for _, node := range nodes {
+		index[strings.ToLower(node.cmd.Name)] = node
+	}
+	for _, node := range nodes {
+		for _, alias := range node.cmd.Aliases {
+			if _, ok := index[strings.ToLower(alias)]; !ok {
+				index[strings.ToLower(alias)] = node
+			}
+		}
+	}
+	return index
+}
+
+func (r *CommandRouter) handle(e *Event) {
+	if e.Self {
+		return
+	}
+	text, prefix, ok := r.commandText(e)
+	if !ok {
+		return
+	}
+	c := &CommandContext{Event: e, Router: r, Prefix: prefix}
+	words, offsets, err := splitCommandArgs(text)
+	if err != nil {
+		r.fail(c, fmt.Errorf("%w: %v", ErrCommandUsage, err))
+		return
+	}
+	if len(words) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	index := r.commands
+	r.mu.Unlock()
+
+	var path []*commandNode
+	for len(path) < len(words) {
+		node := index[strings.ToLower(words[len(path)])]
+		if node == nil {
+			break
+		}
+		path = append(path, node)
+		index = node.children
+	}
+	if len(path) == 0 {
+		return
+	}
+
+	node := path[len(path)-1]
+	c.Command = node.cmd
+	c.Name = commandPathName(path)
+	c.Args = words[len(path):]
+	if len(path) < len(words) {
+		c.RawArgs = text[offsets[len(path)]:]
+	}
+
+	for _, n := range path {
+		if n.cmd.Permission != nil && !n.cmd.Permission(c) {
+			r.fail(c, ErrPermissionDenied)
+			return
+		}
+	}
+	if node.cmd.Run == nil {
+		r.showHelp(c, path)
+		return
+	}
+	if len(c.Args) < node.cmd.MinArgs {
+		r.fail(c, fmt.Errorf("%w: %s", ErrCommandUsage, r.synopsis(path)))
+		return
+	}
+	if wait := r.cooldown(c); wait > 0 {
+		r.fail(c, fmt.Errorf("%w: try again in %s", ErrCommandCooldown, wait.Round(time.Second)))
+		return
+	}
+	if err := node.cmd.Run(c); err != nil {
+		r.fail(c, err)
+	}
+}
+
+// commandText returns the command part of a message and the prefix used,
+// or false when the message is not a command.
+func (r *CommandRouter) commandText(e *Event) (text, prefix string, ok bool) {
+	if len(e.Arguments) < 2 {
+		return "", "", false
+	}
+	msg := strings.TrimSpace(e.Message())
+	for _, p := range r.Prefixes {
+		if p != "" && strings.HasPrefix(msg, p) {
+			return msg[len(p):], p, true
+		}
+	}
+	if r.NickPrefix {
+		nick := r.conn.GetNick()
+		if nick != "" && len(msg) > len(nick) && ircNickEqual(msg[:len(nick)], nick) {
+			if rest := msg[len(nick):]; rest[0] == ':' || rest[0] == ',' {
+				return rest[1:], "", true
+			}
+		}
+	}
+	if r.Private && !e.IsChannelMessage() {
+		return msg, "", true
+	}
+	return "", "", false
+}
+
+func (r *CommandRouter) fail(c *CommandContext, err error) {
+	if r.OnError != nil {
+		r.OnError(c, err)
+		return
+	}
+	if errors.Is(err, ErrCommandCooldown) || r.throttle(" notice", c, errorNoticeInterval) > 0 {
+		return
+	}
+	r.conn.Notice(c.Nick, err.Error())
+}
+
+// cooldown starts the cooldown of the command for the sender, or returns
+// the time left of a running one.
+func (r *CommandRouter) cooldown(c *CommandContext) time.Duration {
+	if c.Command.Cooldown <= 0 {
+		return 0
+	}
+	return r.throttle(c.Name, c, c.Command.Cooldown)
+}
+
+// throttle starts a wait of d for name and the sender, or returns the
+// time left of a running one.
+func (r *CommandRouter) throttle(name string, c *CommandContext, d time.Duration) time.Duration {
+	who := c.Account()
+	if who == "" {
+		who = c.User + "@" + c.Host
+	}
+	key := name + " " + strings.ToLower(who)
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if until, ok := r.cooldowns[key]; ok && now.Before(until) {
+		return until.Sub(now)
+	}
+	if len(r.cooldowns) >= 1024 {
+		for k, until := range r.cooldowns {
+			if !now.Before(until) {
+				delete(r.cooldowns, k)
+			}
+		}
+	}
+	r.cooldowns[key] = now.Add(d)
+	return 0
+}
+
+// help is the Run function of the default help command.
+func (r *CommandRouter) help(c *CommandContext) error {
+	r.mu.Lock()
+	nodes, index := r.order, r.commands
+	r.mu.Unlock()
+
+	if len(c.Args) == 0 {
+		r.conn.Notice(c.Nick, fmt.Sprintf("Commands: %s. Use %shelp <command> for details.",
+			strings.Join(r.allowedNames(c, nodes), ", "), r.displayPrefix()))
+		return nil
+	}
+
+	var path []*commandNode
+	for _, arg := range c.Args {
+		node := index[strings.ToLower(arg)]
+		if node == nil || (node.cmd.Permission != nil && !node.cmd.Permission(c)) {
+			return fmt.Errorf("no such command: %s", strings.Join(c.Args, " "))
+		}
+		path = append(path, node)
+		index = node.children
+	}
+	r.showHelp(c, path)
+	return nil
+}
+
+// showHelp describes the last command of path to the sender.
+func (r *CommandRouter) showHelp(c *CommandContext, path []*commandNode) {
+	node := path[len(path)-1]
+	line := r.synopsis(path)
+	if node.cmd.Help != "" {
+		line += " - " + node.cmd.Help
+	}
+	r.conn.Notice(c.Nick, line)
+	if subs := r.allowedNames(c, node.order); len(subs) > 0 {
+		r.conn.Notice(c.Nick, "Subcommands: "+strings.Join(subs, ", "))
+	}
+}
+
+func (r *CommandRouter) synopsis(path []*commandNode) string {
+	line := r.displayPrefix() + commandPathName(path)
+	if usage := path[len(path)-1].cmd.Usage; usage != "" {
+		line += " " + usage
+	}
+	return line
+}
+
+func (r *CommandRouter) displayPrefix() string {
+	if len(r.Prefixes) > 0 {
+		return r.Prefixes[0]
+	}
+	return ""
+}
+
+// allowedNames returns the names of the commands the sender may run.
+func (r *CommandRouter) allowedNames(c *CommandContext, nodes []*commandNode) []string {
+	var names []string
+	for _, node := range nodes {
+		if node.cmd.Permission == nil || node.cmd.Permission(c) {
+			names = append(names, node.cmd.Name)
+		}
+	}
+	return names
+}
+
+func commandPathName(path []*commandNode) string {
+	names := make([]string, len(path))
+	for i, node := range path {
+		names[i] = node.cmd.Name
+	}
+	return strings.Join(names, " ")
+}
+
+// splitCommandArgs splits text into words and returns the offset of each
+// in text. Double or single quotes at the start of a word group words, and
+// a backslash escapes the next character outside single quotes, so
+// `say "hello world" it\'s` has three words.
+func splitCommandArgs(text string) (words []string, offsets []int, err error) {
+	var b strings.Builder
+	var quote byte
+	inWord := false
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		if quote == 0 && (ch == ' ' || ch == '\t') {
+			if inWord {
+				words = append(words, b.String())
+				b.Reset()
+				inWord = false
+			}
+			continue
+		}
+		start := !inWord
+		if start {
+			inWord = true
+			offsets = append(offsets, i)
+		}
+		switch {
+		case quote != 0 && ch == quote:
+			quote = 0
+		case start && (ch == '"' || ch == '\''):
+			quote = ch
+		case ch == '\\' && quote != '\'' && i+1 < len(text):
+			i++
+			b.WriteByte(text[i])
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	if quote != 0 {
+		return nil, nil, fmt.Errorf("missing closing %c", quote)
+	}
+	if inWord {
+		words = append(words, b.String())
+	}
+	return words, offsets, nil
+}
+
+// channelMembers tracks the membership modes (op, voice, ...) of the users
+// in our channels, for AllowChannelMode. Its callbacks run on the read
+// loop, so the modes are up to date when a command arrives.
+type channelMembers struct {
+	conn      *Connection
+	mu        sync.Mutex
+	chans     map[string]map[string]string // channel -> nick -> mode letters, canonical names
+	names     map[string]map[string]string // member lists being received, until 366
+	callbacks []CallbackID
+}
+
+func newChannelMembers(conn *Connection) *channelMembers {
+	m := &channelMembers{
+		conn:  conn,
+		chans: make(map[string]map[string]string),
+		names: make(map[string]map[string]string),
+	}
+	add := func(code string, handler func(*Event)) {
+		id := conn.AddCallbackWithOptions(code, handler, CallbackOptions{Sync: true})
+		m.callbacks = append(m.callbacks, CallbackID{EventCode: code, ID: id})
+	}
+	add(RPL_NAMREPLY, m.handleNames)
+	add(RPL_ENDOFNAMES, m.handleEndOfNames)
+	add("JOIN", m.handleJoin)
+	add("PART", m.handlePart)
+	add("KICK", m.handleKick)
+	add("QUIT", m.handleQuit)
+	add("NICK", m.handleNick)
+	add("MODE", m.handleMode)
+	add(EventDisconnected, m.reset)
+	return m
+}
+
+func (m *channelMembers) isMe(nick string) bool {
+	return ircNickEqual(nick, m.conn.GetNick())
+}
+
+// hasMode reports whether nick has mode, or a higher ranked one, in channel.
+func (m *channelMembers) hasMode(channel, nick string, mode byte) bool {
+	modes, _ := m.conn.prefixModes()
+	rank := strings.IndexByte(modes, mode)
+	if rank < 0 {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, letter := range []byte(m.chans[canonicalizeRFCNick(channel)][canonicalizeRFCNick(nick)]) {
+		if i := strings.IndexByte(modes, letter); i >= 0 && i <= rank {
+			return true
+		}
+	}
+	return false
+}
+
+// handleNames reads RPL_NAMREPLY, with multi-prefix and userhost-in-names.
+// The members are collected apart and replace the channel's list on
+// RPL_ENDOFNAMES, so users who left unnoticed are dropped.
+//
+// Format: :server 353 <me> <symbol> <channel> :[prefix]<nick>{ [prefix]<nick>}
+func (m *channelMembers) handleNames(e *Event) {
+	if len(e.Arguments) < 4 {
+		return
+	}
+	modes, symbols := m.conn.prefixModes()
+	channel := canonicalizeRFCNick(e.Arguments[2])
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := m.names[channel]
+	if members == nil {
+		members = make(map[string]string)
+		m.names[channel] = members
+	}
+	for _, name := range strings.Fields(e.Message()) {
+		var letters []byte
+		for len(name) > 0 {
+			i := strings.IndexByte(symbols, name[0])
+			if i < 0 || i >= len(modes) {
+				break
+			}
+			letters = append(letters, modes[i])
+			name = name[1:]
+		}
+		nick, _, _ := strings.Cut(name, "!")
+		if nick != "" {
+			members[canonicalizeRFCNick(nick)] = string(letters)
+		}
+	}
+}
+
+// handleEndOfNames installs the member list collected for the channel.
+//
+// Format: :server 366 <me> <channel> :End of /NAMES list.
+func (m *channelMembers) handleEndOfNames(e *Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+	channel := canonicalizeRFCNick(e.Arguments[1])
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if members, ok := m.names[channel]; ok {
+		m.chans[channel] = members
+		delete(m.names, channel)
+	}
+}
+
+func (m *channelMembers) handleJoin(e *Event) {
+	if len(e.Arguments) < 1 {
+		return
+	}
+	channel := canonicalizeRFCNick(e.Arguments[0])
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.isMe(e.Nick) || m.chans[channel] == nil {
+		m.chans[channel] = make(map[string]string)
+	}
+	m.chans[channel][canonicalizeRFCNick(e.Nick)] = ""
+}
+
+func (m *channelMembers) handlePart(e *Event) {
+	if len(e.Arguments) < 1 {
+		return
+	}
+	m.leave(e.Arguments[0], e.Nick)
+}
+
+func (m *channelMembers) handleKick(e *Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+	m.leave(e.Arguments[0], e.Arguments[1])
+}
+
+func (m *channelMembers) leave(channel, nick string) {
+	channel = canonicalizeRFCNick(channel)
+	me := m.isMe(nick)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if me {
+		delete(m.chans, channel)
+	} else {
+		delete(m.chans[channel], canonicalizeRFCNick(nick))
+	}
+}
+
+func (m *channelMembers) handleQuit(e *Event) {
+	nick := canonicalizeRFCNick(e.Nick)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, members := range m.chans {
+		delete(members, nick)
+	}
+}
+
+func (m *channelMembers) handleNick(e *Event) {
+	if len(e.Arguments) < 1 {
+		return
+	}
+	old, nick := canonicalizeRFCNick(e.Nick), canonicalizeRFCNick(e.Arguments[0])
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, members := range m.chans {
+		if letters, ok := members[old]; ok {
+			delete(members, old)
+			members[nick] = letters
+		}
+	}
+}
+
+func (m *channelMembers) handleMode(e *Event) {
+	mode, err := e.AsMode()
+	if err != nil || !e.Connection.IsChannel(mode.Target) {
+		return
+	}
+	modes, _ := m.conn.prefixModes()
+	channel := canonicalizeRFCNick(mode.Target)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := m.chans[channel]
+	if members == nil {
+		return
+	}
+	for _, change := range mode.Changes {
+		if strings.IndexByte(modes, change.Mode) < 0 || change.Param == "" {
+			continue
+		}
+		nick := canonicalizeRFCNick(change.Param)
+		letters := strings.ReplaceAll(members[nick], string(change.Mode), "")
+		if change.Adding {
+			letters += string(change.Mode)
+		}
+		members[nick] = letters
+	}
+}
+
+func (m *channelMembers) reset(*Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chans = make(map[string]map[string]string)
+	m.names = make(map[string]map[string]string)
+}
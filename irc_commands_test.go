@@ -0,0 +1,263 @@
+package irc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRouter(t *testing.T) (*Connection, *CommandRouter, *[]error) {
+	t.Helper()
+	irccon := IRC("bot", "testuser")
+	irccon.pwrite = make(chan string, 100)
+	feedLines(t, irccon, ":srv 001 bot :Welcome", ":srv 005 bot PREFIX=(qaohv)~&@%+ :are supported by this server")
+	r := NewCommandRouter(irccon)
+	var errs []error
+	r.OnError = func(c *CommandContext, err error) { errs = append(errs, err) }
+	return irccon, r, &errs
+}
+
+func drainRaw(pwrite chan string) []string {
+	var lines []string
+	for {
+		select {
+		case line := <-pwrite:
+			lines = append(lines, strings.TrimSuffix(line, "\r\n"))
+		default:
+			return lines
+		}
+	}
+}
+
+func TestCommandRouterRouting(t *testing.T) {
+	irccon, r, errs := newTestRouter(t)
+
+	var calls []string
+	record := func(c *CommandContext) error {
+		calls = append(calls, c.Prefix+"|"+c.Name+"|"+strings.Join(c.Args, ",")+"|"+c.RawArgs)
+		return nil
+	}
+	r.Register(&Command{Name: "seen", Aliases: []string{"lastseen"}, MinArgs: 1, Usage: "<nick>", Run: record})
+	r.Register(&Command{Name: "admin", Subcommands: []*Command{
+		{Name: "say", Run: record},
+	}, Run: record})
+
+	feedLines(t, irccon,
+		":a!u@h PRIVMSG #go :!seen bob",
+		":a!u@h PRIVMSG #go :!LastSeen \"bob smith\" it\\'s",
+		":a!u@h PRIVMSG #go :Bot: admin say 'hi there'  now",
+		":a!u@h PRIVMSG #go :!admin unknown sub",
+		":a!u@h PRIVMSG bot :seen carol",
+		":a!u@h PRIVMSG #go :seen dave",
+		":a!u@h PRIVMSG #go :!nosuch",
+		":a!u@h PRIVMSG #go :!seen",
+		":a!u@h PRIVMSG #go :!seen 'unterminated",
+	)
+
+	want := []string{
+		"!|seen|bob|bob",
+		`!|seen|bob smith,it's|"bob smith" it\'s`,
+		"|admin say|hi there,now|'hi there'  now",
+		"!|admin|unknown,sub|unknown sub",
+		"|seen|carol|carol",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %q, want %q", calls, want)
+	}
+	if len(*errs) != 2 || !errors.Is((*errs)[0], ErrCommandUsage) || !errors.Is((*errs)[1], ErrCommandUsage) {
+		t.Fatalf("errors = %v, want two usage errors", *errs)
+	}
+	if got := (*errs)[0].Error(); got != "usage: !seen <nick>" {
+		t.Fatalf("usage error %q", got)
+	}
+}
+
+func TestCommandRouterPermissions(t *testing.T) {
+	irccon, r, errs := newTestRouter(t)
+
+	ran := 0
+	run := func(*CommandContext) error { ran++; return nil }
+	r.Register(&Command{Name: "host", Permission: AllowHostmask("*!*@*.trusted.net"), Run: run})
+	r.Register(&Command{Name: "acct", Permission: AllowAccount("Alice"), Run: run})
+	r.Register(&Command{Name: "op", Permission: AllowChannelMode('o'), Run: run})
+	r.Register(&Command{Name: "mixed", Permission: AnyPermission(AllowAccount("zed"), AllowChannelMode('v')), Run: run})
+
+	feedLines(t, irccon,
+		":bot!u@h JOIN #go",
+		":srv 353 bot = #go :~owner @op +voice plain bot",
+		":srv 366 bot #go :End of /NAMES list.",
+		":x!u@a.trusted.net PRIVMSG #go :!host",
+		":x!u@evil.net PRIVMSG #go :!host",
+		"@account=alice :y!u@h PRIVMSG #go :!acct",
+		":y!u@h PRIVMSG #go :!acct",
+		":owner!u@h PRIVMSG #go :!op",
+		":op!u@h PRIVMSG #go :!op",
+		":voice!u@h PRIVMSG #go :!op",
+		":voice!u@h PRIVMSG #go :!mixed",
+		":op!u@h PRIVMSG bot :op",
+		":op!u@h MODE #go -o op",
+		":op!u@h PRIVMSG #go :!op",
+		":srv MODE #go +h plain",
+		":plain!u@h NICK newplain",
+		":newplain!u@h PRIVMSG #go :!op",
+		":newplain!u@h PRIVMSG #go :!mixed",
+	)
+
+	if ran != 6 {
+		t.Fatalf("ran %d commands, want 6", ran)
+	}
+	if len(*errs) != 6 {
+		t.Fatalf("got %d errors, want 6: %v", len(*errs), *errs)
+	}
+	for _, err := range *errs {
+		if !errors.Is(err, ErrPermissionDenied) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+}
+
+func TestCommandRouterCooldown(t *testing.T) {
+	irccon, r, errs := newTestRouter(t)
+
+	ran := 0
+	r.Register(&Command{Name: "slow", Cooldown: time.Hour, Run: func(*CommandContext) error { ran++; return nil }})
+	feedLines(t, irccon,
+		":a!u@h PRIVMSG #go :!slow",
+		":b!u@h PRIVMSG #go :!slow",
+		":c!other@h PRIVMSG #go :!slow",
+	)
+	if ran != 2 || len(*errs) != 1 || !errors.Is((*errs)[0], ErrCommandCooldown) {
+		t.Fatalf("ran %d, errors %v; want 2 runs and one cooldown", ran, *errs)
+	}
+}
+
+func TestCommandRouterDefaultErrorNotices(t *testing.T) {
+	irccon, r, _ := newTestRouter(t)
+	r.OnError = nil
+	drainRaw(irccon.pwrite)
+
+	r.Register(&Command{Name: "slow", Cooldown: time.Hour, Run: func(*CommandContext) error { return nil }})
+	r.Register(&Command{Name: "seen", MinArgs: 1, Usage: "<nick>", Run: func(*CommandContext) error { return nil }})
+	feedLines(t, irccon,
+		":a!u@h PRIVMSG #go :!slow",
+		":a!u@h PRIVMSG #go :!slow",
+		":a!u@h PRIVMSG #go :!seen",
+		":a!u@h PRIVMSG #go :!seen",
+		":b!u@h2 PRIVMSG #go :!seen",
+	)
+
+	want := []string{
+		"NOTICE a :usage: !seen <nick>",
+		"NOTICE b :usage: !seen <nick>",
+	}
+	if got := drainRaw(irccon.pwrite); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+}
+
+func TestChannelMembersRebuiltFromNames(t *testing.T) {
+	irccon, r, _ := newTestRouter(t)
+	m := r.members
+
+	feedLines(t, irccon,
+		":bot!u@h JOIN #go",
+		":srv 353 bot = #go :@stale bot",
+		":srv 366 bot #go :End of /NAMES list.",
+		":srv 353 bot = #go :+fresh",
+		":srv 353 bot = #go :bot",
+	)
+	if !m.hasMode("#go", "stale", 'o') {
+		t.Fatal("member list replaced before RPL_ENDOFNAMES")
+	}
+	feedLines(t, irccon, ":srv 366 bot #go :End of /NAMES list.")
+	if m.hasMode("#go", "stale", 'o') || !m.hasMode("#go", "fresh", 'v') {
+		t.Fatalf("member list not rebuilt: %v", m.chans["#go"])
+	}
+}
+
+func TestCommandRouterClose(t *testing.T) {
+	irccon := IRC("bot", "testuser")
+	irccon.pwrite = make(chan string, 100)
+	before := make(map[string]int)
+	for code, callbacks := range irccon.events {
+		before[code] = len(callbacks)
+	}
+
+	r := NewCommandRouter(irccon)
+	feedLines(t, irccon, ":a!u@h PRIVMSG bot :help")
+	if lines := drainRaw(irccon.pwrite); len(lines) == 0 {
+		t.Fatal("router did not answer before Close")
+	}
+
+	r.Close()
+	r.Close()
+	for code, callbacks := range irccon.events {
+		if len(callbacks) != before[code] {
+			t.Fatalf("%s has %d callbacks after Close, want %d", code, len(callbacks), before[code])
+		}
+	}
+	feedLines(t, irccon, ":a!u@h PRIVMSG bot :help", ":bot!u@h JOIN #go")
+	if lines := drainRaw(irccon.pwrite); len(lines) != 0 {
+		t.Fatalf("closed router sent %q", lines)
+	}
+	if len(r.members.chans) != 0 {
+		t.Fatalf("closed router still tracks %v", r.members.chans)
+	}
+}
+
+func TestCommandRouterHelp(t *testing.T) {
+	irccon, r, _ := newTestRouter(t)
+	r.OnError = nil
+
+	r.Register(&Command{Name: "seen", Usage: "<nick>", Help: "Last seen time", Run: func(*CommandContext) error { return nil }})
+	r.Register(&Command{Name: "secret", Permission: AllowAccount("root"), Run: func(*CommandContext) error { return nil }})
+	r.Register(&Command{Name: "admin", Help: "Administration", Subcommands: []*Command{
+		{Name: "ban", Usage: "<mask>", Help: "Ban a mask"},
+		{Name: "kick", Usage: "<nick>"},
+	}})
+	drainRaw(irccon.pwrite)
+
+	feedLines(t, irccon,
+		":a!u@h PRIVMSG #go :!help",
+		":a!u@h PRIVMSG #go :!help admin ban",
+		":a!u@h PRIVMSG #go :!admin",
+		":a!u@h PRIVMSG #go :!help secret",
+	)
+	want := []string{
+		"NOTICE a :Commands: help, seen, admin. Use !help <command> for details.",
+		"NOTICE a :!admin ban <mask> - Ban a mask",
+		"NOTICE a :!admin - Administration",
+		"NOTICE a :Subcommands: ban, kick",
+		"NOTICE a :no such command: secret",
+	}
+	if got := drainRaw(irccon.pwrite); !reflect.DeepEqual(got, want) {
+		t.Fatalf("help output %q, want %q", got, want)
+	}
+}
+
+func TestSplitCommandArgs(t *testing.T) {
+	cases := []struct {
+		in      string
+		words   []string
+		offsets []int
+	}{
+		{"", nil, nil},
+		{"  a  b ", []string{"a", "b"}, []int{2, 5}},
+		{`say "hello world" it\'s`, []string{"say", "hello world", "it's"}, []int{0, 4, 18}},
+		{`don't 'a "b"' "c\"d"`, []string{"don't", `a "b"`, `c"d`}, []int{0, 6, 14}},
+		{`x""y ''`, []string{`x""y`, ""}, []int{0, 5}},
+	}
+	for _, c := range cases {
+		words, offsets, err := splitCommandArgs(c.in)
+		if err != nil || !reflect.DeepEqual(words, c.words) || !reflect.DeepEqual(offsets, c.offsets) {
+			t.Errorf("splitCommandArgs(%q) = %q, %v, %v; want %q, %v", c.in, words, offsets, err, c.words, c.offsets)
+		}
+	}
+	for _, in := range []string{`"open`, `a 'b`} {
+		if _, _, err := splitCommandArgs(in); err == nil {
+			t.Errorf("splitCommandArgs(%q) succeeded, want error", in)
+		}
+	}
+}
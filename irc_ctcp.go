@@ -0,0 +1,315 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCTCPRequestInterval is the rate at which CTCP requests are
+	// sent once the burst allowance is used up.
+	DefaultCTCPRequestInterval = 2 * time.Second
+	// DefaultCTCPRequestBurst is the number of CTCP requests that may be
+	// sent back to back.
+	DefaultCTCPRequestBurst = 3
+)
+
+// rateLimiter is a token bucket: up to burst events at once, then one per
+// interval.
+type rateLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token and returns how long the caller has to wait before
+// using it.
+func (r *rateLimiter) reserve(interval time.Duration, burst int) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.last.IsZero() {
+		r.tokens = float64(burst)
+	} else {
+		r.tokens += float64(now.Sub(r.last)) / float64(interval)
+		if r.tokens > float64(burst) {
+			r.tokens = float64(burst)
+		}
+	}
+	r.last = now
+	r.tokens--
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens * float64(interval))
+}
+
+// allow takes a token if one is available right now.
+func (r *rateLimiter) allow(interval time.Duration, burst int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.last.IsZero() {
+		r.tokens = float64(burst)
+	} else {
+		r.tokens += float64(now.Sub(r.last)) / float64(interval)
+		if r.tokens > float64(burst) {
+			r.tokens = float64(burst)
+		}
+	}
+	r.last = now
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+type ctcpResult struct {
+	reply string
+	err   error
+}
+
+type ctcpWaiter struct {
+	args   string // request arguments, used to match PING replies
+	result chan ctcpResult
+}
+
+type ctcpTracker struct {
+	mu      sync.Mutex
+	pending map[string][]*ctcpWaiter // canonical nick + " " + command -> waiters, oldest first
+	limiter rateLimiter
+	replies map[string]int // CTCPREPLY_* code -> ID of the callback delivering it
+}
+
+func newCTCPTracker() *ctcpTracker {
+	return &ctcpTracker{
+		pending: make(map[string][]*ctcpWaiter),
+		replies: make(map[string]int),
+	}
+}
+
+func ctcpKey(nick, command string) string {
+	return canonicalizeRFCNick(nick) + " " + strings.ToUpper(command)
+}
+
+// CTCP sends a CTCP request to nick and waits for the matching reply
+// NOTICE, returning its arguments: CTCP(ctx, "bob", "VERSION") might
+// return "irssi v1.4.5". Requests are rate limited by CTCPRequestInterval
+// and CTCPRequestBurst so that a burst of queries cannot get us
+// disconnected for flooding; CTCP waits for its turn.
+//
+// It returns ErrNoSuchNick if nick is not on the network, ErrDisconnected
+// when the connection is closed first and ctx.Err() when ctx is done. Many
+// clients ignore CTCP, so always pass a ctx with a deadline.
+func (irc *Connection) CTCP(ctx context.Context, nick, command string, args ...string) (string, error) {
+	reply, _, err := irc.ctcpRequest(ctx, nick, command, strings.Join(args, " "))
+	return reply, err
+}
+
+// CTCPPing sends a CTCP PING to nick and returns the round-trip time,
+// measured from the moment the request leaves the rate limiter.
+func (irc *Connection) CTCPPing(ctx context.Context, nick string) (time.Duration, error) {
+	_, sent, err := irc.ctcpRequest(ctx, nick, "PING", strconv.FormatInt(time.Now().UnixNano(), 10))
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(sent), nil
+}
+
+// ctcpRequest implements CTCP and reports when the request was sent.
+func (irc *Connection) ctcpRequest(ctx context.Context, nick, command, payload string) (string, time.Time, error) {
+	if nick == "" || command == "" {
+		return "", time.Time{}, errors.New("empty CTCP target or command")
+	}
+	command = strings.ToUpper(command)
+
+	if err := irc.waitCTCPTurn(ctx); err != nil {
+		return "", time.Time{}, err
+	}
+
+	irc.watchCTCPReplies(command)
+	c := irc.ctcp
+	key := ctcpKey(nick, command)
+	w := &ctcpWaiter{args: payload, result: make(chan ctcpResult, 1)}
+	c.mu.Lock()
+	c.pending[key] = append(c.pending[key], w)
+	c.mu.Unlock()
+
+	sent := time.Now()
+	irc.sendText("PRIVMSG", nick, EncodeCTCP(command, payload))
+
+	select {
+	case r := <-w.result:
+		return r.reply, sent, r.err
+	case <-ctx.Done():
+		c.remove(key, w)
+		return "", sent, ctx.Err()
+	}
+}
+
+// waitCTCPTurn blocks until the rate limiter allows another request.
+func (irc *Connection) waitCTCPTurn(ctx context.Context) error {
+	interval := irc.CTCPRequestInterval
+	if interval <= 0 {
+		interval = DefaultCTCPRequestInterval
+	}
+	burst := irc.CTCPRequestBurst
+	if burst <= 0 {
+		burst = DefaultCTCPRequestBurst
+	}
+
+	delay := irc.ctcp.limiter.reserve(interval, burst)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// remove forgets a waiter whose caller gave up.
+func (c *ctcpTracker) remove(key string, w *ctcpWaiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	waiters := c.pending[key]
+	for i, candidate := range waiters {
+		if candidate == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(c.pending, key)
+	} else {
+		c.pending[key] = waiters
+	}
+}
+
+// deliver hands a reply from nick to the oldest matching request. PING
+// replies go to the request with the same payload.
+func (c *ctcpTracker) deliver(nick, command, args string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := ctcpKey(nick, command)
+	waiters := c.pending[key]
+	if len(waiters) == 0 {
+		return
+	}
+	i := 0
+	if strings.EqualFold(command, "PING") {
+		i = -1
+		for j, w := range waiters {
+			if w.args == args {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return
+		}
+	}
+	w := waiters[i]
+	waiters = append(waiters[:i], waiters[i+1:]...)
+	if len(waiters) == 0 {
+		delete(c.pending, key)
+	} else {
+		c.pending[key] = waiters
+	}
+	w.result <- ctcpResult{reply: args}
+}
+
+// failNick ends every request to nick with err.
+func (c *ctcpTracker) failNick(nick string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := canonicalizeRFCNick(nick) + " "
+	for key, waiters := range c.pending {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		delete(c.pending, key)
+		for _, w := range waiters {
+			w.result <- ctcpResult{err: err}
+		}
+	}
+}
+
+// failAll ends every request in flight with err.
+func (c *ctcpTracker) failAll(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, waiters := range c.pending {
+		delete(c.pending, key)
+		for _, w := range waiters {
+			w.result <- ctcpResult{err: err}
+		}
+	}
+}
+
+// setupCTCPRequestCallbacks installs the handlers that match CTCP replies
+// to requests made with CTCP and CTCPPing.
+func (irc *Connection) setupCTCPRequestCallbacks() {
+	// Replies are matched by watchCTCPReplies, per requested command
+	// ERR_NOSUCHNICK (401): <me> <nick> :No such nick/channel
+	irc.AddCallback(ERR_NOSUCHNICK, func(e *Event) {
+		if len(e.Arguments) < 2 {
+			return
+		}
+		irc.ctcp.failNick(e.Arguments[1], ErrNoSuchNick)
+	})
+}
+
+// watchCTCPReplies registers the handler for replies to command, which
+// arrive as CTCPREPLY_<COMMAND> events (see classifyCTCP), unless it is
+// already registered. Only the commands we sent get one, so other events
+// do not pay for a callback.
+func (irc *Connection) watchCTCPReplies(command string) {
+	code := "CTCPREPLY_" + command
+	c := irc.ctcp
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if id, ok := c.replies[code]; ok && irc.hasCallback(code, id) {
+		return
+	}
+	c.replies[code] = irc.addInternalCallback(code, func(e *Event) {
+		if e.Nick == "" {
+			return
+		}
+		command, args, _ := e.CTCP()
+		c.deliver(e.Nick, command, args)
+	})
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import "strings"
+
+const (
+	ctcpDelim = '\x01'
+	ctcpQuote = '\x10' // low-level quote (M-QUOTE)
+)
+
+// EncodeCTCP builds the body of a CTCP message, ready to be sent with
+// PRIVMSG (requests) or NOTICE (replies):
+//
+//	EncodeCTCP("PING", "123") == "\x01PING 123\x01"
+//
+// NUL, CR, LF and the quote character itself are escaped with the
+// low-level CTCP quoting so they survive the trip through the server.
+func EncodeCTCP(command, args string) string {
+	body := strings.ToUpper(command)
+	if args != "" {
+		body += " " + args
+	}
+	return string(ctcpDelim) + quoteCTCP(body) + string(ctcpDelim)
+}
+
+// ParseCTCP decodes a CTCP message body as found in the text of a PRIVMSG
+// or NOTICE. A missing closing \x01 is tolerated, as many clients omit it.
+// command is upper-cased; ok is false when text is not a CTCP message.
+func ParseCTCP(text string) (command, args string, ok bool) {
+	if len(text) < 2 || text[0] != ctcpDelim {
+		return "", "", false
+	}
+	body := text[1:]
+	if i := strings.IndexByte(body, ctcpDelim); i >= 0 {
+		body = body[:i]
+	}
+	body = dequoteCTCP(body)
+
+	command, args, _ = strings.Cut(body, " ")
+	if command == "" {
+		return "", "", false
+	}
+	return strings.ToUpper(command), args, true
+}
+
+// isCTCPCommandName reports whether command is safe to use in an event code.
+func isCTCPCommandName(command string) bool {
+	for i := 0; i < len(command); i++ {
+		ch := command[i]
+		if !(ch >= 'A' && ch <= 'Z') && !(ch >= '0' && ch <= '9') && ch != '-' && ch != '_' {
+			return false
+		}
+	}
+	return command != ""
+}
+
+func quoteCTCP(s string) string {
+	if !strings.ContainsAny(s, "\x00\r\n\x10") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\x00':
+			b.WriteString("\x100")
+		case '\n':
+			b.WriteString("\x10n")
+		case '\r':
+			b.WriteString("\x10r")
+		case ctcpQuote:
+			b.WriteString("\x10\x10")
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func dequoteCTCP(s string) string {
+	if strings.IndexByte(s, ctcpQuote) < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != ctcpQuote || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case '0':
+			b.WriteByte('\x00')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			// \x10\x10 and unknown escapes yield the escaped character
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// CTCP returns the command and arguments of a CTCP request (CTCP_*, CTCP)
+// or reply (CTCPREPLY_*, CTCPREPLY) event. ok is false for other events.
+// CTCP_ACTION events carry only the action text, returned as args.
+func (e *Event) CTCP() (command, args string, ok bool) {
+	switch {
+	case e.Code == "CTCP_ACTION":
+		return "ACTION", e.Message(), true
+	case e.Code == "CTCP", e.Code == "CTCPREPLY",
+		strings.HasPrefix(e.Code, "CTCP_"), strings.HasPrefix(e.Code, "CTCPREPLY_"):
+		command, args, _ = strings.Cut(e.Message(), " ")
+		return command, args, true
+	}
+	return "", "", false
+}
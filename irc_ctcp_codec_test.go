@@ -0,0 +1,79 @@
+package irc
+
+import "testing"
+
+func TestCTCPCodecRoundTrip(t *testing.T) {
+	cases := []struct {
+		command, args string
+	}{
+		{"VERSION", ""},
+		{"PING", "1700000000"},
+		{"ACTION", "waves"},
+		{"DATA", "line1\nline2\r\x00\x10end"},
+	}
+	for _, c := range cases {
+		encoded := EncodeCTCP(c.command, c.args)
+		for _, ch := range []byte{'\x00', '\r', '\n'} {
+			for i := 0; i < len(encoded); i++ {
+				if encoded[i] == ch {
+					t.Fatalf("EncodeCTCP(%q, %q) = %q contains %q", c.command, c.args, encoded, ch)
+				}
+			}
+		}
+		command, args, ok := ParseCTCP(encoded)
+		if !ok || command != c.command || args != c.args {
+			t.Fatalf("ParseCTCP(%q) = %q, %q, %v; want %q, %q", encoded, command, args, ok, c.command, c.args)
+		}
+	}
+}
+
+func TestParseCTCPTolerance(t *testing.T) {
+	cases := []struct {
+		text          string
+		command, args string
+		ok            bool
+	}{
+		{"\x01version\x01", "VERSION", "", true},
+		{"\x01PING 123", "PING", "123", true},
+		{"\x01ACTION waves\x01 trailing", "ACTION", "waves", true},
+		{"\x01", "", "", false},
+		{"\x01\x01", "", "", false},
+		{"hello", "", "", false},
+	}
+	for _, c := range cases {
+		command, args, ok := ParseCTCP(c.text)
+		if command != c.command || args != c.args || ok != c.ok {
+			t.Errorf("ParseCTCP(%q) = %q, %q, %v; want %q, %q, %v", c.text, command, args, ok, c.command, c.args, c.ok)
+		}
+	}
+}
+
+func TestCTCPRepliesBecomeEvents(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	var codes []string
+	var command, args string
+	irccon.AddCallback("*", func(e *Event) { codes = append(codes, e.Code) })
+	irccon.AddCallback("CTCPREPLY_VERSION", func(e *Event) { command, args, _ = e.CTCP() })
+
+	feedLines(t, irccon,
+		":bob!b@host NOTICE me :\x01VERSION irssi v1.4.5",
+		":bob!b@host NOTICE me :\x01weird/cmd x\x01",
+		":bob!b@host NOTICE me :plain notice",
+		":bob!b@host PRIVMSG me :\x01ACTION waves",
+	)
+
+	want := []string{"CTCPREPLY_VERSION", "CTCPREPLY", "NOTICE", "CTCP_ACTION"}
+	if len(codes) != len(want) {
+		t.Fatalf("unexpected events %v", codes)
+	}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("unexpected events %v, want %v", codes, want)
+		}
+	}
+	if command != "VERSION" || args != "irssi v1.4.5" {
+		t.Fatalf("unexpected CTCP reply %q %q", command, args)
+	}
+}
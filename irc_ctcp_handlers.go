@@ -0,0 +1,243 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCTCPFloodInterval and DefaultCTCPFloodBurst limit how often a
+	// single sender gets a CTCP reply: DefaultCTCPFloodBurst replies at
+	// once, then one per DefaultCTCPFloodInterval.
+	DefaultCTCPFloodInterval = 5 * time.Second
+	DefaultCTCPFloodBurst    = 3
+
+	// DefaultCTCPSource is the SOURCE reply unless CTCPSource is set.
+	DefaultCTCPSource = "https://github.com/kofany/go-ircevo"
+
+	// maxCTCPFloodSenders bounds the per-sender limiter map; idle senders
+	// are pruned once it grows past this size.
+	maxCTCPFloodSenders = 256
+)
+
+// CTCPHandler answers an incoming CTCP request. args is the text after the
+// command ("PING 123" -> "123"). The reply is sent back as a NOTICE
+// encoded with EncodeCTCP; return ok == false to send nothing.
+type CTCPHandler func(e *Event, args string) (reply string, ok bool)
+
+type ctcpHandlerEntry struct {
+	handler    CTCPHandler
+	callbackID int
+}
+
+type ctcpRegistry struct {
+	mu       sync.Mutex
+	handlers map[string]ctcpHandlerEntry // upper-case command -> handler
+	flood    map[string]*rateLimiter     // sender host (or nick) -> limiter
+}
+
+func newCTCPRegistry() *ctcpRegistry {
+	return &ctcpRegistry{
+		handlers: make(map[string]ctcpHandlerEntry),
+		flood:    make(map[string]*rateLimiter),
+	}
+}
+
+// legacyCTCPCommands always get their own CTCP_<COMMAND> event code, even
+// with no handler registered, so existing callbacks keep working.
+var legacyCTCPCommands = map[string]bool{
+	"VERSION":    true,
+	"TIME":       true,
+	"PING":       true,
+	"USERINFO":   true,
+	"CLIENTINFO": true,
+}
+
+// isKnownCTCP reports whether an incoming CTCP command gets a dedicated
+// CTCP_<COMMAND> event code instead of the generic "CTCP".
+func (irc *Connection) isKnownCTCP(command string) bool {
+	if legacyCTCPCommands[command] {
+		return true
+	}
+	if irc.ctcpHandlers == nil {
+		return false
+	}
+	irc.ctcpHandlers.mu.Lock()
+	defer irc.ctcpHandlers.mu.Unlock()
+	_, ok := irc.ctcpHandlers.handlers[command]
+	return ok
+}
+
+// HandleCTCP registers handler as the responder for CTCP command, replacing
+// any previous one; requests for it are then delivered as CTCP_<COMMAND>
+// events and listed in the CLIENTINFO reply. A nil handler disables the
+// command, e.g. HandleCTCP("VERSION", nil) stops answering VERSION.
+//
+// Replies are rate limited per sender (CTCPFloodInterval, CTCPFloodBurst).
+func (irc *Connection) HandleCTCP(command string, handler CTCPHandler) {
+	command = strings.ToUpper(command)
+	if command == "" || command == "ACTION" {
+		return
+	}
+
+	r := irc.ctcpHandlers
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, exists := r.handlers[command]
+	if handler == nil {
+		if exists {
+			irc.RemoveCallback("CTCP_"+command, entry.callbackID)
+			delete(r.handlers, command)
+		}
+		return
+	}
+
+	callback := irc.ctcpCallback(command, handler)
+	if exists {
+		irc.ReplaceCallback("CTCP_"+command, entry.callbackID, callback)
+		entry.handler = handler
+	} else {
+		entry = ctcpHandlerEntry{handler: handler, callbackID: irc.AddCallback("CTCP_"+command, callback)}
+	}
+	r.handlers[command] = entry
+}
+
+// CTCPCommands returns the CTCP commands with a registered handler, sorted,
+// as advertised in the CLIENTINFO reply (plus ACTION).
+func (irc *Connection) CTCPCommands() []string {
+	r := irc.ctcpHandlers
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	commands := make([]string, 0, len(r.handlers))
+	for command := range r.handlers {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+// ctcpCallback wraps a CTCPHandler into an event callback.
+func (irc *Connection) ctcpCallback(command string, handler CTCPHandler) func(*Event) {
+	return func(e *Event) {
+		if e.Self || e.Nick == "" {
+			return
+		}
+		if !irc.allowCTCPReply(e) {
+			if irc.Debug {
+				irc.Log.Printf("CTCP %s from %s dropped: flood limit", command, e.Nick)
+			}
+			return
+		}
+
+		_, args, _ := strings.Cut(e.Message(), " ")
+		reply, ok := handler(e, args)
+		if !ok {
+			return
+		}
+		irc.sendText("NOTICE", e.Nick, EncodeCTCP(command, reply))
+	}
+}
+
+// allowCTCPReply applies the per-sender flood limit. Senders are keyed by
+// host so that changing nick does not reset the limit.
+func (irc *Connection) allowCTCPReply(e *Event) bool {
+	interval := irc.CTCPFloodInterval
+	if interval <= 0 {
+		interval = DefaultCTCPFloodInterval
+	}
+	burst := irc.CTCPFloodBurst
+	if burst <= 0 {
+		burst = DefaultCTCPFloodBurst
+	}
+
+	key := strings.ToLower(e.Host)
+	if key == "" {
+		key = canonicalizeRFCNick(e.Nick)
+	}
+
+	r := irc.ctcpHandlers
+	r.mu.Lock()
+	limiter, ok := r.flood[key]
+	if !ok {
+		if len(r.flood) >= maxCTCPFloodSenders {
+			r.pruneFloodLocked(interval * time.Duration(burst))
+		}
+		limiter = &rateLimiter{}
+		r.flood[key] = limiter
+	}
+	r.mu.Unlock()
+
+	return limiter.allow(interval, burst)
+}
+
+// pruneFloodLocked forgets senders idle for longer than idle, whose
+// buckets would be full again anyway.
+func (r *ctcpRegistry) pruneFloodLocked(idle time.Duration) {
+	for key, limiter := range r.flood {
+		limiter.mu.Lock()
+		stale := time.Since(limiter.last) > idle
+		limiter.mu.Unlock()
+		if stale {
+			delete(r.flood, key)
+		}
+	}
+}
+
+// setupCTCPHandlers registers the built-in CTCP responders.
+func (irc *Connection) setupCTCPHandlers() {
+	irc.HandleCTCP("VERSION", func(e *Event, args string) (string, bool) {
+		return irc.Version, true
+	})
+	irc.HandleCTCP("USERINFO", func(e *Event, args string) (string, bool) {
+		return irc.user, true
+	})
+	irc.HandleCTCP("CLIENTINFO", func(e *Event, args string) (string, bool) {
+		return strings.Join(append(irc.CTCPCommands(), "ACTION"), " "), true
+	})
+	irc.HandleCTCP("TIME", func(e *Event, args string) (string, bool) {
+		return time.Now().String(), true
+	})
+	irc.HandleCTCP("PING", func(e *Event, args string) (string, bool) {
+		return args, true
+	})
+	irc.HandleCTCP("SOURCE", func(e *Event, args string) (string, bool) {
+		if irc.CTCPSource != "" {
+			return irc.CTCPSource, true
+		}
+		return DefaultCTCPSource, true
+	})
+	irc.HandleCTCP("FINGER", func(e *Event, args string) (string, bool) {
+		if irc.RealName != "" {
+			return irc.RealName, true
+		}
+		return irc.user, true
+	})
+	irc.HandleCTCP("ERRMSG", func(e *Event, args string) (string, bool) {
+		if args == "" {
+			return "No error", true
+		}
+		return args + " :No error", true
+	})
+}
@@ -0,0 +1,93 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCTCPRegistryClientInfoAndCustomHandlers(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	irccon.HandleCTCP("VERSION", nil)
+	irccon.HandleCTCP("weather", func(e *Event, args string) (string, bool) {
+		return "sunny in " + args, true
+	})
+
+	feedLines(t, irccon, ":bob!b@host.b PRIVMSG me :\x01CLIENTINFO\x01")
+	want := "NOTICE bob :\x01CLIENTINFO CLIENTINFO ERRMSG FINGER PING SOURCE TIME USERINFO WEATHER ACTION\x01\r\n"
+	if got := nextRawCommand(t, irccon.pwrite); got != want {
+		t.Fatalf("unexpected CLIENTINFO reply:\n got %q\nwant %q", got, want)
+	}
+
+	feedLines(t, irccon, ":bob!b@host.b PRIVMSG me :\x01WEATHER Warsaw\x01")
+	if got := nextRawCommand(t, irccon.pwrite); got != "NOTICE bob :\x01WEATHER sunny in Warsaw\x01\r\n" {
+		t.Fatalf("unexpected custom reply %q", got)
+	}
+
+	var code string
+	irccon.AddCallback("CTCP_VERSION", func(e *Event) { code = e.Code })
+	feedLines(t, irccon, ":bob!b@host.b PRIVMSG me :\x01VERSION\x01")
+	if code != "CTCP_VERSION" {
+		t.Fatalf("expected CTCP_VERSION event for a disabled command, got %q", code)
+	}
+	select {
+	case got := <-irccon.pwrite:
+		t.Fatalf("expected no VERSION reply after disabling it, got %q", got)
+	default:
+	}
+
+	feedLines(t, irccon, ":bob!b@host.b PRIVMSG me :\x01ERRMSG test\x01")
+	if got := nextRawCommand(t, irccon.pwrite); got != "NOTICE bob :\x01ERRMSG test :No error\x01\r\n" {
+		t.Fatalf("unexpected ERRMSG reply %q", got)
+	}
+}
+
+func TestCTCPUnknownCommandStaysGeneric(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	var code string
+	irccon.AddCallback("*", func(e *Event) { code = e.Code })
+	feedLines(t, irccon, ":bob!b@host.b PRIVMSG me :\x01XYZZY plugh\x01")
+	if code != "CTCP" {
+		t.Fatalf("expected generic CTCP event, got %q", code)
+	}
+	select {
+	case got := <-irccon.pwrite:
+		t.Fatalf("expected no reply to an unknown CTCP, got %q", got)
+	default:
+	}
+}
+
+func TestCTCPFloodLimitPerSender(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+	irccon.CTCPFloodBurst = 2
+	irccon.CTCPFloodInterval = time.Hour
+
+	for i := 0; i < 4; i++ {
+		feedLines(t, irccon, ":bob!b@host.b PRIVMSG me :\x01PING 1\x01")
+	}
+	// Changing nick does not reset the limit; another host has its own.
+	feedLines(t, irccon,
+		":bob2!b@host.b PRIVMSG me :\x01PING 2\x01",
+		":carol!c@host.c PRIVMSG me :\x01PING 3\x01",
+	)
+
+	want := []string{
+		"NOTICE bob :\x01PING 1\x01\r\n",
+		"NOTICE bob :\x01PING 1\x01\r\n",
+		"NOTICE carol :\x01PING 3\x01\r\n",
+	}
+	for _, w := range want {
+		if got := nextRawCommand(t, irccon.pwrite); got != w {
+			t.Fatalf("expected %q, got %q", w, got)
+		}
+	}
+	select {
+	case got := <-irccon.pwrite:
+		t.Fatalf("expected flood to be dropped, got %q", got)
+	default:
+	}
+}
@@ -0,0 +1,151 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCTCPMatchesReplyFromTarget(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	type result struct {
+		reply string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		reply, err := irccon.CTCP(ctx, "Bob", "version")
+		done <- result{reply, err}
+	}()
+
+	if got := nextRawCommand(t, irccon.pwrite); got != "PRIVMSG Bob :\x01VERSION\x01\r\n" {
+		t.Fatalf("unexpected command %q", got)
+	}
+	feedLines(t, irccon,
+		":carol!c@host NOTICE me :\x01VERSION not for you\x01",
+		":bob!b@host NOTICE me :\x01VERSION irssi v1.4.5\x01",
+	)
+
+	r := <-done
+	if r.err != nil || r.reply != "irssi v1.4.5" {
+		t.Fatalf("unexpected CTCP result %q, %v", r.reply, r.err)
+	}
+}
+
+func TestCTCPRepliesOnlyWatchedForRequestedCommands(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+	if plan := irccon.callbacksFor("PRIVMSG"); hasCallbackCode(plan, "*") {
+		t.Fatal("CTCP request tracking runs a callback for every event")
+	}
+
+	request := func() chan string {
+		done := make(chan string, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			reply, _ := irccon.CTCP(ctx, "bob", "TIME")
+			done <- reply
+		}()
+		nextRawCommand(t, irccon.pwrite)
+		return done
+	}
+
+	done := request()
+	if plan := irccon.callbacksFor("CTCPREPLY_TIME"); len(plan.internal) != 1 {
+		t.Fatalf("CTCPREPLY_TIME has %d library handlers, want 1", len(plan.internal))
+	}
+	feedLines(t, irccon, ":bob!b@host NOTICE me :\x01TIME noon\x01")
+	if reply := <-done; reply != "noon" {
+		t.Fatalf("got reply %q, want noon", reply)
+	}
+
+	// A later request re-registers a handler the user cleared
+	irccon.ClearCallback("CTCPREPLY_TIME")
+	done = request()
+	feedLines(t, irccon, ":bob!b@host NOTICE me :\x01TIME midnight\x01")
+	if reply := <-done; reply != "midnight" {
+		t.Fatalf("got reply %q, want midnight", reply)
+	}
+	if plan := irccon.callbacksFor("CTCPREPLY_TIME"); len(plan.internal) != 1 {
+		t.Fatalf("CTCPREPLY_TIME has %d library handlers, want 1", len(plan.internal))
+	}
+}
+
+func hasCallbackCode(plan *callbackPlan, code string) bool {
+	for _, c := range plan.firstWave {
+		if c.code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCTCPPingMeasuresRoundTrip(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	done := make(chan error, 1)
+	var rtt time.Duration
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		var err error
+		rtt, err = irccon.CTCPPing(ctx, "bob")
+		done <- err
+	}()
+
+	sent := nextRawCommand(t, irccon.pwrite)
+	payload := strings.TrimSuffix(strings.TrimPrefix(sent, "PRIVMSG bob :\x01PING "), "\x01\r\n")
+	if payload == sent || payload == "" {
+		t.Fatalf("unexpected PING request %q", sent)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	feedLines(t, irccon,
+		":bob!b@host NOTICE me :\x01PING 12345\x01",
+		":bob!b@host NOTICE me :\x01PING "+payload+"\x01",
+	)
+	if err := <-done; err != nil {
+		t.Fatalf("CTCPPing failed: %v", err)
+	}
+	if rtt < 20*time.Millisecond {
+		t.Fatalf("expected round trip of at least 20ms, got %s", rtt)
+	}
+}
+
+func TestCTCPRateLimitAndErrors(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+	irccon.CTCPRequestInterval = time.Hour
+	irccon.CTCPRequestBurst = 1
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := irccon.CTCP(context.Background(), "ghost", "TIME")
+		done <- err
+	}()
+	nextRawCommand(t, irccon.pwrite)
+	feedLines(t, irccon, ":srv 401 me ghost :No such nick/channel")
+	if err := <-done; !errors.Is(err, ErrNoSuchNick) {
+		t.Fatalf("expected ErrNoSuchNick, got %v", err)
+	}
+
+	// The burst is used up, so the next request waits for the limiter.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := irccon.CTCP(ctx, "bob", "TIME"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected rate-limited request to time out, got %v", err)
+	}
+	select {
+	case got := <-irccon.pwrite:
+		t.Fatalf("expected no request while rate limited, got %q", got)
+	default:
+	}
+}
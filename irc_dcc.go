@@ -135,7 +135,12 @@ func (irc *Connection) InitiateDCCChat(target string) error {
 	port := listener.Addr().(*net.TCPAddr).Port
 	ip := irc.getLocalIP()
 
-	irc.SendRawf("PRIVMSG %s :\001DCC CHAT chat %d %d\001", target, ip2int(ip), port)
+	offer := NewMessage("PRIVMSG", target, EncodeCTCP("DCC", fmt.Sprintf("CHAT chat %d %d", ip2int(ip), port)))
+	offer.ForceTrailing = true
+	if err := irc.SendMessage(offer); err != nil {
+		listener.Close()
+		return err
+	}
 
 	go func() {
 		conn, err := listener.Accept()
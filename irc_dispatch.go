@@ -0,0 +1,403 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"context"
+	"hash/maphash"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDispatchQueueSize is the number of events that may wait for a
+// worker when DispatchWorkers is set and DispatchQueueSize is zero.
+const DefaultDispatchQueueSize = 1024
+
+// DispatchStats describes the callback worker pool of the current
+// connection (see Connection.DispatchWorkers).
+type DispatchStats struct {
+	Workers       int    // workers taking events from the queue
+	Busy          int    // callbacks running right now, replaced workers included
+	Stalled       int    // callbacks running past CallbackTimeout, replaced workers included
+	QueueDepth    int    // events waiting for a worker
+	MaxQueueDepth int    // highest QueueDepth seen
+	QueueCapacity int    // events that can wait before the read loop blocks
+	Dispatched    uint64 // events handed to the pool
+	Blocked       uint64 // events the read loop had to wait to queue
+	SlowCallbacks uint64 // callbacks that returned after CallbackTimeout
+	Replaced      uint64 // stalled workers replaced by a new one
+}
+
+// dispatcher runs the callbacks of incoming events on a fixed set of
+// workers. The library's own handlers and Sync callbacks still run on the
+// read loop, so connection state is updated in order. Only the read loop
+// queues events and it closes the queues when it exits; the workers then
+// drain them and stop.
+//
+// A worker stuck in a callback for longer than CallbackTimeout is retired
+// and a new one takes over its queue, so a callback that never returns
+// cannot fill the queue and stall the read loop. The retired worker exits
+// once its callback returns.
+type dispatcher struct {
+	irc      *Connection
+	queues   []chan dispatchJob // one shared queue, or one per worker when ordered
+	mu       sync.Mutex
+	workers  []*dispatchWorker // current worker of each slot
+	capacity int
+	seed     maphash.Seed
+	stop     chan struct{} // closed by close, stops watch
+
+	depth      atomic.Int64
+	maxDepth   atomic.Int64
+	dispatched atomic.Uint64
+	blocked    atomic.Uint64
+	slow       atomic.Uint64
+	replaced   atomic.Uint64
+	retired    atomic.Int64 // retired workers still running their callback
+}
+
+type dispatchJob struct {
+	event   *Event
+	plan    *callbackPlan
+	release bool // event came from the pool and is recycled after the job
+}
+
+type dispatchWorker struct {
+	// UnixNano start of the running callback, 0 when idle, retiredWorker
+	// once replaced
+	busySince atomic.Int64
+}
+
+const retiredWorker = -1
+
+// startDispatcher starts the worker pool for a connection's read loop.
+func (irc *Connection) startDispatcher() *dispatcher {
+	workers := irc.DispatchWorkers
+	size := irc.DispatchQueueSize
+	if size <= 0 {
+		size = DefaultDispatchQueueSize
+	}
+
+	d := &dispatcher{
+		irc:      irc,
+		workers:  make([]*dispatchWorker, workers),
+		capacity: size,
+		seed:     maphash.MakeSeed(),
+		stop:     make(chan struct{}),
+	}
+	if irc.DispatchOrdered {
+		perWorker := (size + workers - 1) / workers
+		d.capacity = perWorker * workers
+		for i := 0; i < workers; i++ {
+			d.queues = append(d.queues, make(chan dispatchJob, perWorker))
+		}
+	} else {
+		d.queues = []chan dispatchJob{make(chan dispatchJob, size)}
+	}
+
+	for i := range d.workers {
+		d.workers[i] = &dispatchWorker{}
+		go d.run(d.workers[i], d.queues[i%len(d.queues)])
+	}
+	if irc.CallbackTimeout > 0 {
+		go d.watch(irc.CallbackTimeout)
+	}
+
+	irc.Lock()
+	irc.dispatch = d
+	irc.Unlock()
+	return d
+}
+
+// close stops accepting events; queued events are still handled.
+func (d *dispatcher) close() {
+	close(d.stop)
+	for _, queue := range d.queues {
+		close(queue)
+	}
+}
+
+// dispatch runs the read-loop steps of event's callbacks and queues the
+// rest for the workers, waiting when the queue is full. The event is
+// returned to the pool afterwards when release is set.
+func (d *dispatcher) dispatch(event *Event, release bool) {
+	irc := d.irc
+	irc.classifyCTCP(event)
+	plan := irc.callbacksFor(event.Code)
+
+	if irc.VerboseCallbackHandler {
+		irc.Log.Printf("%v (%v) >> %#v\n", eventCodeName(event.Code), plan.count, event)
+	}
+
+	d.runCallbacks(nil, event, plan.syncBefore)
+	d.runCallbacks(nil, event, plan.internal)
+	d.runCallbacks(nil, event, plan.syncAfter)
+	if len(plan.plain) == 0 && len(plan.after) == 0 {
+		if release {
+			releaseEvent(event)
+		}
+		return
+	}
+
+	queue := d.queues[0]
+	if len(d.queues) > 1 {
+		key := strings.ToLower(event.ReplyTarget())
+		if key == "" {
+			key = strings.ToLower(event.Source)
+		}
+		queue = d.queues[maphash.String(d.seed, key)%uint64(len(d.queues))]
+	}
+
+	depth := d.depth.Add(1)
+	for {
+		highest := d.maxDepth.Load()
+		if depth <= highest || d.maxDepth.CompareAndSwap(highest, depth) {
+			break
+		}
+	}
+	d.dispatched.Add(1)
+
+	job := dispatchJob{event: event, plan: plan, release: release}
+	select {
+	case queue <- job:
+	default:
+		d.blocked.Add(1)
+		queue <- job
+	}
+}
+
+func (d *dispatcher) run(w *dispatchWorker, queue <-chan dispatchJob) {
+	for job := range queue {
+		d.depth.Add(-1)
+		d.runCallbacks(w, job.event, job.plan.matching(job.event, job.plan.plain))
+		d.runCallbacks(w, job.event, job.plan.after)
+		if job.release {
+			releaseEvent(job.event)
+		}
+		if w.busySince.Load() == retiredWorker {
+			d.retired.Add(-1)
+			return
+		}
+	}
+}
+
+// watch replaces the workers whose callback has run past timeout.
+func (d *dispatcher) watch(timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().UnixNano()
+		d.mu.Lock()
+		for i, w := range d.workers {
+			since := w.busySince.Load()
+			if since <= 0 || time.Duration(now-since) <= timeout {
+				continue
+			}
+			// Fails if the callback returned in the meantime
+			if !w.busySince.CompareAndSwap(since, retiredWorker) {
+				continue
+			}
+			d.retired.Add(1)
+			d.replaced.Add(1)
+			d.workers[i] = &dispatchWorker{}
+			go d.run(d.workers[i], d.queues[i%len(d.queues)])
+			d.irc.Log.Printf("Replaced dispatch worker stuck in a callback for %s\n", time.Duration(now-since))
+		}
+		d.mu.Unlock()
+	}
+}
+
+// runCallbacks runs callbacks one after another, each with its own
+// CallbackTimeout context. w is nil on the read loop.
+func (d *dispatcher) runCallbacks(w *dispatchWorker, event *Event, callbacks []callbackEntry) {
+	irc := d.irc
+	parent := event.Ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	defer func() { event.Ctx = parent }()
+	for _, callback := range callbacks {
+		ctx, cancel := parent, context.CancelFunc(nil)
+		if irc.CallbackTimeout != 0 {
+			ctx, cancel = context.WithTimeout(ctx, irc.CallbackTimeout)
+		}
+		event.Ctx = ctx
+
+		start := time.Now()
+		if w != nil {
+			// A retired worker stays retired
+			w.busySince.CompareAndSwap(0, start.UnixNano())
+		}
+		irc.invokeCallback(callback, event)
+		if w != nil {
+			w.busySince.CompareAndSwap(start.UnixNano(), 0)
+		}
+
+		if cancel != nil {
+			cancel()
+			if elapsed := time.Since(start); elapsed > irc.CallbackTimeout {
+				d.slow.Add(1)
+				irc.Log.Printf("Slow callback %s finished in %s >> %#v\n",
+					getFunctionName(callback.cb), elapsed, event)
+			}
+		}
+	}
+}
+
+func (d *dispatcher) stats() DispatchStats {
+	s := DispatchStats{
+		Workers:       len(d.workers),
+		Busy:          int(d.retired.Load()),
+		Stalled:       int(d.retired.Load()),
+		QueueCapacity: d.capacity,
+		MaxQueueDepth: int(d.maxDepth.Load()),
+		Dispatched:    d.dispatched.Load(),
+		Blocked:       d.blocked.Load(),
+		SlowCallbacks: d.slow.Load(),
+		Replaced:      d.replaced.Load(),
+	}
+	for _, queue := range d.queues {
+		s.QueueDepth += len(queue)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now().UnixNano()
+	for _, w := range d.workers {
+		since := w.busySince.Load()
+		if since <= 0 {
+			continue
+		}
+		s.Busy++
+		if d.irc.CallbackTimeout != 0 && time.Duration(now-since) > d.irc.CallbackTimeout {
+			s.Stalled++
+		}
+	}
+	return s
+}
+
+// DispatchStats returns the state of the callback worker pool of the
+// current or last connection. It is the zero value when DispatchWorkers
+// is not set.
+func (irc *Connection) DispatchStats() DispatchStats {
+	irc.Lock()
+	d := irc.dispatch
+	irc.Unlock()
+	if d == nil {
+		return DispatchStats{}
+	}
+	return d.stats()
+}
+
+// deliverEvent runs the callbacks of an incoming event, on the worker
+// pool when there is one.
+func (irc *Connection) deliverEvent(d *dispatcher, event *Event) {
+	if chain, _ := irc.middleware(); len(chain) > 0 {
+		irc.deliverFiltered(chain, d, event)
+		return
+	}
+	if d != nil {
+		d.dispatch(event, irc.PoolEvents)
+		return
+	}
+	if irc.runCallbacks(event) && irc.PoolEvents {
+		releaseEvent(event)
+	}
+}
+
+// localEvents holds events raised by the library itself, such as
+// EventRegistered, until the read loop delivers them. They then take the
+// same path as lines from the server, worker pool included, and a handler
+// raising one does not run its callbacks from inside its own.
+type localEvents struct {
+	mu     sync.Mutex
+	events []*Event
+	ready  chan struct{} // signalled when events were queued
+}
+
+func (q *localEvents) push(event *Event) {
+	q.mu.Lock()
+	q.events = append(q.events, event)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *localEvents) take() []*Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	events := q.events
+	q.events = nil
+	return events
+}
+
+// startLocalEvents makes queueEvent hand events to the read loop.
+func (irc *Connection) startLocalEvents() *localEvents {
+	q := &localEvents{ready: make(chan struct{}, 1)}
+	irc.Lock()
+	irc.local = q
+	irc.Unlock()
+	return q
+}
+
+// stopLocalEvents makes queueEvent run callbacks directly again and
+// delivers what is still queued. It runs when the read loop exits.
+func (irc *Connection) stopLocalEvents(d *dispatcher, q *localEvents) {
+	irc.Lock()
+	if irc.local == q {
+		irc.local = nil
+	}
+	irc.Unlock()
+	irc.deliverLocal(d, q)
+}
+
+// deliverLocal delivers the queued events, including those raised while
+// delivering them.
+func (irc *Connection) deliverLocal(d *dispatcher, q *localEvents) {
+	for events := q.take(); len(events) > 0; events = q.take() {
+		for _, event := range events {
+			irc.deliverEvent(d, event)
+		}
+	}
+}
+
+// queueEvent delivers an event raised by the library through the read loop
+// after the event being handled. Without a read loop, e.g. while
+// connecting or reconnecting, its callbacks run on the calling goroutine.
+func (irc *Connection) queueEvent(event *Event) {
+	irc.Lock()
+	q := irc.local
+	irc.Unlock()
+	if q == nil {
+		irc.RunCallbacks(event)
+		return
+	}
+	q.push(event)
+}
@@ -0,0 +1,187 @@
+package irc
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcherSlowCallbackDoesNotBlockReading(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.DispatchWorkers = 2
+	irccon.CallbackTimeout = 20 * time.Millisecond
+
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+	irccon.AddCallback("SLOW", func(*Event) { <-release })
+	fast := make(chan string, 10)
+	irccon.AddCallback("FAST", func(e *Event) { fast <- e.Message() })
+
+	server := startReadLoop(t, irccon)
+	go server.Write([]byte(":srv SLOW me\r\n:srv FAST me :one\r\n:srv FAST me :two\r\n"))
+
+	for _, want := range []string{"one", "two"} {
+		select {
+		case got := <-fast:
+			if got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("FAST events were not handled while SLOW was running")
+		}
+	}
+
+	time.Sleep(2 * irccon.CallbackTimeout)
+	stats := irccon.DispatchStats()
+	if stats.Workers != 2 || stats.Busy != 1 || stats.Stalled != 1 || stats.Dispatched != 3 {
+		t.Fatalf("unexpected stats while SLOW runs: %+v", stats)
+	}
+
+	releaseOnce()
+	deadline := time.Now().Add(2 * time.Second)
+	for irccon.DispatchStats().SlowCallbacks != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("slow callback not counted: %+v", irccon.DispatchStats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := irccon.DispatchStats(); stats.Busy != 0 || stats.QueueDepth != 0 {
+		t.Fatalf("pool not idle after release: %+v", stats)
+	}
+}
+
+func TestDispatcherOrderedPerTarget(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.DispatchWorkers = 4
+	irccon.DispatchOrdered = true
+	irccon.PoolEvents = true
+
+	var mu sync.Mutex
+	seen := make(map[string][]string)
+	done := make(chan struct{}, 200)
+	irccon.AddCallback("PRIVMSG", func(e *Event) {
+		mu.Lock()
+		seen[e.ReplyTarget()] = append(seen[e.ReplyTarget()], e.Message())
+		mu.Unlock()
+		done <- struct{}{}
+	})
+	server := startReadLoop(t, irccon)
+
+	var lines []byte
+	for i := 0; i < 50; i++ {
+		for _, target := range []string{"#a", "#B", "#c", "me"} {
+			lines = fmt.Appendf(lines, ":nick!u@h PRIVMSG %s :%d\r\n", target, i)
+		}
+	}
+	go server.Write(lines)
+
+	for i := 0; i < 200; i++ {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d of 200 events handled", i)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for target, messages := range seen {
+		for i, msg := range messages {
+			if msg != fmt.Sprint(i) {
+				t.Fatalf("%s handled out of order: %v", target, messages)
+			}
+		}
+	}
+	if len(seen) != 4 {
+		t.Fatalf("unexpected targets %v", seen)
+	}
+}
+
+func TestDispatcherQueueBackpressure(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.DispatchWorkers = 1
+	irccon.DispatchQueueSize = 2
+
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+	handled := make(chan struct{}, 10)
+	irccon.AddCallback("FOO", func(*Event) {
+		<-release
+		handled <- struct{}{}
+	})
+	server := startReadLoop(t, irccon)
+	go server.Write([]byte(":srv FOO a\r\n:srv FOO b\r\n:srv FOO c\r\n:srv FOO d\r\n"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for irccon.DispatchStats().Blocked == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("read loop did not block on a full queue: %+v", irccon.DispatchStats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := irccon.DispatchStats(); stats.QueueDepth != 2 || stats.QueueCapacity != 2 {
+		t.Fatalf("unexpected queue stats %+v", stats)
+	}
+
+	releaseOnce()
+	for i := 0; i < 4; i++ {
+		select {
+		case <-handled:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d of 4 events handled", i)
+		}
+	}
+	if stats := irccon.DispatchStats(); stats.MaxQueueDepth < 2 {
+		t.Fatalf("MaxQueueDepth = %d, want at least 2", stats.MaxQueueDepth)
+	}
+}
+
+func TestDispatcherReplacesHungWorker(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.DispatchWorkers = 1
+	irccon.DispatchOrdered = true
+	irccon.DispatchQueueSize = 1
+	irccon.CallbackTimeout = 20 * time.Millisecond
+	irccon.pwrite = make(chan string, 10)
+
+	hang := make(chan struct{})
+	defer close(hang)
+	handled := make(chan string, 10)
+	irccon.AddCallback("PRIVMSG", func(e *Event) {
+		if e.Message() == "hang" {
+			<-hang
+			return
+		}
+		handled <- e.Message()
+	})
+
+	server := startReadLoop(t, irccon)
+	go server.Write([]byte(":a!u@h PRIVMSG #go :hang\r\n" +
+		":a!u@h PRIVMSG #go :one\r\n:a!u@h PRIVMSG #go :two\r\n:a!u@h PRIVMSG #go :three\r\n" +
+		":srv PING :alive\r\n"))
+
+	select {
+	case line := <-irccon.pwrite:
+		if line != "PONG :alive\r\n" {
+			t.Fatalf("sent %q, want the PONG", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("a callback that never returns stalled the read loop")
+	}
+	for _, want := range []string{"one", "two", "three"} {
+		select {
+		case got := <-handled:
+			if got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("events behind the hung callback were not handled")
+		}
+	}
+	if stats := irccon.DispatchStats(); stats.Replaced != 1 || stats.Stalled != 1 || stats.Workers != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMalformedEvent is returned by the typed event views (AsKick, AsMode,
+// ...) when the event has the wrong code or too few arguments.
+var ErrMalformedEvent = errors.New("malformed event")
+
+// Defaults used when the server did not advertise the ISUPPORT tokens.
+const (
+	defaultChanTypes = "#&"
+	defaultChanModes = "beI,k,l,imnpst"
+	defaultPrefix    = "(ov)@+"
+)
+
+// IsChannel reports whether name is a channel name according to the
+// server's CHANTYPES (default "#&").
+func (irc *Connection) IsChannel(name string) bool {
+	chantypes := defaultChanTypes
+	if irc != nil {
+		if v, ok := irc.ISupport("CHANTYPES"); ok {
+			chantypes = v
+		}
+	}
+	return name != "" && strings.IndexByte(chantypes, name[0]) >= 0
+}
+
+// stripStatusPrefix removes a STATUSMSG prefix ("@#chan" -> "#chan").
+func (irc *Connection) stripStatusPrefix(target string) string {
+	if irc == nil || len(target) < 2 {
+		return target
+	}
+	statusmsg, _ := irc.ISupport("STATUSMSG")
+	if statusmsg != "" && strings.IndexByte(statusmsg, target[0]) >= 0 {
+		return target[1:]
+	}
+	return target
+}
+
+// Target returns the first argument of the event: the channel or nick a
+// PRIVMSG, NOTICE or CTCP was sent to, or the channel of a JOIN, PART,
+// KICK, TOPIC or MODE. It is empty when the event has no arguments.
+func (e *Event) Target() string {
+	if len(e.Arguments) == 0 {
+		return ""
+	}
+	return e.Arguments[0]
+}
+
+// IsChannelMessage reports whether the event was sent to a channel
+// (including STATUSMSG targets such as "@#chan") rather than to us.
+func (e *Event) IsChannelMessage() bool {
+	return e.Connection.IsChannel(e.Connection.stripStatusPrefix(e.Target()))
+}
+
+// ReplyTarget returns where a reply to the event should go: the channel
+// for channel messages, otherwise the sender's nick (or the original
+// target for our own Self events).
+func (e *Event) ReplyTarget() string {
+	if e.IsChannelMessage() {
+		return e.Connection.stripStatusPrefix(e.Target())
+	}
+	if e.Self {
+		return e.Target()
+	}
+	return e.Nick
+}
+
+// Reply sends text to ReplyTarget. Replies to NOTICE events are sent as
+// NOTICE, as RFC 2812 forbids automatic replies to notices.
+func (e *Event) Reply(text string) error {
+	if e.Connection == nil {
+		return errors.New("event has no connection")
+	}
+	target := e.ReplyTarget()
+	if target == "" {
+		return fmt.Errorf("%w: no reply target for %s", ErrMalformedEvent, e.Code)
+	}
+	if e.Code == "NOTICE" || strings.HasPrefix(e.Code, "CTCPREPLY") {
+		e.Connection.Notice(target, text)
+	} else {
+		e.Connection.Privmsg(target, text)
+	}
+	return nil
+}
+
+// Account returns the sender's services account from the IRCv3
+// account-tag, or an empty string when the tag is absent.
+func (e *Event) Account() string {
+	account, _ := e.Tag("account")
+	return account
+}
+
+// MsgID returns the IRCv3 msgid tag, or an empty string when absent.
+func (e *Event) MsgID() string {
+	msgID, _ := e.Tag("msgid")
+	return msgID
+}
+
+// checkEvent validates the code and argument count for a typed view.
+func (e *Event) checkEvent(minArgs int, codes ...string) error {
+	for _, code := range codes {
+		if e.Code == code {
+			if len(e.Arguments) < minArgs {
+				return fmt.Errorf("%w: %s needs %d arguments, got %d", ErrMalformedEvent, e.Code, minArgs, len(e.Arguments))
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not a %s event", ErrMalformedEvent, e.Code, strings.Join(codes, "/"))
+}
+
+// KickEvent is the typed view of a KICK.
+type KickEvent struct {
+	Channel string
+	Nick    string // who was kicked
+	Reason  string
+	By      string // who kicked
+}
+
+// AsKick returns the KICK details.
+//
+// Format: :<by> KICK <channel> <nick> [:<reason>]
+func (e *Event) AsKick() (*KickEvent, error) {
+	if err := e.checkEvent(2, "KICK"); err != nil {
+		return nil, err
+	}
+	k := &KickEvent{Channel: e.Arguments[0], Nick: e.Arguments[1], By: e.Nick}
+	if len(e.Arguments) > 2 {
+		k.Reason = e.Arguments[2]
+	}
+	return k, nil
+}
+
+// TopicEvent is the typed view of a TOPIC change or RPL_TOPIC (332).
+type TopicEvent struct {
+	Channel string
+	Topic   string
+	By      string // empty for RPL_TOPIC
+}
+
+// AsTopic returns the topic details of a TOPIC or 332 event.
+//
+// Format: :<by> TOPIC <channel> :<topic>
+// Format: :server 332 <me> <channel> :<topic>
+func (e *Event) AsTopic() (*TopicEvent, error) {
+	if err := e.checkEvent(2, "TOPIC", RPL_TOPIC); err != nil {
+		return nil, err
+	}
+	if e.Code == RPL_TOPIC {
+		if len(e.Arguments) < 3 {
+			return nil, fmt.Errorf("%w: 332 needs 3 arguments, got %d", ErrMalformedEvent, len(e.Arguments))
+		}
+		return &TopicEvent{Channel: e.Arguments[1], Topic: e.Arguments[2]}, nil
+	}
+	return &TopicEvent{Channel: e.Arguments[0], Topic: e.Arguments[1], By: e.Nick}, nil
+}
+
+// InviteEvent is the typed view of an INVITE.
+type InviteEvent struct {
+	Nick    string // who was invited, usually us
+	Channel string
+	By      string
+}
+
+// AsInvite returns the INVITE details.
+//
+// Format: :<by> INVITE <nick> <channel>
+func (e *Event) AsInvite() (*InviteEvent, error) {
+	if err := e.checkEvent(2, "INVITE"); err != nil {
+		return nil, err
+	}
+	return &InviteEvent{Nick: e.Arguments[0], Channel: e.Arguments[1], By: e.Nick}, nil
+}
+
+// NickEvent is the typed view of a NICK change.
+type NickEvent struct {
+	Old string
+	New string
+}
+
+// AsNick returns the old and new nick of a NICK event.
+//
+// Format: :<old>!user@host NICK <new>
+func (e *Event) AsNick() (*NickEvent, error) {
+	if err := e.checkEvent(1, "NICK"); err != nil {
+		return nil, err
+	}
+	if e.Nick == "" {
+		return nil, fmt.Errorf("%w: NICK without a source nick", ErrMalformedEvent)
+	}
+	return &NickEvent{Old: e.Nick, New: e.Arguments[0]}, nil
+}
+
+// ModeChange is a single mode set or unset by a MODE event.
+type ModeChange struct {
+	Adding bool   // '+' or '-'
+	Mode   byte   // mode letter
+	Param  string // parameter, if the mode takes one
+}
+
+// ModeEvent is the typed view of a MODE.
+type ModeEvent struct {
+	Target  string // channel or nick
+	By      string // nick or server that set the modes
+	Changes []ModeChange
+}
+
+// AsMode returns the mode changes of a MODE event. Channel mode parameters
+// are assigned using the server's CHANMODES and PREFIX tokens.
+//
+// Format: :<by> MODE <target> <modes> [<param>...]
+func (e *Event) AsMode() (*ModeEvent, error) {
+	if err := e.checkEvent(2, "MODE"); err != nil {
+		return nil, err
+	}
+	m := &ModeEvent{Target: e.Arguments[0], By: e.Nick}
+	if m.By == "" {
+		m.By = e.Source
+	}
+
+	var alwaysParam, setParam string
+	if e.Connection.IsChannel(m.Target) {
+		alwaysParam, setParam = e.Connection.channelModeParams()
+	}
+
+	params := e.Arguments[2:]
+	adding := true
+	for i := 0; i < len(e.Arguments[1]); i++ {
+		c := e.Arguments[1][i]
+		switch c {
+		case '+':
+			adding = true
+			continue
+		case '-':
+			adding = false
+			continue
+		}
+		change := ModeChange{Adding: adding, Mode: c}
+		if strings.IndexByte(alwaysParam, c) >= 0 || (adding && strings.IndexByte(setParam, c) >= 0) {
+			if len(params) == 0 {
+				return nil, fmt.Errorf("%w: MODE %c is missing its parameter", ErrMalformedEvent, c)
+			}
+			change.Param = params[0]
+			params = params[1:]
+		}
+		m.Changes = append(m.Changes, change)
+	}
+	return m, nil
+}
+
+// channelModeParams returns the channel modes that always take a parameter
+// (list modes, CHANMODES type B and PREFIX modes) and those that take one
+// only when set (CHANMODES type C).
+func (irc *Connection) channelModeParams() (always, whenSet string) {
+	chanmodes := defaultChanModes
+	if irc != nil {
+		if v, ok := irc.ISupport("CHANMODES"); ok {
+			chanmodes = v
+		}
+	}
+
+	types := strings.SplitN(chanmodes, ",", 4)
+	for len(types) < 3 {
+		types = append(types, "")
+	}
+	prefixModes, _ := irc.prefixModes()
+	always = types[0] + types[1] + prefixModes
+	whenSet = types[2]
+	return always, whenSet
+}
+
+// prefixModes returns the channel membership modes and their prefix
+// symbols from PREFIX (default "(ov)@+"), highest rank first.
+func (irc *Connection) prefixModes() (modes, symbols string) {
+	prefix := defaultPrefix
+	if irc != nil {
+		if v, ok := irc.ISupport("PREFIX"); ok {
+			prefix = v
+		}
+	}
+	if strings.HasPrefix(prefix, "(") {
+		if end := strings.IndexByte(prefix, ')'); end > 0 {
+			return prefix[1:end], prefix[end+1:]
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,132 @@
+package irc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func parseTestEvent(t *testing.T, irccon *Connection, line string) *Event {
+	t.Helper()
+	event, err := parseToEvent(line)
+	if err != nil {
+		t.Fatalf("parseToEvent(%q) failed: %v", line, err)
+	}
+	event.Connection = irccon
+	return event
+}
+
+func TestEventTargetAndReply(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+	feedLines(t, irccon, ":srv 005 me CHANTYPES=#! STATUSMSG=@+ :are supported by this server")
+
+	cases := []struct {
+		line      string
+		channel   bool
+		replyTo   string
+		replySent string
+	}{
+		{":bob!b@h PRIVMSG #go :hi", true, "#go", "PRIVMSG #go :pong\r\n"},
+		{":bob!b@h PRIVMSG !safe :hi", true, "!safe", "PRIVMSG !safe :pong\r\n"},
+		{":bob!b@h PRIVMSG @#go :ops only", true, "#go", "PRIVMSG #go :pong\r\n"},
+		{":bob!b@h PRIVMSG &local :hi", false, "bob", "PRIVMSG bob :pong\r\n"},
+		{":bob!b@h PRIVMSG me :hi", false, "bob", "PRIVMSG bob :pong\r\n"},
+		{":bob!b@h NOTICE me :hi", false, "bob", "NOTICE bob :pong\r\n"},
+	}
+	for _, c := range cases {
+		e := parseTestEvent(t, irccon, c.line)
+		if got := e.IsChannelMessage(); got != c.channel {
+			t.Errorf("%q: IsChannelMessage() = %v, want %v", c.line, got, c.channel)
+		}
+		if got := e.ReplyTarget(); got != c.replyTo {
+			t.Errorf("%q: ReplyTarget() = %q, want %q", c.line, got, c.replyTo)
+		}
+		if err := e.Reply("pong"); err != nil {
+			t.Fatalf("%q: Reply failed: %v", c.line, err)
+		}
+		if got := nextRawCommand(t, irccon.pwrite); got != c.replySent {
+			t.Errorf("%q: Reply sent %q, want %q", c.line, got, c.replySent)
+		}
+	}
+
+	if err := (&Event{Code: "PRIVMSG"}).Reply("x"); err == nil {
+		t.Fatal("expected Reply without a connection to fail")
+	}
+}
+
+func TestEventTags(t *testing.T) {
+	e := parseTestEvent(t, nil, "@account=alice;msgid=abc123 :alice!a@h PRIVMSG #go :hi")
+	if e.Account() != "alice" || e.MsgID() != "abc123" {
+		t.Fatalf("unexpected tags: account %q msgid %q", e.Account(), e.MsgID())
+	}
+	if e.Target() != "#go" || !e.IsChannelMessage() {
+		t.Fatal("expected default CHANTYPES without a connection")
+	}
+	if plain := parseTestEvent(t, nil, ":alice!a@h PRIVMSG #go :hi"); plain.Account() != "" || plain.MsgID() != "" {
+		t.Fatal("expected empty tags")
+	}
+}
+
+func TestTypedEventViews(t *testing.T) {
+	kick, err := parseTestEvent(t, nil, ":op!o@h KICK #go bob :spam").AsKick()
+	if err != nil || *kick != (KickEvent{Channel: "#go", Nick: "bob", Reason: "spam", By: "op"}) {
+		t.Fatalf("unexpected KICK view %+v, %v", kick, err)
+	}
+	topic, err := parseTestEvent(t, nil, ":srv 332 me #go :Welcome").AsTopic()
+	if err != nil || *topic != (TopicEvent{Channel: "#go", Topic: "Welcome"}) {
+		t.Fatalf("unexpected 332 view %+v, %v", topic, err)
+	}
+	invite, err := parseTestEvent(t, nil, ":bob!b@h INVITE me #go").AsInvite()
+	if err != nil || *invite != (InviteEvent{Nick: "me", Channel: "#go", By: "bob"}) {
+		t.Fatalf("unexpected INVITE view %+v, %v", invite, err)
+	}
+	nick, err := parseTestEvent(t, nil, ":bob!b@h NICK robert").AsNick()
+	if err != nil || *nick != (NickEvent{Old: "bob", New: "robert"}) {
+		t.Fatalf("unexpected NICK view %+v, %v", nick, err)
+	}
+
+	malformed := []struct {
+		line string
+		view func(*Event) error
+	}{
+		{":op!o@h KICK #go", func(e *Event) error { _, err := e.AsKick(); return err }},
+		{":op!o@h PRIVMSG #go :hi", func(e *Event) error { _, err := e.AsKick(); return err }},
+		{":srv 332 me #go", func(e *Event) error { _, err := e.AsTopic(); return err }},
+		{":bob!b@h INVITE me", func(e *Event) error { _, err := e.AsInvite(); return err }},
+		{"NICK robert", func(e *Event) error { _, err := e.AsNick(); return err }},
+		{":op!o@h MODE #go", func(e *Event) error { _, err := e.AsMode(); return err }},
+		{":op!o@h MODE #go +o", func(e *Event) error { _, err := e.AsMode(); return err }},
+	}
+	for _, m := range malformed {
+		if err := m.view(parseTestEvent(t, nil, m.line)); !errors.Is(err, ErrMalformedEvent) {
+			t.Errorf("%q: expected ErrMalformedEvent, got %v", m.line, err)
+		}
+	}
+}
+
+func TestModeViewUsesISupport(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	feedLines(t, irccon, ":srv 005 me CHANMODES=beIq,k,lf,imnpst PREFIX=(qaohv)~&@%+ :are supported by this server")
+
+	mode, err := parseTestEvent(t, irccon, ":op!o@h MODE #go +qlk-l+h-k owner 50 key helper key").AsMode()
+	if err != nil {
+		t.Fatalf("AsMode failed: %v", err)
+	}
+	want := []ModeChange{
+		{true, 'q', "owner"},
+		{true, 'l', "50"},
+		{true, 'k', "key"},
+		{false, 'l', ""},
+		{true, 'h', "helper"},
+		{false, 'k', "key"},
+	}
+	if mode.Target != "#go" || mode.By != "op" || !reflect.DeepEqual(mode.Changes, want) {
+		t.Fatalf("unexpected MODE view %+v", mode)
+	}
+
+	user, err := parseTestEvent(t, irccon, ":me MODE me +iw").AsMode()
+	if err != nil || len(user.Changes) != 2 || user.Changes[1].Param != "" || user.By != "me" {
+		t.Fatalf("unexpected user MODE view %+v, %v", user, err)
+	}
+}
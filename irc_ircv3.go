@@ -0,0 +1,146 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import "strings"
+
+// IRCv3 capabilities with dedicated helpers on Event.
+// Pass them to RequestCap to have them negotiated on connect.
+const (
+	CapAccountNotify = "account-notify" // ACCOUNT events, see Event.AccountNotify
+	CapAwayNotify    = "away-notify"    // AWAY events, see Event.AwayMessage
+	CapChghost       = "chghost"        // CHGHOST events, see Event.ChangedHost
+	CapExtendedJoin  = "extended-join"  // JOIN account/realname, see Event.ExtendedJoin
+	CapSetname       = "setname"        // SETNAME events, see Event.NewRealName
+	CapEchoMessage   = "echo-message"   // server echoes our own PRIVMSG/NOTICE
+)
+
+// RequestCap adds capabilities to RequestCaps so they are requested during
+// the next CAP negotiation. Capabilities already listed are not duplicated.
+// Call it before Connect; capabilities the server does not advertise are
+// silently skipped.
+func (irc *Connection) RequestCap(caps ...string) {
+	irc.Lock()
+	defer irc.Unlock()
+
+	for _, c := range caps {
+		if c == "" || capListContains(irc.RequestCaps, c) {
+			continue
+		}
+		irc.RequestCaps = append(irc.RequestCaps, c)
+	}
+}
+
+// capsToRequest returns the capabilities to request for the current
+// session: everything in RequestCaps plus the ones implied by other
+// settings (sasl for UseSASL).
+func (irc *Connection) capsToRequest() []string {
+	irc.Lock()
+	defer irc.Unlock()
+
+	var caps []string
+	for _, c := range irc.RequestCaps {
+		if c != "" && !capListContains(caps, c) {
+			caps = append(caps, c)
+		}
+	}
+	if irc.UseSASL && !capListContains(caps, "sasl") {
+		caps = append(caps, "sasl")
+	}
+	return caps
+}
+
+// capName strips a CAP 302 value ("sasl=PLAIN,EXTERNAL" -> "sasl").
+func capName(token string) string {
+	if i := strings.IndexByte(token, '='); i >= 0 {
+		return token[:i]
+	}
+	return token
+}
+
+func capListContains(caps []string, name string) bool {
+	for _, c := range caps {
+		if capName(c) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isCapLSContinuation reports whether a CAP LS/LIST reply is followed by
+// more lines (CAP 302 "CAP <nick> LS * :caps...").
+func isCapLSContinuation(e *Event) bool {
+	return len(e.Arguments) >= 4 && e.Arguments[2] == "*"
+}
+
+// AccountNotify returns the account carried by an ACCOUNT event
+// (account-notify). loggedIn is false when the user logged out ("*") or
+// when the event is not an ACCOUNT event.
+func (e *Event) AccountNotify() (account string, loggedIn bool) {
+	if e.Code != "ACCOUNT" || len(e.Arguments) < 1 {
+		return "", false
+	}
+	account = e.Arguments[0]
+	if account == "*" || account == "" {
+		return "", false
+	}
+	return account, true
+}
+
+// AwayMessage returns the away message carried by an AWAY event
+// (away-notify). away is false when the user came back or when the event
+// is not an AWAY event.
+func (e *Event) AwayMessage() (message string, away bool) {
+	if e.Code != "AWAY" || len(e.Arguments) < 1 || e.Arguments[0] == "" {
+		return "", false
+	}
+	return e.Arguments[0], true
+}
+
+// ChangedHost returns the new username and hostname carried by a CHGHOST
+// event. ok is false for other events or malformed CHGHOST lines.
+func (e *Event) ChangedHost() (user, host string, ok bool) {
+	if e.Code != "CHGHOST" || len(e.Arguments) < 2 {
+		return "", "", false
+	}
+	return e.Arguments[0], e.Arguments[1], true
+}
+
+// NewRealName returns the realname carried by a SETNAME event.
+// ok is false for other events.
+func (e *Event) NewRealName() (realname string, ok bool) {
+	if e.Code != "SETNAME" || len(e.Arguments) < 1 {
+		return "", false
+	}
+	return e.Arguments[0], true
+}
+
+// ExtendedJoin returns the account and realname carried by a JOIN sent
+// with extended-join. account is empty when the user is not logged in.
+// ok is false for other events and for plain JOIN lines.
+func (e *Event) ExtendedJoin() (account, realname string, ok bool) {
+	if e.Code != "JOIN" || len(e.Arguments) < 3 {
+		return "", "", false
+	}
+	account = e.Arguments[1]
+	if account == "*" {
+		account = ""
+	}
+	return account, e.Arguments[2], true
+}
@@ -0,0 +1,88 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIRCv3EventHelpers(t *testing.T) {
+	parse := func(line string) *Event {
+		t.Helper()
+		e, err := parseToEvent(line)
+		if err != nil {
+			t.Fatalf("parseToEvent(%q) failed: %v", line, err)
+		}
+		return e
+	}
+
+	if account, ok := parse(":nick!u@h ACCOUNT alice").AccountNotify(); !ok || account != "alice" {
+		t.Fatalf("AccountNotify() = %q, %v", account, ok)
+	}
+	if account, ok := parse(":nick!u@h ACCOUNT *").AccountNotify(); ok || account != "" {
+		t.Fatalf("AccountNotify() on logout = %q, %v", account, ok)
+	}
+	if msg, away := parse(":nick!u@h AWAY :gone fishing").AwayMessage(); !away || msg != "gone fishing" {
+		t.Fatalf("AwayMessage() = %q, %v", msg, away)
+	}
+	if _, away := parse(":nick!u@h AWAY").AwayMessage(); away {
+		t.Fatal("AwayMessage() reported away for a back notification")
+	}
+	if user, host, ok := parse(":nick!u@h CHGHOST newuser new.host").ChangedHost(); !ok || user != "newuser" || host != "new.host" {
+		t.Fatalf("ChangedHost() = %q, %q, %v", user, host, ok)
+	}
+	if name, ok := parse(":nick!u@h SETNAME :New Name").NewRealName(); !ok || name != "New Name" {
+		t.Fatalf("NewRealName() = %q, %v", name, ok)
+	}
+	if account, name, ok := parse(":nick!u@h JOIN #chan * :Real Name").ExtendedJoin(); !ok || account != "" || name != "Real Name" {
+		t.Fatalf("ExtendedJoin() = %q, %q, %v", account, name, ok)
+	}
+	if _, _, ok := parse(":nick!u@h JOIN #chan").ExtendedJoin(); ok {
+		t.Fatal("ExtendedJoin() accepted a plain JOIN")
+	}
+}
+
+func TestNegotiateCapsRequestsUserCapsAcrossMultilineLS(t *testing.T) {
+	irccon := IRC("capbot", "capuser")
+	irccon.pwrite = make(chan string, 16)
+	irccon.RequestCap(CapAccountNotify, CapExtendedJoin, CapAccountNotify, "unsupported-cap")
+
+	done := make(chan error, 1)
+	go func() { done <- irccon.negotiateCaps() }()
+
+	if got := nextRawCommand(t, irccon.pwrite); got != "CAP LS 302\r\n" {
+		t.Fatalf("first command = %q, want CAP LS 302", got)
+	}
+
+	irccon.RunCallbacks(&Event{Code: "CAP", Arguments: []string{"*", "LS", "*", "account-notify multi-prefix"}})
+	irccon.RunCallbacks(&Event{Code: "CAP", Arguments: []string{"*", "LS", "extended-join sasl=PLAIN"}})
+
+	for _, want := range []string{
+		"CAP REQ :account-notify\r\n",
+		"CAP REQ :extended-join\r\n",
+	} {
+		if got := nextRawCommand(t, irccon.pwrite); got != want {
+			t.Fatalf("command = %q, want %q", got, want)
+		}
+	}
+	assertRegistrationCommands(t, irccon.pwrite, "capbot", "capuser")
+
+	irccon.RunCallbacks(&Event{Code: "CAP", Arguments: []string{"capbot", "ACK", "account-notify extended-join"}})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("negotiateCaps failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("negotiateCaps did not finish after ACK")
+	}
+	if got := nextRawCommand(t, irccon.pwrite); got != "CAP END\r\n" {
+		t.Fatalf("command = %q, want CAP END", got)
+	}
+	if !irccon.hasCap(CapAccountNotify) || !irccon.hasCap(CapExtendedJoin) {
+		t.Fatalf("AcknowledgedCaps = %v", irccon.AcknowledgedCaps)
+	}
+	if len(irccon.RequestCaps) != 3 {
+		t.Fatalf("RequestCaps = %v, want user caps preserved without duplicates", irccon.RequestCaps)
+	}
+}
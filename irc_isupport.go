@@ -0,0 +1,101 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ISupport returns the value of a token advertised by the server in
+// RPL_ISUPPORT (005) during the current session, e.g. ISupport("NICKLEN").
+// ok is false when the server did not advertise the token. Tokens without
+// a value ("WHOX") are reported with an empty value and ok set to true.
+//
+// This method is thread-safe.
+func (irc *Connection) ISupport(token string) (value string, ok bool) {
+	irc.Lock()
+	defer irc.Unlock()
+	value, ok = irc.isupport[strings.ToUpper(token)]
+	return value, ok
+}
+
+// isupportInt returns a numeric ISUPPORT token, or def when the token is
+// missing, empty or not a positive number.
+func (irc *Connection) isupportInt(token string, def int) int {
+	irc.Lock()
+	defer irc.Unlock()
+	return irc.isupportIntLocked(token, def)
+}
+
+// isupportIntLocked is isupportInt for callers holding irc.Lock.
+func (irc *Connection) isupportIntLocked(token string, def int) int {
+	value, ok := irc.isupport[strings.ToUpper(token)]
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// parseISupportLocked records the tokens of an RPL_ISUPPORT (005) reply.
+// The caller must hold irc.Lock.
+//
+// Format: :server 005 <nick> TOKEN TOKEN=value -TOKEN :are supported by this server
+func (irc *Connection) parseISupportLocked(e *Event) {
+	if len(e.Arguments) < 3 {
+		return
+	}
+	if irc.isupport == nil {
+		irc.isupport = make(map[string]string)
+	}
+	for _, token := range e.Arguments[1 : len(e.Arguments)-1] {
+		if token == "" {
+			continue
+		}
+		if token[0] == '-' {
+			delete(irc.isupport, strings.ToUpper(token[1:]))
+			continue
+		}
+		name, value, _ := strings.Cut(token, "=")
+		irc.isupport[strings.ToUpper(name)] = unescapeISupportValue(value)
+	}
+}
+
+// unescapeISupportValue decodes the \xHH escapes allowed in ISUPPORT values.
+func unescapeISupportValue(value string) string {
+	if !strings.Contains(value, `\x`) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) && value[i+1] == 'x' {
+			if n, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
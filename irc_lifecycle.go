@@ -0,0 +1,144 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Connection lifecycle events. Like EventDisconnected they are run by the
+// library itself, in this order for each connection, and carry their
+// details in Arguments. Those raised while the read loop runs are
+// delivered by it like lines from the server, on the DispatchWorkers pool
+// when there is one.
+const (
+	// EventConnected: the TCP (or proxy) connection is established.
+	// Arguments: server, remote address.
+	EventConnected = "CONNECTED"
+
+	// EventTLSConnected: the TLS handshake completed.
+	// Arguments: server, TLS version, cipher suite.
+	EventTLSConnected = "TLS_CONNECTED"
+
+	// EventCapNegotiated: CAP negotiation finished, after CAP END, when
+	// the server did not answer CAP LS, or at the latest when it
+	// registered us. Arguments: the acknowledged capabilities.
+	EventCapNegotiated = "CAP_NEGOTIATED"
+
+	// EventRegistered: the server accepted the registration, as reported
+	// by IsFullyConnected. Arguments: our nick.
+	EventRegistered = "REGISTERED"
+
+	// EventReconnecting: Loop is about to reconnect after an error; see
+	// AsReconnect. Arguments: attempt, delay, reason.
+	EventReconnecting = "RECONNECTING"
+
+	// EventGaveUp: Loop stopped reconnecting after a permanent ERROR or
+	// MaxRecoverableReconnects. EventDisconnected follows.
+	// Arguments: reason.
+	EventGaveUp = "GAVE_UP"
+)
+
+// reconnectDelay is the wait between failed reconnect attempts.
+const reconnectDelay = 60 * time.Second
+
+// ReconnectEvent is the typed view of EventReconnecting.
+type ReconnectEvent struct {
+	Attempt int           // 1 for the first attempt after a disconnect
+	Delay   time.Duration // wait before this attempt
+	Reason  string        // error that caused the reconnect
+}
+
+// AsReconnect returns the details of an EventReconnecting event.
+func (e *Event) AsReconnect() (*ReconnectEvent, error) {
+	if err := e.checkEvent(3, EventReconnecting); err != nil {
+		return nil, err
+	}
+	attempt, err := strconv.Atoi(e.Arguments[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad attempt %q", ErrMalformedEvent, e.Arguments[0])
+	}
+	delay, err := time.ParseDuration(e.Arguments[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad delay %q", ErrMalformedEvent, e.Arguments[1])
+	}
+	return &ReconnectEvent{Attempt: attempt, Delay: delay, Reason: e.Arguments[2]}, nil
+}
+
+// emitLifecycle raises a lifecycle event, see queueEvent.
+func (irc *Connection) emitLifecycle(code string, args ...string) {
+	irc.queueEvent(&Event{
+		Code:       code,
+		Raw:        strings.Join(args, " "),
+		Arguments:  args,
+		Connection: irc,
+	})
+}
+
+// emitCapNegotiated raises EventCapNegotiated with the acknowledged
+// capabilities, once per connection.
+func (irc *Connection) emitCapNegotiated() {
+	if caps, first := irc.capsNegotiatedOnce(); first {
+		irc.emitLifecycle(EventCapNegotiated, caps...)
+	}
+}
+
+func (irc *Connection) capsNegotiatedOnce() (caps []string, first bool) {
+	irc.Lock()
+	defer irc.Unlock()
+	if irc.capsNegotiated {
+		return nil, false
+	}
+	irc.capsNegotiated = true
+	return append([]string(nil), irc.AcknowledgedCaps...), true
+}
+
+// emitRegistered raises EventRegistered. A server that registers us before
+// CAP negotiation finished (or without supporting CAP at all) ends it, so
+// EventCapNegotiated is raised first if it has not been yet.
+func (irc *Connection) emitRegistered() {
+	irc.emitCapNegotiated()
+	irc.emitLifecycle(EventRegistered, irc.GetNick())
+}
+
+func (irc *Connection) emitReconnecting(attempt int, delay time.Duration, reason error) {
+	irc.emitLifecycle(EventReconnecting, strconv.Itoa(attempt), delay.String(), reason.Error())
+}
+
+// handshakeTLS completes the TLS handshake within Timeout, so that
+// handshake errors are returned by Connect.
+func (irc *Connection) handshakeTLS(conn *tls.Conn) error {
+	ctx := context.Background()
+	if irc.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, irc.Timeout)
+		defer cancel()
+	}
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	state := conn.ConnectionState()
+	irc.emitLifecycle(EventTLSConnected, irc.Server, tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	return nil
+}
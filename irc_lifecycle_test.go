@@ -0,0 +1,251 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var lifecycleEvents = []string{
+	EventConnected, EventTLSConnected, EventCapNegotiated, EventRegistered,
+	EventReconnecting, EventGaveUp, EventDisconnected,
+}
+
+// recordLifecycle collects the lifecycle events of irccon.
+func recordLifecycle(irccon *Connection) func() []*Event {
+	var mu sync.Mutex
+	var events []*Event
+	for _, code := range lifecycleEvents {
+		irccon.AddCallback(code, func(e *Event) {
+			mu.Lock()
+			events = append(events, e)
+			mu.Unlock()
+		})
+	}
+	return func() []*Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*Event(nil), events...)
+	}
+}
+
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestLifecycleEventsOnConnect(t *testing.T) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", selfSignedTLSConfig(t))
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "CAP LS"):
+				conn.Write([]byte(":srv CAP * LS :server-time multi-prefix\r\n"))
+			case strings.HasPrefix(line, "CAP REQ"):
+				conn.Write([]byte(":srv CAP * ACK :" + strings.TrimSpace(strings.SplitN(line, ":", 2)[1]) + "\r\n"))
+			case strings.HasPrefix(line, "CAP END"):
+				conn.Write([]byte(":srv 001 go-life :Welcome\r\n"))
+			}
+		}
+	}()
+
+	irccon := IRC("go-life", "go-life")
+	irccon.UseTLS = true
+	irccon.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	irccon.RequestCaps = []string{"server-time"}
+	events := recordLifecycle(irccon)
+
+	if err := irccon.Connect(ln.Addr().String()); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer irccon.Disconnect()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(events()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	got := events()
+	var codes []string
+	for _, e := range got {
+		codes = append(codes, e.Code)
+	}
+	want := []string{EventConnected, EventTLSConnected, EventCapNegotiated, EventRegistered}
+	if strings.Join(codes, " ") != strings.Join(want, " ") {
+		t.Fatalf("lifecycle events %q, want %q", codes, want)
+	}
+	if got[0].Arguments[0] != ln.Addr().String() || !strings.HasPrefix(got[1].Arguments[1], "TLS 1.") {
+		t.Fatalf("unexpected arguments %q and %q", got[0].Arguments, got[1].Arguments)
+	}
+	if strings.Join(got[2].Arguments, " ") != "server-time" || got[3].Arguments[0] != "go-life" {
+		t.Fatalf("unexpected arguments %q and %q", got[2].Arguments, got[3].Arguments)
+	}
+}
+
+func TestLifecycleTLSHandshakeError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("NOTICE * :this is not TLS\r\n"))
+		conn.Close()
+	}()
+
+	irccon := IRC("go-life", "go-life")
+	irccon.UseTLS = true
+	irccon.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	events := recordLifecycle(irccon)
+
+	if err := irccon.Connect(ln.Addr().String()); err == nil {
+		t.Fatal("Connect succeeded without a TLS server")
+	}
+	if got := events(); len(got) != 1 || got[0].Code != EventConnected {
+		t.Fatalf("unexpected lifecycle events %v", got)
+	}
+}
+
+func TestLifecycleReconnectAndGiveUp(t *testing.T) {
+	addr, _, cleanup := startServerErrorServer(t)
+	defer cleanup()
+
+	irccon := IRC("go-life", "go-life")
+	irccon.MaxRecoverableReconnects = 2
+	events := recordLifecycle(irccon)
+	if err := irccon.Connect(addr); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		irccon.Loop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Loop did not stop")
+	}
+
+	var reconnects []*ReconnectEvent
+	var tail []string
+	for _, e := range events() {
+		switch e.Code {
+		case EventReconnecting:
+			r, err := e.AsReconnect()
+			if err != nil {
+				t.Fatalf("AsReconnect failed: %v", err)
+			}
+			reconnects = append(reconnects, r)
+		case EventGaveUp, EventDisconnected:
+			tail = append(tail, e.Code)
+		}
+	}
+	// Each reconnect succeeds before the server closes the link again, so
+	// every disconnect needs one immediate attempt.
+	if len(reconnects) != 2 {
+		t.Fatalf("got %d reconnect events, want 2", len(reconnects))
+	}
+	for _, r := range reconnects {
+		if r.Attempt != 1 || r.Delay != 0 || r.Reason == "" {
+			t.Fatalf("unexpected reconnect event %+v", r)
+		}
+	}
+	if strings.Join(tail, " ") != EventGaveUp+" "+EventDisconnected {
+		t.Fatalf("events after reconnects %q, want %s then %s", tail, EventGaveUp, EventDisconnected)
+	}
+}
+
+func TestRegisteredUsesDispatcher(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.DispatchWorkers = 1
+	irccon.pwrite = make(chan string, 10)
+
+	release := make(chan struct{})
+	defer close(release)
+	registered := make(chan string, 1)
+	irccon.AddCallback(EventRegistered, func(e *Event) {
+		registered <- e.Arguments[0]
+		<-release
+	})
+
+	server := startReadLoop(t, irccon)
+	go server.Write([]byte(":srv 001 me :Welcome\r\n:srv PING :check\r\n"))
+
+	select {
+	case nick := <-registered:
+		if nick != "me" {
+			t.Fatalf("REGISTERED for %q, want me", nick)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("REGISTERED was not run")
+	}
+	// The REGISTERED callback is still running on the worker
+	select {
+	case line := <-irccon.pwrite:
+		if line != "PONG :check\r\n" {
+			t.Fatalf("sent %q, want the PONG", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("a slow REGISTERED callback stalled the read loop")
+	}
+}
+
+func TestAsReconnect(t *testing.T) {
+	e := &Event{Code: EventReconnecting, Arguments: []string{"3", "1m0s", "EOF"}}
+	r, err := e.AsReconnect()
+	if err != nil || *r != (ReconnectEvent{Attempt: 3, Delay: time.Minute, Reason: "EOF"}) {
+		t.Fatalf("AsReconnect = %+v, %v", r, err)
+	}
+	for _, bad := range []*Event{
+		{Code: EventDisconnected, Arguments: []string{"1", "0s", "x"}},
+		{Code: EventReconnecting, Arguments: []string{"x", "0s", "x"}},
+		{Code: EventReconnecting, Arguments: []string{"1", "soon", "x"}},
+	} {
+		if _, err := bad.AsReconnect(); err == nil {
+			t.Errorf("AsReconnect(%q) succeeded", bad.Arguments)
+		}
+	}
+}
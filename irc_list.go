@@ -0,0 +1,466 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrListInProgress is returned by List while another LIST is in
+	// flight; servers do not tag LIST replies, so only one can run at a
+	// time.
+	ErrListInProgress = errors.New("LIST already in progress")
+	// ErrListOverflow ends a List whose reader fell more than
+	// ListOptions.MaxBuffered entries behind the server.
+	ErrListOverflow = errors.New("LIST reader fell behind")
+	// ErrTryAgain is returned by List and WhoSync when the server
+	// refuses the query with RPL_TRYAGAIN (263), usually because of rate
+	// limiting.
+	ErrTryAgain = errors.New("server asked to try again later")
+	// ErrTooManyMatches is returned by List and WhoSync when the server
+	// cuts the query short with ERR_TOOMANYMATCHES (416).
+	ErrTooManyMatches = errors.New("too many matches")
+)
+
+const (
+	// defaultListBuffer is the MaxBuffered used when it is not set.
+	defaultListBuffer = 10000
+	// listDrainTimeout is how long an abandoned LIST may keep swallowing
+	// replies while waiting for its RPL_LISTEND before a new List may
+	// start anyway.
+	listDrainTimeout = time.Minute
+)
+
+// ListEntry is one channel from RPL_LIST (322).
+type ListEntry struct {
+	Channel string
+	Users   int
+	Topic   string
+}
+
+// ListOptions filters the channels returned by List. Filters the server
+// advertises in ISUPPORT ELIST are sent with the LIST command; user count
+// and mask filters are also applied locally, so they work on every server.
+// The topic and creation time filters have no local fallback and are
+// ignored when the server does not support them.
+type ListOptions struct {
+	Masks   []string // channel masks to include, e.g. "#go-*" (ELIST M)
+	Exclude []string // channel masks to exclude (ELIST N)
+
+	MinUsers int // at least this many users, 0 = no limit (ELIST U)
+	MaxUsers int // at most this many users, 0 = no limit (ELIST U)
+
+	TopicNewerThan   time.Duration // topic set less than this long ago (ELIST T)
+	TopicOlderThan   time.Duration // topic set more than this long ago (ELIST T)
+	CreatedNewerThan time.Duration // created less than this long ago (ELIST C)
+	CreatedOlderThan time.Duration // created more than this long ago (ELIST C)
+
+	// Limit stops collecting after this many matching channels, 0 = all.
+	// The rest of the server's reply is discarded.
+	Limit int
+
+	// MaxBuffered is how many entries may wait for Next before the
+	// listing fails with ErrListOverflow, 0 = 10000.
+	MaxBuffered int
+}
+
+// params returns the LIST argument for the filters the server supports.
+func (o *ListOptions) params(elist string) string {
+	var conds []string
+	supports := func(c byte) bool { return strings.IndexByte(elist, c) >= 0 }
+
+	if supports('M') {
+		conds = append(conds, o.Masks...)
+	}
+	if supports('N') {
+		for _, mask := range o.Exclude {
+			conds = append(conds, "!"+mask)
+		}
+	}
+	if supports('U') {
+		if o.MinUsers > 0 {
+			conds = append(conds, ">"+strconv.Itoa(o.MinUsers-1))
+		}
+		if o.MaxUsers > 0 {
+			conds = append(conds, "<"+strconv.Itoa(o.MaxUsers+1))
+		}
+	}
+	minutes := func(d time.Duration) string { return strconv.Itoa(int(d / time.Minute)) }
+	if supports('T') {
+		if o.TopicNewerThan > 0 {
+			conds = append(conds, "T<"+minutes(o.TopicNewerThan))
+		}
+		if o.TopicOlderThan > 0 {
+			conds = append(conds, "T>"+minutes(o.TopicOlderThan))
+		}
+	}
+	if supports('C') {
+		if o.CreatedNewerThan > 0 {
+			conds = append(conds, "C<"+minutes(o.CreatedNewerThan))
+		}
+		if o.CreatedOlderThan > 0 {
+			conds = append(conds, "C>"+minutes(o.CreatedOlderThan))
+		}
+	}
+	return strings.Join(conds, ",")
+}
+
+// match applies the filters that can be checked locally.
+func (o *ListOptions) match(entry *ListEntry) bool {
+	if o.MinUsers > 0 && entry.Users < o.MinUsers {
+		return false
+	}
+	if o.MaxUsers > 0 && entry.Users > o.MaxUsers {
+		return false
+	}
+	if len(o.Masks) > 0 {
+		matched := false
+		for _, mask := range o.Masks {
+			if wildcardMatch(mask, entry.Channel) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, mask := range o.Exclude {
+		if wildcardMatch(mask, entry.Channel) {
+			return false
+		}
+	}
+	return true
+}
+
+// wildcardMatch matches an IRC mask with '*' and '?' wildcards, using IRC
+// case mapping.
+func wildcardMatch(pattern, name string) bool {
+	pattern = canonicalizeRFCNick(pattern)
+	name = canonicalizeRFCNick(name)
+
+	p, n := 0, 0
+	star, mark := -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, n
+			p++
+		case star >= 0:
+			p = star + 1
+			mark++
+			n = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// ListIterator streams the result of a LIST query. Entries are buffered as
+// they arrive, up to ListOptions.MaxBuffered, so reading slowly never holds
+// up other events:
+//
+//	it := conn.List(ctx, irc.ListOptions{MinUsers: 10})
+//	defer it.Close()
+//	for it.Next() {
+//		entry := it.Entry()
+//	}
+//	if err := it.Err(); err != nil { ... }
+type ListIterator struct {
+	ctx     context.Context
+	tracker *listTracker
+	query   *listQuery
+	batch   []ListEntry
+	entry   ListEntry
+	err     error
+}
+
+type listQuery struct {
+	opts      ListOptions
+	entries   []ListEntry // received but not yet read
+	matched   int
+	done      bool
+	err       error
+	abandoned bool        // reader gave up or Limit reached; drop entries until 323
+	since     time.Time   // when abandoned was set
+	stop      func() bool // stops watching the context
+	notify    chan struct{}
+}
+
+type listTracker struct {
+	mu      sync.Mutex
+	current *listQuery
+}
+
+func newListTracker() *listTracker {
+	return &listTracker{}
+}
+
+// abandonLocked stops delivering entries to the reader. The query stays
+// current until its RPL_LISTEND, or for at most listDrainTimeout, so that
+// the rest of its reply is not mistaken for a later LIST.
+func (q *listQuery) abandonLocked() {
+	if !q.abandoned {
+		q.abandoned = true
+		q.since = time.Now()
+	}
+	q.entries = nil
+}
+
+// failLocked ends the query with err unless it already ended.
+func (q *listQuery) failLocked(err error) {
+	if !q.done {
+		q.done = true
+		q.err = err
+	}
+	q.signal()
+}
+
+// finishLocked makes q no longer current.
+func (t *listTracker) finishLocked(q *listQuery) {
+	if t.current == q {
+		t.current = nil
+	}
+	if q.stop != nil {
+		q.stop()
+	}
+}
+
+func (q *listQuery) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// List sends LIST with the given filters and returns an iterator over the
+// matching channels. The iterator ends after RPL_LISTEND (323); Err then
+// reports why it stopped early: ErrListInProgress, ErrTryAgain,
+// ErrTooManyMatches, ErrListOverflow, ErrDisconnected or ctx.Err().
+// Cancelling ctx fails the listing even while nobody calls Next.
+func (irc *Connection) List(ctx context.Context, opts ListOptions) *ListIterator {
+	it := &ListIterator{ctx: ctx, tracker: irc.list}
+	t := irc.list
+
+	t.mu.Lock()
+	if q := t.current; q != nil {
+		if !q.abandoned || time.Since(q.since) < listDrainTimeout {
+			t.mu.Unlock()
+			it.err = ErrListInProgress
+			return it
+		}
+		// Its RPL_LISTEND never came
+		t.finishLocked(q)
+	}
+	q := &listQuery{opts: opts, notify: make(chan struct{}, 1)}
+	it.query = q
+	t.current = q
+	q.stop = context.AfterFunc(ctx, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if !q.done {
+			q.abandonLocked()
+			q.failLocked(ctx.Err())
+		}
+	})
+	t.mu.Unlock()
+
+	elist, _ := irc.ISupport("ELIST")
+	if params := opts.params(strings.ToUpper(elist)); params != "" {
+		irc.send("LIST", params)
+	} else {
+		irc.send("LIST")
+	}
+	return it
+}
+
+// Next advances to the next entry, waiting for the server if necessary.
+// It returns false when the listing is complete or failed; see Err.
+func (it *ListIterator) Next() bool {
+	if it.query == nil {
+		return false
+	}
+	for {
+		if len(it.batch) > 0 {
+			it.entry = it.batch[0]
+			it.batch = it.batch[1:]
+			return true
+		}
+
+		t := it.tracker
+		t.mu.Lock()
+		it.batch, it.query.entries = it.query.entries, nil
+		done, err := it.query.done, it.query.err
+		t.mu.Unlock()
+
+		if len(it.batch) > 0 {
+			continue
+		}
+		if done {
+			it.err = err
+			it.query = nil
+			return false
+		}
+
+		select {
+		case <-it.query.notify:
+		case <-it.ctx.Done():
+			it.err = it.ctx.Err()
+			it.Close()
+			return false
+		}
+	}
+}
+
+// Entry returns the entry read by the last successful call to Next.
+func (it *ListIterator) Entry() ListEntry {
+	return it.entry
+}
+
+// Err returns the error that ended the iteration, if any.
+func (it *ListIterator) Err() error {
+	return it.err
+}
+
+// Close stops the iteration early. The rest of the server's reply is
+// discarded. Close is safe to call more than once.
+func (it *ListIterator) Close() {
+	if it.query == nil {
+		return
+	}
+	it.tracker.mu.Lock()
+	it.query.abandonLocked()
+	it.tracker.mu.Unlock()
+	it.query = nil
+	it.batch = nil
+}
+
+// handleListReply queues an RPL_LIST (322) entry for the query in flight.
+//
+// Format: :server 322 <me> <channel> <users> :<topic>
+func (t *listTracker) handleListReply(e *Event) {
+	if len(e.Arguments) < 3 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q := t.current
+	if q == nil || q.abandoned {
+		return
+	}
+	entry := ListEntry{Channel: e.Arguments[1]}
+	entry.Users, _ = strconv.Atoi(e.Arguments[2])
+	if len(e.Arguments) > 3 {
+		entry.Topic = e.Arguments[3]
+	}
+	if !q.opts.match(&entry) {
+		return
+	}
+
+	limit := q.opts.MaxBuffered
+	if limit <= 0 {
+		limit = defaultListBuffer
+	}
+	if len(q.entries) >= limit {
+		q.abandonLocked()
+		q.failLocked(ErrListOverflow)
+		return
+	}
+
+	q.entries = append(q.entries, entry)
+	q.matched++
+	if q.opts.Limit > 0 && q.matched >= q.opts.Limit {
+		// Keep the entries already queued for the reader
+		q.abandoned = true
+		q.since = time.Now()
+		q.done = true
+	}
+	q.signal()
+}
+
+// handleListEnd completes the query in flight on RPL_LISTEND (323).
+func (t *listTracker) handleListEnd(e *Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if q := t.current; q != nil {
+		t.finishLocked(q)
+		q.done = true
+		q.signal()
+	}
+}
+
+// handleListError fails the query in flight on RPL_TRYAGAIN (263) or
+// ERR_TOOMANYMATCHES (416) about LIST.
+//
+// Format: :server 263 <me> LIST :Please wait a while and try again.
+func (t *listTracker) handleListError(e *Event) {
+	if len(e.Arguments) < 2 || !strings.EqualFold(e.Arguments[1], "LIST") {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q := t.current
+	if q == nil {
+		return
+	}
+	if e.Code == RPL_TRYAGAIN {
+		// The server ignored the command, no RPL_LISTEND follows
+		t.finishLocked(q)
+		q.failLocked(ErrTryAgain)
+		return
+	}
+	// Some servers still end a truncated listing with RPL_LISTEND
+	q.failLocked(ErrTooManyMatches)
+	q.abandonLocked()
+}
+
+// failAll ends the query in flight with err.
+func (t *listTracker) failAll(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if q := t.current; q != nil {
+		t.finishLocked(q)
+		q.failLocked(err)
+	}
+}
+
+// setupListCallbacks installs the reply handlers used by List.
+func (irc *Connection) setupListCallbacks() {
+	irc.AddCallback(RPL_LIST, irc.list.handleListReply)
+	irc.AddCallback(RPL_LISTEND, irc.list.handleListEnd)
+	irc.AddCallback(RPL_TRYAGAIN, irc.list.handleListError)
+	irc.AddCallback(ERR_TOOMANYMATCHES, irc.list.handleListError)
+}
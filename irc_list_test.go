@@ -0,0 +1,218 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListOptionsParams(t *testing.T) {
+	opts := ListOptions{
+		Masks:          []string{"#go-*"},
+		Exclude:        []string{"#go-offtopic"},
+		MinUsers:       10,
+		MaxUsers:       100,
+		TopicNewerThan: 2 * time.Hour,
+	}
+	if got := opts.params("CMNTU"); got != "#go-*,!#go-offtopic,>9,<101,T<120" {
+		t.Fatalf("unexpected LIST params %q", got)
+	}
+	if got := opts.params(""); got != "" {
+		t.Fatalf("expected no params without ELIST, got %q", got)
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"#go-*", "#GO-nuts", true},
+		{"#go-*", "#golang", false},
+		{"#?o", "#go", true},
+		{"*", "#anything", true},
+		{"#a*b*c", "#aXXbYYc", true},
+		{"#a*b*c", "#aXXbYY", false},
+	}
+	for _, c := range cases {
+		if got := wildcardMatch(c.pattern, c.name); got != c.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestListFiltersLocallyWithoutELIST(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	it := irccon.List(ctx, ListOptions{Masks: []string{"#go*"}, MinUsers: 5})
+	if got := nextRawCommand(t, irccon.pwrite); got != "LIST\r\n" {
+		t.Fatalf("unexpected command %q", got)
+	}
+
+	if second := irccon.List(ctx, ListOptions{}); second.Next() || !errors.Is(second.Err(), ErrListInProgress) {
+		t.Fatalf("expected ErrListInProgress, got %v", second.Err())
+	}
+
+	feedLines(t, irccon,
+		":srv 321 me Channel :Users  Name",
+		":srv 322 me #go 120 :The Go language",
+		":srv 322 me #rust 300 :Rust",
+		":srv 322 me #gophers 3 :small",
+		":srv 322 me #go-nuts 7 :",
+		":srv 323 me :End of /LIST",
+	)
+
+	var got []ListEntry
+	for it.Next() {
+		got = append(got, it.Entry())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	want := []ListEntry{{"#go", 120, "The Go language"}, {"#go-nuts", 7, ""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected entries:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestListUsesELISTAndLimit(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+	feedLines(t, irccon, ":srv 005 me ELIST=U :are supported by this server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	it := irccon.List(ctx, ListOptions{MinUsers: 50, Limit: 1})
+	if got := nextRawCommand(t, irccon.pwrite); got != "LIST >49\r\n" {
+		t.Fatalf("unexpected command %q", got)
+	}
+
+	feedLines(t, irccon,
+		":srv 322 me #one 60 :first",
+		":srv 322 me #two 70 :second",
+	)
+	if !it.Next() || it.Entry().Channel != "#one" {
+		t.Fatalf("expected #one, got %+v (err %v)", it.Entry(), it.Err())
+	}
+	if it.Next() {
+		t.Fatalf("expected iteration to stop at Limit, got %+v", it.Entry())
+	}
+
+	// The rest of the reply still belongs to the first LIST.
+	if next := irccon.List(ctx, ListOptions{}); !errors.Is(next.Err(), ErrListInProgress) {
+		t.Fatalf("expected ErrListInProgress before 323, got %v", next.Err())
+	}
+	feedLines(t, irccon, ":srv 323 me :End of /LIST")
+	next := irccon.List(ctx, ListOptions{})
+	defer next.Close()
+	if next.Err() != nil {
+		t.Fatalf("expected a new LIST after 323, got %v", next.Err())
+	}
+}
+
+func TestListCloseAndDisconnect(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	it := irccon.List(context.Background(), ListOptions{})
+	feedLines(t, irccon, ":srv 322 me #a 1 :x")
+	it.Close()
+	if it.Next() {
+		t.Fatal("expected no entries after Close")
+	}
+
+	irccon.Lock()
+	irccon.resetRegistrationStateLocked()
+	irccon.Unlock()
+
+	it = irccon.List(context.Background(), ListOptions{})
+	irccon.Lock()
+	irccon.resetRegistrationStateLocked()
+	irccon.Unlock()
+	if it.Next() || !errors.Is(it.Err(), ErrDisconnected) {
+		t.Fatalf("expected ErrDisconnected, got %v", it.Err())
+	}
+}
+
+func TestListFailsOnServerErrors(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	it := irccon.List(context.Background(), ListOptions{})
+	feedLines(t, irccon, ":srv 263 me LIST :Please wait a while and try again.")
+	if it.Next() || !errors.Is(it.Err(), ErrTryAgain) {
+		t.Fatalf("expected ErrTryAgain, got %v", it.Err())
+	}
+
+	it = irccon.List(context.Background(), ListOptions{})
+	if it.Err() != nil {
+		t.Fatalf("expected a new LIST after 263, got %v", it.Err())
+	}
+	feedLines(t, irccon,
+		":srv 322 me #a 1 :x",
+		":srv 416 me LIST :output too large, truncated",
+		":srv 322 me #b 1 :x",
+	)
+	if it.Next() || !errors.Is(it.Err(), ErrTooManyMatches) {
+		t.Fatalf("expected ErrTooManyMatches, got %v", it.Err())
+	}
+	feedLines(t, irccon, ":srv 323 me :End of /LIST")
+	if next := irccon.List(context.Background(), ListOptions{}); next.Err() != nil {
+		t.Fatalf("expected a new LIST after 323, got %v", next.Err())
+	}
+}
+
+func TestListContextCancelled(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := irccon.List(ctx, ListOptions{})
+	cancel()
+
+	// The query fails without anyone calling Next
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		irccon.list.mu.Lock()
+		done := it.query.done
+		irccon.list.mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cancelling the context did not fail the LIST")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if it.Next() || !errors.Is(it.Err(), context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", it.Err())
+	}
+
+	// Its 323 never arrives; a new LIST may start after the drain timeout
+	irccon.list.mu.Lock()
+	irccon.list.current.since = time.Now().Add(-listDrainTimeout)
+	irccon.list.mu.Unlock()
+	if next := irccon.List(context.Background(), ListOptions{}); next.Err() != nil {
+		t.Fatalf("expected a new LIST after the drain timeout, got %v", next.Err())
+	}
+}
+
+func TestListOverflow(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	it := irccon.List(context.Background(), ListOptions{MaxBuffered: 2})
+	feedLines(t, irccon,
+		":srv 322 me #a 1 :x",
+		":srv 322 me #b 1 :x",
+		":srv 322 me #c 1 :x",
+	)
+	if it.Next() || !errors.Is(it.Err(), ErrListOverflow) {
+		t.Fatalf("expected ErrListOverflow, got %v", it.Err())
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Message is a single IRC protocol line:
+//
+//	[@tags] [:source] COMMAND [params...] [:trailing]
+//
+// ParseMessage and String/MarshalText convert between the two forms,
+// applying the IRCv3 tag escaping and the trailing parameter rules, so
+// arbitrary commands can be built without string formatting:
+//
+//	m := irc.NewMessage("PRIVMSG", "#go", "hello world")
+//	conn.SendMessage(m) // PRIVMSG #go :hello world
+type Message struct {
+	Tags    map[string]string // IRCv3 message tags, unescaped
+	Source  string            // nick!user@host or server name; empty for outgoing lines
+	Command string            // command or three-digit numeric
+	Params  []string          // parameters, the last one may contain spaces
+
+	// ForceTrailing sends the last parameter with a leading ':' even when
+	// it would not need one. ParseMessage sets it when the line had one.
+	ForceTrailing bool
+}
+
+// NewMessage returns a message for command with params. The last parameter
+// is sent as a trailing parameter only when it needs to be; set
+// ForceTrailing for message text.
+func NewMessage(command string, params ...string) *Message {
+	return &Message{Command: command, Params: params}
+}
+
+// ParseMessage parses a protocol line following the IRCv3 message format.
+// A trailing CR/LF is ignored, runs of spaces separate parameters, and tag
+// values are unescaped. A line without a command is an error.
+func ParseMessage(line string) (*Message, error) {
+	e := &Event{Raw: strings.TrimRight(line, "\r\n")}
+	trailing, err := e.parseRaw(true)
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{
+		Tags:          e.Tags,
+		Source:        e.Source,
+		Command:       e.Code,
+		ForceTrailing: trailing,
+	}
+	if len(e.Arguments) > 0 {
+		m.Params = e.Arguments
+	}
+	return m, nil
+}
+
+// String returns the protocol line without the final CR/LF. Use
+// MarshalText to detect messages that cannot be sent as they are.
+func (m *Message) String() string {
+	var b strings.Builder
+
+	if len(m.Tags) > 0 {
+		keys := make([]string, 0, len(m.Tags))
+		for key := range m.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b.WriteByte('@')
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteByte(';')
+			}
+			b.WriteString(key)
+			if value := m.Tags[key]; value != "" {
+				b.WriteByte('=')
+				b.WriteString(escapeTagValue(value))
+			}
+		}
+		b.WriteByte(' ')
+	}
+
+	if m.Source != "" {
+		b.WriteByte(':')
+		b.WriteString(m.Source)
+		b.WriteByte(' ')
+	}
+
+	b.WriteString(m.Command)
+
+	for i, param := range m.Params {
+		b.WriteByte(' ')
+		if i == len(m.Params)-1 && (m.ForceTrailing || param == "" || param[0] == ':' || strings.IndexByte(param, ' ') >= 0) {
+			b.WriteByte(':')
+		}
+		b.WriteString(param)
+	}
+	return b.String()
+}
+
+// MarshalText implements encoding.TextMarshaler. It fails for messages
+// that would be misread by the server: an invalid command, or parameters
+// containing CR, LF or NUL, or a space in any but the last parameter.
+func (m *Message) MarshalText() ([]byte, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (m *Message) UnmarshalText(text []byte) error {
+	parsed, err := ParseMessage(string(text))
+	if err != nil {
+		return err
+	}
+	*m = *parsed
+	return nil
+}
+
+func (m *Message) validate() error {
+	if m.Command == "" {
+		return errors.New("irc: message without a command")
+	}
+	for i := 0; i < len(m.Command); i++ {
+		c := m.Command[i]
+		if !(c >= 'A' && c <= 'Z') && !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') {
+			return fmt.Errorf("irc: invalid command %q", m.Command)
+		}
+	}
+	if strings.ContainsAny(m.Source, " \r\n\x00") {
+		return fmt.Errorf("irc: invalid source %q", m.Source)
+	}
+	for key := range m.Tags {
+		if key == "" || strings.ContainsAny(key, " =;\r\n\x00") {
+			return fmt.Errorf("irc: invalid tag name %q", key)
+		}
+	}
+	for i, param := range m.Params {
+		if strings.ContainsAny(param, "\r\n\x00") {
+			return fmt.Errorf("irc: %s parameter %d contains CR, LF or NUL", m.Command, i+1)
+		}
+		if i < len(m.Params)-1 && (param == "" || param[0] == ':' || strings.IndexByte(param, ' ') >= 0) {
+			return fmt.Errorf("irc: %s parameter %d %q must be the last parameter", m.Command, i+1, param)
+		}
+	}
+	return nil
+}
+
+// parseTags parses the tag section of a line (without the leading '@').
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, "=")
+		if key == "" {
+			continue
+		}
+		tags[key] = unescapeTagValue(value)
+	}
+	return tags
+}
+
+var tagEscaper = strings.NewReplacer(";", `\:`, " ", `\s`, `\`, `\\`, "\r", `\r`, "\n", `\n`)
+
+// escapeTagValue applies the IRCv3 tag value escaping.
+func escapeTagValue(value string) string {
+	return tagEscaper.Replace(value)
+}
+
+// unescapeTagValue reverses escapeTagValue. Unknown escapes drop the
+// backslash and a trailing lone backslash is removed.
+func unescapeTagValue(value string) string {
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		if i == len(value) {
+			break
+		}
+		switch value[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// SendMessage sends m to the server. It returns an error, and sends
+// nothing, when m cannot be represented as a single protocol line.
+func (irc *Connection) SendMessage(m *Message) error {
+	line, err := m.MarshalText()
+	if err != nil {
+		return err
+	}
+	irc.SendRaw(string(line))
+	return nil
+}
+
+// send is SendMessage for the library's own helpers: invalid messages are
+// logged and dropped. It reports whether the message was sent.
+func (irc *Connection) send(command string, params ...string) bool {
+	return irc.sendMessage(NewMessage(command, params...))
+}
+
+// sendText is send with the last parameter always sent as trailing text,
+// as expected for PRIVMSG, NOTICE, QUIT and similar commands.
+func (irc *Connection) sendText(command string, params ...string) bool {
+	m := NewMessage(command, params...)
+	m.ForceTrailing = true
+	return irc.sendMessage(m)
+}
+
+// writeMessage is sendMessage for the write queue of a connection being
+// set up, which registration writes to directly.
+func (irc *Connection) writeMessage(pwrite chan<- string, m *Message) bool {
+	line, err := m.MarshalText()
+	if err != nil {
+		irc.Log.Printf("Not sending %s: %v", m.Command, err)
+		return false
+	}
+	pwrite <- string(line) + "\r\n"
+	return true
+}
+
+func (irc *Connection) sendMessage(m *Message) bool {
+	if err := irc.SendMessage(m); err != nil {
+		irc.Log.Printf("Not sending %s: %v", m.Command, err)
+		return false
+	}
+	return true
+}
+
+// splitUserhost splits a nick!user@host source. The user and host parts
+// are optional, so "nick", "nick!user" and "nick@host" are all accepted.
+func splitUserhost(source string) (nick, user, host string) {
+	rest, host, _ := strings.Cut(source, "@")
+	nick, user, _ = strings.Cut(rest, "!")
+	return nick, user, host
+}
+
+// isServerSource reports whether source names a server rather than a
+// client. Nicks cannot contain '.', server names always do.
+func isServerSource(source string) bool {
+	return strings.IndexByte(source, '.') >= 0 && !strings.ContainsAny(source, "!@")
+}
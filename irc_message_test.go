@@ -0,0 +1,178 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	m, err := ParseMessage("@time=2024-01-01T00:00:00Z;msgid=a\\sb\\:c;+draft/flag :nick!user@host privmsg  #chan   :hello  world\r\n")
+	if err != nil {
+		t.Fatalf("ParseMessage failed: %v", err)
+	}
+	want := &Message{
+		Tags:          map[string]string{"time": "2024-01-01T00:00:00Z", "msgid": "a b;c", "+draft/flag": ""},
+		Source:        "nick!user@host",
+		Command:       "PRIVMSG",
+		Params:        []string{"#chan", "hello  world"},
+		ForceTrailing: true,
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("ParseMessage = %#v, want %#v", m, want)
+	}
+
+	for _, line := range []string{"", "@tags-only", ":source-only", "@a=b :src"} {
+		if _, err := ParseMessage(line); err == nil {
+			t.Errorf("ParseMessage(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	cases := []struct {
+		msg  *Message
+		want string
+	}{
+		{NewMessage("JOIN", "#go"), "JOIN #go"},
+		{NewMessage("PING"), "PING"},
+		{NewMessage("PRIVMSG", "#go", "hello world"), "PRIVMSG #go :hello world"},
+		{NewMessage("PRIVMSG", "#go", ":)"), "PRIVMSG #go ::)"},
+		{NewMessage("TOPIC", "#go", ""), "TOPIC #go :"},
+		{&Message{Command: "QUIT", Params: []string{"bye"}, ForceTrailing: true}, "QUIT :bye"},
+		{&Message{Tags: map[string]string{"b": "x;y z\\", "a": ""}, Command: "TAGMSG", Params: []string{"#go"}}, `@a;b=x\:y\sz\\ TAGMSG #go`},
+		{&Message{Source: "irc.example.net", Command: "001", Params: []string{"me", "Welcome"}}, ":irc.example.net 001 me Welcome"},
+	}
+	for _, c := range cases {
+		if got := c.msg.String(); got != c.want {
+			t.Errorf("String() = %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	lines := []string{
+		"PING :irc.example.net",
+		":srv 005 me CHANTYPES=# NICKLEN=30 :are supported by this server",
+		`@label=1;msgid=a\sb :nick!u@h PRIVMSG #go :hi there`,
+		":nick!u@h MODE #go +o other",
+	}
+	for _, line := range lines {
+		var m Message
+		if err := m.UnmarshalText([]byte(line)); err != nil {
+			t.Fatalf("UnmarshalText(%q) failed: %v", line, err)
+		}
+		text, err := m.MarshalText()
+		if err != nil {
+			t.Fatalf("MarshalText(%q) failed: %v", line, err)
+		}
+		if string(text) != line {
+			t.Errorf("round trip = %q, want %q", text, line)
+		}
+	}
+}
+
+func TestMessageMarshalRejectsInvalid(t *testing.T) {
+	invalid := []*Message{
+		NewMessage(""),
+		NewMessage("PRIV MSG", "#go"),
+		NewMessage("PRIVMSG", "#go", "hi\r\nQUIT :owned"),
+		NewMessage("PRIVMSG", "#go x", "hi"),
+		NewMessage("KICK", "", "nick"),
+		{Command: "PING", Tags: map[string]string{"a b": "c"}},
+		{Command: "PING", Source: "bad source"},
+	}
+	for _, m := range invalid {
+		if _, err := m.MarshalText(); err == nil {
+			t.Errorf("MarshalText(%#v) succeeded, want error", m)
+		}
+	}
+}
+
+func TestUnescapeTagValue(t *testing.T) {
+	cases := map[string]string{
+		`plain`:     "plain",
+		`a\:b\sc`:   "a;b c",
+		`\\\r\n`:    "\\\r\n",
+		`unknown\x`: "unknownx",
+		`trailing\`: "trailing",
+	}
+	for in, want := range cases {
+		if got := unescapeTagValue(in); got != want {
+			t.Errorf("unescapeTagValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHelpersUseMessage(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	irccon.Privmsg("#go", "hi\r\nQUIT :injected")
+	irccon.Privmsg("#go", "hello")
+	if got := nextRawCommand(t, irccon.pwrite); got != "PRIVMSG #go :hello\r\n" {
+		t.Fatalf("expected only the valid PRIVMSG, got %q", got)
+	}
+
+	irccon.Mode("#go", "+ov alice", "bob")
+	if got := nextRawCommand(t, irccon.pwrite); got != "MODE #go +ov alice bob\r\n" {
+		t.Fatalf("unexpected MODE line %q", got)
+	}
+
+	irccon.Kick("bob", "#go", "")
+	if got := nextRawCommand(t, irccon.pwrite); got != "KICK #go bob\r\n" {
+		t.Fatalf("unexpected KICK line %q", got)
+	}
+
+	if err := irccon.SendMessage(NewMessage("NOTICE", "#go", "a\nb")); err == nil {
+		t.Fatal("SendMessage accepted a parameter with LF")
+	}
+	if len(irccon.pwrite) != 0 {
+		t.Fatalf("unexpected line written: %q", <-irccon.pwrite)
+	}
+}
+
+func TestJoinAndPartParameters(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	for _, tt := range []struct {
+		send func()
+		want string
+	}{
+		{func() { irccon.Join("#go") }, "JOIN #go\r\n"},
+		{func() { irccon.Join("#chan key") }, "JOIN #chan key\r\n"},
+		{func() { irccon.Join("#a,#b keyA,keyB") }, "JOIN #a,#b keyA,keyB\r\n"},
+		{func() { irccon.Part("#go") }, "PART #go\r\n"},
+		{func() { irccon.Part("#chan :bye now") }, "PART #chan :bye now\r\n"},
+		{func() { irccon.Part("#chan bye") }, "PART #chan :bye\r\n"},
+	} {
+		tt.send()
+		if got := nextRawCommand(t, irccon.pwrite); got != tt.want {
+			t.Fatalf("sent %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestRegistrationUsesMessage(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.RealName = "Real Name"
+	pwrite := make(chan string, 10)
+
+	irccon.Lock()
+	generation := irccon.registrationGeneration
+	irccon.Unlock()
+	if !irccon.sendRegistrationOnce(generation, pwrite) {
+		t.Fatal("registration not sent")
+	}
+	if got := <-pwrite + <-pwrite; got != "NICK me\r\nUSER testuser 0 * :Real Name\r\n" {
+		t.Fatalf("sent %q", got)
+	}
+
+	irccon = IRC("bad\r\nnick", "testuser")
+	irccon.Lock()
+	generation = irccon.registrationGeneration
+	irccon.Unlock()
+	if irccon.sendRegistrationOnce(generation, pwrite) || len(pwrite) != 0 {
+		t.Fatal("registration sent an invalid NICK")
+	}
+}
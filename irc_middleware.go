@@ -0,0 +1,134 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import "strings"
+
+// EventMiddleware is called with every event before its callbacks, the
+// library's own handlers included. It passes the event on by calling next,
+// possibly after changing it or with a different event, and drops it by
+// not calling next. next must be called before the middleware returns;
+// only the first call delivers the event, later ones are ignored.
+//
+// Middleware for incoming lines runs on the read loop, after CTCP messages
+// got their CTCP_* code. With PoolEvents the event must not be used after
+// calling next or dropping it. Events the middleware created are never
+// recycled, and neither is the event they replaced, as they may share its
+// Arguments or Tags.
+type EventMiddleware func(e *Event, next func(*Event))
+
+// LineMiddleware is called with every line sent to the server, without the
+// final CR/LF, just before it is written. It passes the line on by calling
+// next, possibly changed or several times, and drops it by not calling next.
+// It runs on the write loop, so it must not wait for other lines to be sent.
+type LineMiddleware func(line string, next func(string))
+
+// AddEventMiddleware appends mw to the event middleware chain. Middleware
+// runs in the order it was added, the first one seeing the event first.
+//
+//	conn.AddEventMiddleware(func(e *irc.Event, next func(*irc.Event)) {
+//		if !ignored[strings.ToLower(e.Nick)] {
+//			next(e)
+//		}
+//	})
+func (irc *Connection) AddEventMiddleware(mw EventMiddleware) {
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+
+	irc.eventMiddleware = append(irc.eventMiddleware[:len(irc.eventMiddleware):len(irc.eventMiddleware)], mw)
+}
+
+// AddLineMiddleware appends mw to the outgoing line middleware chain.
+// Middleware runs in the order it was added.
+func (irc *Connection) AddLineMiddleware(mw LineMiddleware) {
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+
+	irc.lineMiddleware = append(irc.lineMiddleware[:len(irc.lineMiddleware):len(irc.lineMiddleware)], mw)
+}
+
+// middleware returns the current chains. Adding middleware copies the
+// slices, so they can be used without holding eventsMutex.
+func (irc *Connection) middleware() ([]EventMiddleware, []LineMiddleware) {
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+
+	return irc.eventMiddleware, irc.lineMiddleware
+}
+
+// deliverFiltered is deliverEvent for a connection with event middleware.
+// A pooled event is released here when the middleware dropped it or once
+// its callbacks returned, by the dispatcher when there is one. Only the
+// event read from the connection is released.
+func (irc *Connection) deliverFiltered(chain []EventMiddleware, d *dispatcher, event *Event) {
+	delivered := false
+	irc.classifyCTCP(event)
+	runEventChain(chain, event, func(e *Event) {
+		if delivered {
+			return
+		}
+		delivered = true
+		release := irc.PoolEvents && e == event
+		if d != nil {
+			d.dispatch(e, release)
+		} else if irc.runCallbacks(e) && release {
+			releaseEvent(e)
+		}
+	})
+	if !delivered && irc.PoolEvents {
+		releaseEvent(event)
+	}
+}
+
+func runEventChain(chain []EventMiddleware, event *Event, deliver func(*Event)) {
+	if len(chain) == 0 {
+		deliver(event)
+		return
+	}
+	chain[0](event, func(e *Event) {
+		runEventChain(chain[1:], e, deliver)
+	})
+}
+
+// writeLine passes an outgoing line, including its CR/LF, through the line
+// middleware to write. It returns the first write error.
+func (irc *Connection) writeLine(line string, write func(string) error) error {
+	_, chain := irc.middleware()
+	if len(chain) == 0 {
+		return write(line)
+	}
+
+	var err error
+	runLineChain(chain, strings.TrimRight(line, "\r\n"), func(l string) {
+		if err == nil {
+			err = write(l + "\r\n")
+		}
+	})
+	return err
+}
+
+func runLineChain(chain []LineMiddleware, line string, write func(string)) {
+	if len(chain) == 0 {
+		write(line)
+		return
+	}
+	chain[0](line, func(l string) {
+		runLineChain(chain[1:], l, write)
+	})
+}
@@ -0,0 +1,188 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding"
+)
+
+type testCtxKey struct{}
+
+func TestEventMiddlewareChain(t *testing.T) {
+	for _, mode := range []string{"default", "pooled", "dispatcher"} {
+		t.Run(mode, func(t *testing.T) {
+			irccon := IRC("me", "testuser")
+			switch mode {
+			case "pooled":
+				irccon.PoolEvents = true
+			case "dispatcher":
+				irccon.PoolEvents = true
+				irccon.DispatchWorkers = 2
+				irccon.DispatchOrdered = true
+			}
+
+			var order []string
+			irccon.AddEventMiddleware(func(e *Event, next func(*Event)) {
+				order = append(order, "first")
+				if e.Nick == "spammer" {
+					return
+				}
+				e.Ctx = context.WithValue(context.Background(), testCtxKey{}, "tagged")
+				next(e)
+			})
+			irccon.AddEventMiddleware(func(e *Event, next func(*Event)) {
+				order = append(order, "second:"+e.Code)
+				if e.Code == "PRIVMSG" {
+					e.Arguments[1] = strings.ToUpper(e.Arguments[1])
+				}
+				next(e)
+			})
+
+			got := make(chan string, 10)
+			irccon.AddCallback("PRIVMSG", func(e *Event) {
+				got <- fmt.Sprintf("%s %s %v", e.Nick, e.Message(), e.Ctx.Value(testCtxKey{}))
+			})
+			irccon.AddCallback("CTCP_ACTION", func(e *Event) { got <- "action " + e.Message() })
+
+			server := startReadLoop(t, irccon)
+			go server.Write([]byte(":spammer!u@h PRIVMSG #go :buy now\r\n" +
+				":nick!u@h PRIVMSG #go :\x01ACTION waves\x01\r\n" +
+				":nick!u@h PRIVMSG #go :hello\r\n"))
+
+			for _, want := range []string{"action waves", "nick HELLO tagged"} {
+				select {
+				case g := <-got:
+					if g != want {
+						t.Fatalf("got %q, want %q", g, want)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("timed out waiting for %q", want)
+				}
+			}
+			select {
+			case g := <-got:
+				t.Fatalf("dropped event reached a callback: %q", g)
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			wantOrder := "first first second:CTCP_ACTION first second:PRIVMSG"
+			if strings.Join(order, " ") != wantOrder {
+				t.Fatalf("middleware order %q, want %q", order, wantOrder)
+			}
+		})
+	}
+}
+
+func TestEventMiddlewarePooledOwnership(t *testing.T) {
+	for _, workers := range []int{0, 1} {
+		t.Run(fmt.Sprint("workers=", workers), func(t *testing.T) {
+			irccon := IRC("me", "testuser")
+			irccon.PoolEvents = true
+			irccon.DispatchWorkers = workers
+
+			var replacements []*Event
+			irccon.AddEventMiddleware(func(e *Event, next func(*Event)) {
+				if e.Message() == "replace" {
+					r := &Event{Code: e.Code, Nick: e.Nick, Arguments: []string{e.Arguments[0], "replaced"}, Connection: irccon}
+					replacements = append(replacements, r)
+					next(r)
+					return
+				}
+				next(e)
+				next(e)
+			})
+			got := make(chan string, 10)
+			irccon.AddCallback("PRIVMSG", func(e *Event) { got <- e.Message() })
+
+			server := startReadLoop(t, irccon)
+			go server.Write([]byte(":nick!u@h PRIVMSG #go :replace\r\n" +
+				":nick!u@h PRIVMSG #go :twice\r\n:nick!u@h PRIVMSG #go :end\r\n"))
+
+			for _, want := range []string{"replaced", "twice", "end"} {
+				select {
+				case g := <-got:
+					if g != want {
+						t.Fatalf("got %q, want %q", g, want)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatalf("timed out waiting for %q", want)
+				}
+			}
+			select {
+			case g := <-got:
+				t.Fatalf("second call to next delivered %q again", g)
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			if r := replacements[0]; r.Code != "PRIVMSG" || r.Message() != "replaced" {
+				t.Fatalf("event created by middleware was recycled: %#v", r)
+			}
+		})
+	}
+}
+
+func TestEventMiddlewareSeesSyntheticEvents(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	var codes []string
+	irccon.AddEventMiddleware(func(e *Event, next func(*Event)) {
+		codes = append(codes, e.Code)
+		next(e)
+	})
+	irccon.emitDisconnected("bye")
+	if len(codes) != 1 || codes[0] != EventDisconnected {
+		t.Fatalf("middleware saw %q, want only %s", codes, EventDisconnected)
+	}
+}
+
+func TestLineMiddleware(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.AddLineMiddleware(func(line string, next func(string)) {
+		if strings.HasPrefix(line, "PRIVMSG #secret ") {
+			return
+		}
+		next(line)
+	})
+	irccon.AddLineMiddleware(func(line string, next func(string)) {
+		next("@label=1 " + line)
+		if strings.HasPrefix(line, "QUIT") {
+			next("PING :again")
+		}
+	})
+
+	client, server := net.Pipe()
+	irccon.socket = client
+	irccon.Encoding = encoding.Nop
+	irccon.end = make(chan struct{})
+	irccon.Error = make(chan error, 10)
+	irccon.pwrite = make(chan string, 10)
+	irccon.Add(1)
+	go irccon.writeLoop()
+	t.Cleanup(func() {
+		close(irccon.end)
+		server.Close()
+		irccon.Wait()
+		client.Close()
+	})
+
+	irccon.Privmsg("#secret", "password")
+	irccon.Privmsg("#go", "hi")
+	irccon.SendRaw("QUIT :bye")
+
+	r := bufio.NewReader(server)
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, want := range []string{"@label=1 PRIVMSG #go :hi\r\n", "@label=1 QUIT :bye\r\n", "PING :again\r\n"} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading %q: %v", want, err)
+		}
+		if line != want {
+			t.Fatalf("wrote %q, want %q", line, want)
+		}
+	}
+}
@@ -0,0 +1,602 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event codes emitted by the presence tracker when a monitored nick
+// changes state. Event.Nick holds the monitored nick; User and Host are
+// filled in when the server reported the full mask.
+const (
+	EventMonitorOnline  = "MONITOR_ONLINE"
+	EventMonitorOffline = "MONITOR_OFFLINE"
+)
+
+// DefaultMonitorPollInterval is the ISON polling interval used on servers
+// without MONITOR support when MonitorPollInterval is not set.
+const DefaultMonitorPollInterval = time.Minute
+
+// maxPresenceLineLen bounds the target list of a single MONITOR or ISON
+// line so the full line stays well below the 512 byte protocol limit.
+const maxPresenceLineLen = 400
+
+type presenceMode int
+
+const (
+	presenceIdle    presenceMode = iota // not registered yet in this session
+	presenceMonitor                     // server supports MONITOR
+	presenceISON                        // fall back to ISON polling
+)
+
+// monitorTracker holds the presence-tracking state of a connection.
+// Targets survive reconnects; everything else is per session.
+type monitorTracker struct {
+	mu        sync.Mutex
+	targets   map[string]string // canonical nick -> nick as requested by the user
+	internal  map[string]string // canonical nick -> nick watched by the library itself
+	online    map[string]bool   // canonical nick -> last known presence
+	sent      map[string]bool   // canonical nick -> on the server's MONITOR list
+	mode      presenceMode
+	limit     int        // MONITOR list limit from ISUPPORT, 0 = unlimited
+	session   uint64     // increments on every session reset
+	isonQueue [][]string // outstanding ISON batches, oldest first
+}
+
+type presenceChange struct {
+	mask     string
+	online   bool
+	user     bool // monitored through Monitor()
+	internal bool // watched by the library (nick regain)
+}
+
+func newMonitorTracker() *monitorTracker {
+	return &monitorTracker{
+		targets:  make(map[string]string),
+		internal: make(map[string]string),
+		online:   make(map[string]bool),
+		sent:     make(map[string]bool),
+	}
+}
+
+// watchedLocked returns the watched nick for a canonical key and who is
+// interested in it.
+func (m *monitorTracker) watchedLocked(key string) (nick string, user, internal bool) {
+	if n, ok := m.internal[key]; ok {
+		nick, internal = n, true
+	}
+	if n, ok := m.targets[key]; ok {
+		nick, user = n, true
+	}
+	return nick, user, internal
+}
+
+// changeLocked records presence for a watched key and returns the change to
+// report, if any.
+func (m *monitorTracker) changeLocked(key, mask string, online bool) (presenceChange, bool) {
+	_, user, internal := m.watchedLocked(key)
+	if !user && !internal {
+		return presenceChange{}, false
+	}
+	if !m.setPresenceLocked(key, online) {
+		return presenceChange{}, false
+	}
+	return presenceChange{mask: mask, online: online, user: user, internal: internal}, true
+}
+
+// resetSession forgets what the previous server knew about our list.
+// Targets and last known presence are kept so the list is re-applied
+// after reconnecting and only real changes are reported.
+func (m *monitorTracker) resetSession() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mode = presenceIdle
+	m.limit = 0
+	m.sent = make(map[string]bool)
+	m.isonQueue = nil
+	m.session++
+}
+
+// forgetPresence drops the last known presence of a nick so the next
+// report about it is treated as a change.
+func (m *monitorTracker) forgetPresence(nick string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.online, canonicalizeRFCNick(nick))
+}
+
+// setPresenceLocked records the presence of a target and reports whether
+// it changed (the first report for a target always counts as a change).
+func (m *monitorTracker) setPresenceLocked(key string, online bool) bool {
+	prev, known := m.online[key]
+	m.online[key] = online
+	return !known || prev != online
+}
+
+// monitorAddLinesLocked marks nicks as sent and returns the MONITOR +
+// lines for them, honouring the server's list limit. It also returns the
+// number of nicks that did not fit.
+func (m *monitorTracker) monitorAddLinesLocked(nicks []string) (lines []*Message, dropped int) {
+	var batch []string
+	for _, nick := range nicks {
+		key := canonicalizeRFCNick(nick)
+		if m.sent[key] {
+			continue
+		}
+		if m.limit > 0 && len(m.sent) >= m.limit {
+			dropped++
+			continue
+		}
+		m.sent[key] = true
+		batch = append(batch, nick)
+	}
+	return joinPresenceLines("+", batch), dropped
+}
+
+func (m *monitorTracker) sortedTargetsLocked() []string {
+	nicks := make([]string, 0, len(m.targets))
+	for _, nick := range m.targets {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+// sortedWatchedLocked returns user targets and internal watches combined.
+func (m *monitorTracker) sortedWatchedLocked() []string {
+	nicks := m.sortedTargetsLocked()
+	for key, nick := range m.internal {
+		if _, ok := m.targets[key]; !ok {
+			nicks = append(nicks, nick)
+		}
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+// joinPresenceLines packs targets into as few "MONITOR <sign>" lines as
+// possible while keeping each line under maxPresenceLineLen.
+func joinPresenceLines(sign string, targets []string) []*Message {
+	var lines []*Message
+	var batch []string
+	size := len("MONITOR ") + len(sign)
+	for _, target := range targets {
+		if len(batch) > 0 && size+1+len(target) > maxPresenceLineLen {
+			lines = append(lines, NewMessage("MONITOR", sign, strings.Join(batch, ",")))
+			batch, size = nil, len("MONITOR ")+len(sign)
+		}
+		batch = append(batch, target)
+		size += 1 + len(target)
+	}
+	if len(batch) > 0 {
+		lines = append(lines, NewMessage("MONITOR", sign, strings.Join(batch, ",")))
+	}
+	return lines
+}
+
+// Monitor adds nicks to the presence-tracking list. When the server
+// supports MONITOR the nicks are added to the server-side list (within the
+// MONITOR limit advertised in ISUPPORT); otherwise they are included in
+// the next ISON poll. EventMonitorOnline and EventMonitorOffline events are
+// emitted whenever a monitored nick changes state.
+//
+// The list is kept across reconnects and re-applied after registration.
+func (irc *Connection) Monitor(nicks ...string) {
+	m := irc.monitor
+	m.mu.Lock()
+	var added []string
+	for _, nick := range nicks {
+		key := canonicalizeRFCNick(nick)
+		if nick == "" {
+			continue
+		}
+		if _, ok := m.targets[key]; ok {
+			continue
+		}
+		m.targets[key] = nick
+		added = append(added, nick)
+	}
+	var lines []*Message
+	var dropped int
+	if m.mode == presenceMonitor {
+		lines, dropped = m.monitorAddLinesLocked(added)
+	}
+	m.mu.Unlock()
+
+	irc.logMonitorDropped(dropped)
+	for _, line := range lines {
+		irc.sendMessage(line)
+	}
+}
+
+// Unmonitor removes nicks from the presence-tracking list.
+func (irc *Connection) Unmonitor(nicks ...string) {
+	m := irc.monitor
+	m.mu.Lock()
+	var removed []string
+	for _, nick := range nicks {
+		key := canonicalizeRFCNick(nick)
+		if _, ok := m.targets[key]; !ok {
+			continue
+		}
+		delete(m.targets, key)
+		if _, ok := m.internal[key]; ok {
+			continue
+		}
+		delete(m.online, key)
+		if m.sent[key] {
+			delete(m.sent, key)
+			removed = append(removed, nick)
+		}
+	}
+	var lines []*Message
+	if m.mode == presenceMonitor {
+		lines = joinPresenceLines("-", removed)
+	}
+	m.mu.Unlock()
+
+	for _, line := range lines {
+		irc.sendMessage(line)
+	}
+}
+
+// ClearMonitor empties the presence-tracking list. Nicks the library
+// watches for itself (nick regain) stay on the server-side list.
+func (irc *Connection) ClearMonitor() {
+	m := irc.monitor
+	m.mu.Lock()
+	var removed []string
+	for key, nick := range m.targets {
+		if _, ok := m.internal[key]; ok {
+			continue
+		}
+		delete(m.online, key)
+		if m.sent[key] {
+			delete(m.sent, key)
+			removed = append(removed, nick)
+		}
+	}
+	m.targets = make(map[string]string)
+	var lines []*Message
+	if m.mode == presenceMonitor {
+		if len(m.internal) == 0 {
+			lines = []*Message{NewMessage("MONITOR", "C")}
+		} else {
+			sort.Strings(removed)
+			lines = joinPresenceLines("-", removed)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, line := range lines {
+		irc.sendMessage(line)
+	}
+}
+
+// watchInternal adds a nick the library itself needs presence updates for.
+func (irc *Connection) watchInternal(nick string) {
+	m := irc.monitor
+	m.mu.Lock()
+	key := canonicalizeRFCNick(nick)
+	if _, ok := m.internal[key]; ok {
+		m.mu.Unlock()
+		return
+	}
+	m.internal[key] = nick
+	var lines []*Message
+	var dropped int
+	if m.mode == presenceMonitor {
+		lines, dropped = m.monitorAddLinesLocked([]string{nick})
+	}
+	m.mu.Unlock()
+
+	irc.logMonitorDropped(dropped)
+	for _, line := range lines {
+		irc.sendMessage(line)
+	}
+}
+
+// unwatchInternal drops an internal watch added by watchInternal.
+func (irc *Connection) unwatchInternal(nick string) {
+	m := irc.monitor
+	m.mu.Lock()
+	key := canonicalizeRFCNick(nick)
+	if _, ok := m.internal[key]; !ok {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.internal, key)
+	var lines []*Message
+	if _, ok := m.targets[key]; !ok {
+		delete(m.online, key)
+		if m.sent[key] {
+			delete(m.sent, key)
+			if m.mode == presenceMonitor {
+				lines = []*Message{NewMessage("MONITOR", "-", nick)}
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, line := range lines {
+		irc.sendMessage(line)
+	}
+}
+
+// MonitorList returns the monitored nicks in sorted order.
+func (irc *Connection) MonitorList() []string {
+	m := irc.monitor
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sortedTargetsLocked()
+}
+
+// MonitorStatus returns the last known presence of a monitored nick.
+// known is false until the server has reported on the nick, or when the
+// nick is not monitored.
+func (irc *Connection) MonitorStatus(nick string) (online, known bool) {
+	m := irc.monitor
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	online, known = m.online[canonicalizeRFCNick(nick)]
+	return online, known
+}
+
+func (irc *Connection) logMonitorDropped(dropped int) {
+	if dropped > 0 {
+		irc.Log.Printf("MONITOR list limit reached, %d nick(s) not monitored", dropped)
+	}
+}
+
+// startPresenceTracking picks MONITOR or ISON for the current session and
+// pushes the target list to the server. It runs once per session, after
+// the server has finished sending ISUPPORT (end of MOTD).
+func (irc *Connection) startPresenceTracking() {
+	_, hasMonitor := irc.ISupport("MONITOR")
+	limit := irc.isupportInt("MONITOR", 0)
+	irc.Lock()
+	end := irc.end
+	irc.Unlock()
+
+	lines, dropped, session, ok := irc.monitor.start(hasMonitor, limit)
+	if !ok {
+		return
+	}
+	irc.logMonitorDropped(dropped)
+	for _, line := range lines {
+		irc.sendMessage(line)
+	}
+	if !hasMonitor {
+		go irc.isonPollLoop(session, end)
+	}
+}
+
+// start switches an idle session to MONITOR or ISON. It returns the
+// MONITOR lines to send and false if the session was already started.
+func (m *monitorTracker) start(hasMonitor bool, limit int) (lines []*Message, dropped int, session uint64, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mode != presenceIdle {
+		return nil, 0, 0, false
+	}
+	if hasMonitor {
+		m.mode = presenceMonitor
+		m.limit = limit
+		lines, dropped = m.monitorAddLinesLocked(m.sortedWatchedLocked())
+	} else {
+		m.mode = presenceISON
+	}
+	return lines, dropped, m.session, true
+}
+
+// isonPollLoop polls ISON for servers without MONITOR until the session
+// ends. To be used as a goroutine.
+func (irc *Connection) isonPollLoop(session uint64, end <-chan struct{}) {
+	interval := irc.MonitorPollInterval
+	if interval <= 0 {
+		interval = DefaultMonitorPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for irc.pollISON(session) {
+		select {
+		case <-end:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollISON sends one round of ISON queries. It returns false once the
+// session it was started for is over.
+func (irc *Connection) pollISON(session uint64) bool {
+	m := irc.monitor
+	m.mu.Lock()
+	if m.session != session || m.mode != presenceISON {
+		m.mu.Unlock()
+		return false
+	}
+	// Unanswered batches from the previous round are dropped; replies are
+	// matched to batches in order, so a stale queue would misattribute them.
+	m.isonQueue = nil
+	var lines []*Message
+	var batch []string
+	size := 0
+	for _, nick := range m.sortedWatchedLocked() {
+		if len(batch) > 0 && size+1+len(nick) > maxPresenceLineLen {
+			m.isonQueue = append(m.isonQueue, batch)
+			lines = append(lines, NewMessage("ISON", batch...))
+			batch, size = nil, 0
+		}
+		batch = append(batch, nick)
+		size += 1 + len(nick)
+	}
+	if len(batch) > 0 {
+		m.isonQueue = append(m.isonQueue, batch)
+		lines = append(lines, NewMessage("ISON", batch...))
+	}
+	m.mu.Unlock()
+
+	for _, line := range lines {
+		irc.sendMessage(line)
+	}
+	return true
+}
+
+// emitPresenceChanges raises EventMonitorOnline/EventMonitorOffline for
+// user targets, see queueEvent, and forwards changes of internal watches.
+func (irc *Connection) emitPresenceChanges(changes []presenceChange) {
+	for _, change := range changes {
+		if change.internal {
+			nick, _, _ := strings.Cut(change.mask, "!")
+			irc.regainPresenceChanged(nick, change.online)
+		}
+		if !change.user {
+			continue
+		}
+		code := EventMonitorOffline
+		if change.online {
+			code = EventMonitorOnline
+		}
+		event := &Event{
+			Code:       code,
+			Raw:        change.mask,
+			Nick:       change.mask,
+			Source:     change.mask,
+			Connection: irc,
+		}
+		if i, j := strings.Index(change.mask, "!"), strings.Index(change.mask, "@"); i > -1 && j > i {
+			event.Nick = change.mask[:i]
+			event.User = change.mask[i+1 : j]
+			event.Host = change.mask[j+1:]
+		}
+		event.Arguments = []string{event.Nick}
+		irc.queueEvent(event)
+	}
+}
+
+// handleMonitorReply processes RPL_MONONLINE (730) and RPL_MONOFFLINE (731).
+//
+// Format: :server 730 <nick> :target!user@host[,target2!user@host]
+// Format: :server 731 <nick> :target[,target2]
+func (irc *Connection) handleMonitorReply(e *Event, online bool) {
+	irc.emitPresenceChanges(irc.monitor.monitorReply(e.Message(), online))
+}
+
+// monitorReply records the targets of a 730/731 reply and returns the
+// resulting presence changes.
+func (m *monitorTracker) monitorReply(targets string, online bool) []presenceChange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changes []presenceChange
+	for _, mask := range strings.Split(targets, ",") {
+		nick, _, _ := strings.Cut(mask, "!")
+		if nick == "" {
+			continue
+		}
+		if change, ok := m.changeLocked(canonicalizeRFCNick(nick), mask, online); ok {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// handleISONReply processes RPL_ISON (303) for the oldest outstanding batch.
+//
+// Format: :server 303 <nick> :nick1 nick2
+func (irc *Connection) handleISONReply(e *Event) {
+	irc.emitPresenceChanges(irc.monitor.isonReply(e.Message()))
+}
+
+// isonReply matches an ISON reply to the oldest outstanding batch and
+// returns the resulting presence changes.
+func (m *monitorTracker) isonReply(reply string) []presenceChange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.isonQueue) == 0 {
+		// Not one of ours (e.g. a user-issued ISON).
+		return nil
+	}
+	batch := m.isonQueue[0]
+	m.isonQueue = m.isonQueue[1:]
+
+	present := make(map[string]bool)
+	for _, nick := range strings.Fields(reply) {
+		present[canonicalizeRFCNick(nick)] = true
+	}
+	var changes []presenceChange
+	for _, nick := range batch {
+		key := canonicalizeRFCNick(nick)
+		if change, ok := m.changeLocked(key, nick, present[key]); ok {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// forgetSent drops nicks the server refused to monitor.
+func (m *monitorTracker) forgetSent(nicks []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, nick := range nicks {
+		delete(m.sent, canonicalizeRFCNick(nick))
+	}
+}
+
+// setupMonitorCallbacks installs the handlers driving presence tracking.
+func (irc *Connection) setupMonitorCallbacks() {
+	// Start once ISUPPORT is complete (end of MOTD or no MOTD)
+	irc.AddCallback(RPL_ENDOFMOTD, func(e *Event) { irc.startPresenceTracking() })
+	irc.AddCallback(ERR_NOMOTD, func(e *Event) { irc.startPresenceTracking() })
+
+	irc.AddCallback(RPL_MONONLINE, func(e *Event) { irc.handleMonitorReply(e, true) })
+	irc.AddCallback(RPL_MONOFFLINE, func(e *Event) { irc.handleMonitorReply(e, false) })
+
+	// RPL_MONLIST (732): keep our view of the server-side list in sync
+	irc.AddCallback(RPL_MONLIST, func(e *Event) {
+		m := irc.monitor
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for _, nick := range strings.Split(e.Message(), ",") {
+			key := canonicalizeRFCNick(nick)
+			if _, user, internal := m.watchedLocked(key); user || internal {
+				m.sent[key] = true
+			}
+		}
+	})
+
+	// ERR_MONLISTFULL (734): :server 734 <nick> <limit> <targets> :Monitor list is full.
+	irc.AddCallback(ERR_MONLISTFULL, func(e *Event) {
+		if len(e.Arguments) < 3 {
+			return
+		}
+		irc.monitor.forgetSent(strings.Split(e.Arguments[2], ","))
+		irc.Log.Printf("MONITOR list is full (limit %s), not monitored: %s", e.Arguments[1], e.Arguments[2])
+	})
+
+	irc.AddCallback(RPL_ISON, irc.handleISONReply)
+}
@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func collectPresence(irccon *Connection) <-chan *Event {
+	events := make(chan *Event, 16)
+	irccon.AddCallback(EventMonitorOnline, func(e *Event) { events <- e })
+	irccon.AddCallback(EventMonitorOffline, func(e *Event) { events <- e })
+	return events
+}
+
+func expectPresence(t *testing.T, events <-chan *Event, code, nick string) *Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		if e.Code != code || e.Nick != nick {
+			t.Fatalf("presence event = %s %q, want %s %q", e.Code, e.Nick, code, nick)
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("no %s event for %q", code, nick)
+		return nil
+	}
+}
+
+func TestMonitorUsesServerLimitAndReportsPresence(t *testing.T) {
+	irccon := IRC("monbot", "monuser")
+	irccon.pwrite = make(chan string, 8)
+	events := collectPresence(irccon)
+
+	irccon.Monitor("alice", "bob", "carol", "Alice")
+	irccon.RunCallbacks(&Event{Code: "005", Arguments: []string{"monbot", "MONITOR=2", "NICKLEN=30", "are supported by this server"}})
+	irccon.RunCallbacks(&Event{Code: "376", Arguments: []string{"monbot", "End of MOTD"}})
+
+	if got := nextRawCommand(t, irccon.pwrite); got != "MONITOR + alice,bob\r\n" {
+		t.Fatalf("command = %q, want MONITOR + alice,bob", got)
+	}
+
+	irccon.RunCallbacks(&Event{Code: "730", Arguments: []string{"monbot", "alice!a@host.example"}})
+	e := expectPresence(t, events, EventMonitorOnline, "alice")
+	if e.User != "a" || e.Host != "host.example" {
+		t.Fatalf("online event mask = %q@%q", e.User, e.Host)
+	}
+	if online, known := irccon.MonitorStatus("ALICE"); !online || !known {
+		t.Fatalf("MonitorStatus(ALICE) = %v, %v", online, known)
+	}
+
+	// Repeated state is not reported again.
+	irccon.RunCallbacks(&Event{Code: "730", Arguments: []string{"monbot", "alice!a@host.example"}})
+	irccon.RunCallbacks(&Event{Code: "731", Arguments: []string{"monbot", "alice,bob"}})
+	expectPresence(t, events, EventMonitorOffline, "alice")
+	expectPresence(t, events, EventMonitorOffline, "bob")
+
+	irccon.Unmonitor("bob")
+	if got := nextRawCommand(t, irccon.pwrite); got != "MONITOR - bob\r\n" {
+		t.Fatalf("command = %q, want MONITOR - bob", got)
+	}
+	if got := irccon.MonitorList(); len(got) != 2 || got[0] != "alice" || got[1] != "carol" {
+		t.Fatalf("MonitorList() = %v", got)
+	}
+}
+
+func TestMonitorIsReappliedAfterReconnect(t *testing.T) {
+	irccon := IRC("monbot", "monuser")
+	irccon.pwrite = make(chan string, 8)
+
+	irccon.Monitor("alice")
+	for session := 0; session < 2; session++ {
+		irccon.Lock()
+		irccon.resetRegistrationStateLocked()
+		irccon.Unlock()
+
+		irccon.RunCallbacks(&Event{Code: "005", Arguments: []string{"monbot", "MONITOR", "are supported by this server"}})
+		irccon.RunCallbacks(&Event{Code: "422", Arguments: []string{"monbot", "MOTD File is missing"}})
+
+		if got := nextRawCommand(t, irccon.pwrite); got != "MONITOR + alice\r\n" {
+			t.Fatalf("session %d: command = %q, want MONITOR + alice", session, got)
+		}
+	}
+}
+
+func TestMonitorFallsBackToISON(t *testing.T) {
+	irccon := IRC("monbot", "monuser")
+	irccon.pwrite = make(chan string, 8)
+	irccon.MonitorPollInterval = time.Hour
+	events := collectPresence(irccon)
+
+	irccon.Monitor("alice", "bob")
+	irccon.RunCallbacks(&Event{Code: "376", Arguments: []string{"monbot", "End of MOTD"}})
+
+	if got := nextRawCommand(t, irccon.pwrite); got != "ISON alice bob\r\n" {
+		t.Fatalf("command = %q, want ISON alice bob", got)
+	}
+
+	irccon.RunCallbacks(&Event{Code: "303", Arguments: []string{"monbot", "Alice"}})
+	expectPresence(t, events, EventMonitorOnline, "alice")
+	expectPresence(t, events, EventMonitorOffline, "bob")
+
+	irccon.closeEnd()
+}
+
+func TestISupportParsing(t *testing.T) {
+	irccon := IRC("isbot", "isuser")
+	irccon.RunCallbacks(&Event{Code: "005", Arguments: []string{"isbot", "NETWORK=Example\\x20Net", "WHOX", "CHANTYPES=#&", "are supported by this server"}})
+	irccon.RunCallbacks(&Event{Code: "005", Arguments: []string{"isbot", "-WHOX", "are supported by this server"}})
+
+	if v, ok := irccon.ISupport("network"); !ok || v != "Example Net" {
+		t.Fatalf("ISupport(network) = %q, %v", v, ok)
+	}
+	if _, ok := irccon.ISupport("WHOX"); ok {
+		t.Fatal("negated WHOX token still present")
+	}
+	if v, _ := irccon.ISupport("CHANTYPES"); v != "#&" {
+		t.Fatalf("ISupport(CHANTYPES) = %q", v)
+	}
+}
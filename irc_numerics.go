@@ -0,0 +1,348 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+// IRC numeric replies from RFC 1459, RFC 2812 and modern IRC servers
+// (https://modern.ircdocs.horse), for use as event codes:
+//
+//	conn.AddCallback(irc.ERR_NICKNAMEINUSE, func(e *irc.Event) { ... })
+//
+// LookupNumeric returns the symbolic name and argument layout of a code.
+const (
+	RPL_WELCOME           = "001"
+	RPL_YOURHOST          = "002"
+	RPL_CREATED           = "003"
+	RPL_MYINFO            = "004"
+	RPL_ISUPPORT          = "005"
+	RPL_BOUNCE            = "010"
+	RPL_HELLO             = "020"
+	RPL_UMODEIS           = "221"
+	RPL_LUSERCLIENT       = "251"
+	RPL_LUSEROP           = "252"
+	RPL_LUSERUNKNOWN      = "253"
+	RPL_LUSERCHANNELS     = "254"
+	RPL_LUSERME           = "255"
+	RPL_ADMINME           = "256"
+	RPL_ADMINLOC1         = "257"
+	RPL_ADMINLOC2         = "258"
+	RPL_ADMINEMAIL        = "259"
+	RPL_TRYAGAIN          = "263"
+	RPL_LOCALUSERS        = "265"
+	RPL_GLOBALUSERS       = "266"
+	RPL_WHOISCERTFP       = "276"
+	RPL_NONE              = "300"
+	RPL_AWAY              = "301"
+	RPL_USERHOST          = "302"
+	RPL_ISON              = "303"
+	RPL_UNAWAY            = "305"
+	RPL_NOWAWAY           = "306"
+	RPL_WHOISREGNICK      = "307"
+	RPL_WHOISUSER         = "311"
+	RPL_WHOISSERVER       = "312"
+	RPL_WHOISOPERATOR     = "313"
+	RPL_WHOWASUSER        = "314"
+	RPL_ENDOFWHO          = "315"
+	RPL_WHOISIDLE         = "317"
+	RPL_ENDOFWHOIS        = "318"
+	RPL_WHOISCHANNELS     = "319"
+	RPL_WHOISSPECIAL      = "320"
+	RPL_LISTSTART         = "321"
+	RPL_LIST              = "322"
+	RPL_LISTEND           = "323"
+	RPL_CHANNELMODEIS     = "324"
+	RPL_CREATIONTIME      = "329"
+	RPL_WHOISACCOUNT      = "330"
+	RPL_NOTOPIC           = "331"
+	RPL_TOPIC             = "332"
+	RPL_TOPICWHOTIME      = "333"
+	RPL_INVITELIST        = "336"
+	RPL_ENDOFINVITELIST   = "337"
+	RPL_WHOISACTUALLY     = "338"
+	RPL_INVITING          = "341"
+	RPL_INVEXLIST         = "346"
+	RPL_ENDOFINVEXLIST    = "347"
+	RPL_EXCEPTLIST        = "348"
+	RPL_ENDOFEXCEPTLIST   = "349"
+	RPL_VERSION           = "351"
+	RPL_WHOREPLY          = "352"
+	RPL_NAMREPLY          = "353"
+	RPL_WHOSPCRPL         = "354"
+	RPL_LINKS             = "364"
+	RPL_ENDOFLINKS        = "365"
+	RPL_ENDOFNAMES        = "366"
+	RPL_BANLIST           = "367"
+	RPL_ENDOFBANLIST      = "368"
+	RPL_ENDOFWHOWAS       = "369"
+	RPL_INFO              = "371"
+	RPL_MOTD              = "372"
+	RPL_ENDOFINFO         = "374"
+	RPL_MOTDSTART         = "375"
+	RPL_ENDOFMOTD         = "376"
+	RPL_WHOISHOST         = "378"
+	RPL_WHOISMODES        = "379"
+	RPL_YOUREOPER         = "381"
+	RPL_REHASHING         = "382"
+	RPL_TIME              = "391"
+	RPL_HOSTHIDDEN        = "396"
+	ERR_UNKNOWNERROR      = "400"
+	ERR_NOSUCHNICK        = "401"
+	ERR_NOSUCHSERVER      = "402"
+	ERR_NOSUCHCHANNEL     = "403"
+	ERR_CANNOTSENDTOCHAN  = "404"
+	ERR_TOOMANYCHANNELS   = "405"
+	ERR_WASNOSUCHNICK     = "406"
+	ERR_TOOMANYTARGETS    = "407"
+	ERR_NOORIGIN          = "409"
+	ERR_NORECIPIENT       = "411"
+	ERR_NOTEXTTOSEND      = "412"
+	ERR_TOOMANYMATCHES    = "416"
+	ERR_INPUTTOOLONG      = "417"
+	ERR_UNKNOWNCOMMAND    = "421"
+	ERR_NOMOTD            = "422"
+	ERR_NONICKNAMEGIVEN   = "431"
+	ERR_ERRONEUSNICKNAME  = "432"
+	ERR_NICKNAMEINUSE     = "433"
+	ERR_NICKCOLLISION     = "436"
+	ERR_UNAVAILRESOURCE   = "437"
+	ERR_USERNOTINCHANNEL  = "441"
+	ERR_NOTONCHANNEL      = "442"
+	ERR_USERONCHANNEL     = "443"
+	ERR_NOTREGISTERED     = "451"
+	ERR_NEEDMOREPARAMS    = "461"
+	ERR_ALREADYREGISTERED = "462"
+	ERR_PASSWDMISMATCH    = "464"
+	ERR_YOUREBANNEDCREEP  = "465"
+	ERR_CHANNELISFULL     = "471"
+	ERR_UNKNOWNMODE       = "472"
+	ERR_INVITEONLYCHAN    = "473"
+	ERR_BANNEDFROMCHAN    = "474"
+	ERR_BADCHANNELKEY     = "475"
+	ERR_BADCHANMASK       = "476"
+	ERR_NOCHANMODES       = "477"
+	ERR_BANLISTFULL       = "478"
+	ERR_NOPRIVILEGES      = "481"
+	ERR_CHANOPRIVSNEEDED  = "482"
+	ERR_CANTKILLSERVER    = "483"
+	ERR_RESTRICTED        = "484"
+	ERR_UNIQOPPRIVSNEEDED = "485"
+	ERR_NOOPERHOST        = "491"
+	ERR_UMODEUNKNOWNFLAG  = "501"
+	ERR_USERSDONTMATCH    = "502"
+	ERR_HELPNOTFOUND      = "524"
+	ERR_INVALIDKEY        = "525"
+	RPL_STARTTLS          = "670"
+	RPL_WHOISSECURE       = "671"
+	ERR_STARTTLS          = "691"
+	ERR_INVALIDMODEPARAM  = "696"
+	RPL_HELPSTART         = "704"
+	RPL_HELPTXT           = "705"
+	RPL_ENDOFHELP         = "706"
+	ERR_NOPRIVS           = "723"
+	RPL_MONONLINE         = "730"
+	RPL_MONOFFLINE        = "731"
+	RPL_MONLIST           = "732"
+	RPL_ENDOFMONLIST      = "733"
+	ERR_MONLISTFULL       = "734"
+	RPL_LOGGEDIN          = "900"
+	RPL_LOGGEDOUT         = "901"
+	ERR_NICKLOCKED        = "902"
+	RPL_SASLSUCCESS       = "903"
+	ERR_SASLFAIL          = "904"
+	ERR_SASLTOOLONG       = "905"
+	ERR_SASLABORTED       = "906"
+	ERR_SASLALREADY       = "907"
+	RPL_SASLMECHS         = "908"
+)
+
+// NumericInfo describes a numeric reply.
+type NumericInfo struct {
+	Name   string // symbolic name, e.g. "ERR_NICKNAMEINUSE"
+	Format string // typical argument layout, e.g. "<client> <nick> :Nickname is already in use"
+}
+
+// numerics maps reply codes to their descriptions.
+var numerics = map[string]NumericInfo{
+	RPL_WELCOME:           {"RPL_WELCOME", "<client> :Welcome to the Internet Relay Network <nick>!<user>@<host>"},
+	RPL_YOURHOST:          {"RPL_YOURHOST", "<client> :Your host is <servername>, running version <version>"},
+	RPL_CREATED:           {"RPL_CREATED", "<client> :This server was created <datetime>"},
+	RPL_MYINFO:            {"RPL_MYINFO", "<client> <servername> <version> <usermodes> <chanmodes> [<chanmodes with param>]"},
+	RPL_ISUPPORT:          {"RPL_ISUPPORT", "<client> <token>[=<value>] ... :are supported by this server"},
+	RPL_BOUNCE:            {"RPL_BOUNCE", "<client> <hostname> <port> :<info>"},
+	RPL_HELLO:             {"RPL_HELLO", "* :<message>"},
+	RPL_UMODEIS:           {"RPL_UMODEIS", "<client> <user modes>"},
+	RPL_LUSERCLIENT:       {"RPL_LUSERCLIENT", "<client> :There are <u> users and <i> invisible on <s> servers"},
+	RPL_LUSEROP:           {"RPL_LUSEROP", "<client> <ops> :operator(s) online"},
+	RPL_LUSERUNKNOWN:      {"RPL_LUSERUNKNOWN", "<client> <connections> :unknown connection(s)"},
+	RPL_LUSERCHANNELS:     {"RPL_LUSERCHANNELS", "<client> <channels> :channels formed"},
+	RPL_LUSERME:           {"RPL_LUSERME", "<client> :I have <c> clients and <s> servers"},
+	RPL_ADMINME:           {"RPL_ADMINME", "<client> [<server>] :Administrative info"},
+	RPL_ADMINLOC1:         {"RPL_ADMINLOC1", "<client> :<info>"},
+	RPL_ADMINLOC2:         {"RPL_ADMINLOC2", "<client> :<info>"},
+	RPL_ADMINEMAIL:        {"RPL_ADMINEMAIL", "<client> :<info>"},
+	RPL_TRYAGAIN:          {"RPL_TRYAGAIN", "<client> <command> :Please wait a while and try again."},
+	RPL_LOCALUSERS:        {"RPL_LOCALUSERS", "<client> [<u> <m>] :Current local users <u>, max <m>"},
+	RPL_GLOBALUSERS:       {"RPL_GLOBALUSERS", "<client> [<u> <m>] :Current global users <u>, max <m>"},
+	RPL_WHOISCERTFP:       {"RPL_WHOISCERTFP", "<client> <nick> :has client certificate fingerprint <fingerprint>"},
+	RPL_NONE:              {"RPL_NONE", "undefined"},
+	RPL_AWAY:              {"RPL_AWAY", "<client> <nick> :<message>"},
+	RPL_USERHOST:          {"RPL_USERHOST", "<client> :[<reply>{ <reply>}]"},
+	RPL_ISON:              {"RPL_ISON", "<client> :[<nick>{ <nick>}]"},
+	RPL_UNAWAY:            {"RPL_UNAWAY", "<client> :You are no longer marked as being away"},
+	RPL_NOWAWAY:           {"RPL_NOWAWAY", "<client> :You have been marked as being away"},
+	RPL_WHOISREGNICK:      {"RPL_WHOISREGNICK", "<client> <nick> :has identified for this nick"},
+	RPL_WHOISUSER:         {"RPL_WHOISUSER", "<client> <nick> <username> <host> * :<realname>"},
+	RPL_WHOISSERVER:       {"RPL_WHOISSERVER", "<client> <nick> <server> :<server info>"},
+	RPL_WHOISOPERATOR:     {"RPL_WHOISOPERATOR", "<client> <nick> :is an IRC operator"},
+	RPL_WHOWASUSER:        {"RPL_WHOWASUSER", "<client> <nick> <username> <host> * :<realname>"},
+	RPL_ENDOFWHO:          {"RPL_ENDOFWHO", "<client> <mask> :End of WHO list"},
+	RPL_WHOISIDLE:         {"RPL_WHOISIDLE", "<client> <nick> <secs> <signon> :seconds idle, signon time"},
+	RPL_ENDOFWHOIS:        {"RPL_ENDOFWHOIS", "<client> <nick> :End of /WHOIS list"},
+	RPL_WHOISCHANNELS:     {"RPL_WHOISCHANNELS", "<client> <nick> :[prefix]<channel>{ [prefix]<channel>}"},
+	RPL_WHOISSPECIAL:      {"RPL_WHOISSPECIAL", "<client> <nick> :blah blah blah"},
+	RPL_LISTSTART:         {"RPL_LISTSTART", "<client> Channel :Users  Name"},
+	RPL_LIST:              {"RPL_LIST", "<client> <channel> <client count> :<topic>"},
+	RPL_LISTEND:           {"RPL_LISTEND", "<client> :End of /LIST"},
+	RPL_CHANNELMODEIS:     {"RPL_CHANNELMODEIS", "<client> <channel> <modestring> <mode arguments>..."},
+	RPL_CREATIONTIME:      {"RPL_CREATIONTIME", "<client> <channel> <creationtime>"},
+	RPL_WHOISACCOUNT:      {"RPL_WHOISACCOUNT", "<client> <nick> <account> :is logged in as"},
+	RPL_NOTOPIC:           {"RPL_NOTOPIC", "<client> <channel> :No topic is set"},
+	RPL_TOPIC:             {"RPL_TOPIC", "<client> <channel> :<topic>"},
+	RPL_TOPICWHOTIME:      {"RPL_TOPICWHOTIME", "<client> <channel> <nick> <setat>"},
+	RPL_INVITELIST:        {"RPL_INVITELIST", "<client> <channel>"},
+	RPL_ENDOFINVITELIST:   {"RPL_ENDOFINVITELIST", "<client> :End of /INVITE list"},
+	RPL_WHOISACTUALLY:     {"RPL_WHOISACTUALLY", "<client> <nick> [<username>@<hostname>] [<ip>] :Is actually using host"},
+	RPL_INVITING:          {"RPL_INVITING", "<client> <nick> <channel>"},
+	RPL_INVEXLIST:         {"RPL_INVEXLIST", "<client> <channel> <mask>"},
+	RPL_ENDOFINVEXLIST:    {"RPL_ENDOFINVEXLIST", "<client> <channel> :End of Channel Invite Exception List"},
+	RPL_EXCEPTLIST:        {"RPL_EXCEPTLIST", "<client> <channel> <mask>"},
+	RPL_ENDOFEXCEPTLIST:   {"RPL_ENDOFEXCEPTLIST", "<client> <channel> :End of channel exception list"},
+	RPL_VERSION:           {"RPL_VERSION", "<client> <version> <server> :<comments>"},
+	RPL_WHOREPLY:          {"RPL_WHOREPLY", "<client> <channel> <username> <host> <server> <nick> <flags> :<hopcount> <realname>"},
+	RPL_NAMREPLY:          {"RPL_NAMREPLY", "<client> <symbol> <channel> :[prefix]<nick>{ [prefix]<nick>}"},
+	RPL_WHOSPCRPL:         {"RPL_WHOSPCRPL", "<client> [<token>] <requested fields...>"},
+	RPL_LINKS:             {"RPL_LINKS", "<client> * <server> :<hopcount> <server info>"},
+	RPL_ENDOFLINKS:        {"RPL_ENDOFLINKS", "<client> * :End of /LINKS list"},
+	RPL_ENDOFNAMES:        {"RPL_ENDOFNAMES", "<client> <channel> :End of /NAMES list"},
+	RPL_BANLIST:           {"RPL_BANLIST", "<client> <channel> <mask> [<who> <set-ts>]"},
+	RPL_ENDOFBANLIST:      {"RPL_ENDOFBANLIST", "<client> <channel> :End of channel ban list"},
+	RPL_ENDOFWHOWAS:       {"RPL_ENDOFWHOWAS", "<client> <nick> :End of WHOWAS"},
+	RPL_INFO:              {"RPL_INFO", "<client> :<string>"},
+	RPL_MOTD:              {"RPL_MOTD", "<client> :<line of the motd>"},
+	RPL_ENDOFINFO:         {"RPL_ENDOFINFO", "<client> :End of INFO list"},
+	RPL_MOTDSTART:         {"RPL_MOTDSTART", "<client> :- <server> Message of the day -"},
+	RPL_ENDOFMOTD:         {"RPL_ENDOFMOTD", "<client> :End of /MOTD command."},
+	RPL_WHOISHOST:         {"RPL_WHOISHOST", "<client> <nick> :is connecting from *@localhost 127.0.0.1"},
+	RPL_WHOISMODES:        {"RPL_WHOISMODES", "<client> <nick> :is using modes +ailosw"},
+	RPL_YOUREOPER:         {"RPL_YOUREOPER", "<client> :You are now an IRC operator"},
+	RPL_REHASHING:         {"RPL_REHASHING", "<client> <config file> :Rehashing"},
+	RPL_TIME:              {"RPL_TIME", "<client> <server> [<timestamp> [<TS offset>]] :<human-readable time>"},
+	RPL_HOSTHIDDEN:        {"RPL_HOSTHIDDEN", "<client> <host> :is now your displayed host"},
+	ERR_UNKNOWNERROR:      {"ERR_UNKNOWNERROR", "<client> <command>{ <subcommand>} :<info>"},
+	ERR_NOSUCHNICK:        {"ERR_NOSUCHNICK", "<client> <nickname> :No such nick/channel"},
+	ERR_NOSUCHSERVER:      {"ERR_NOSUCHSERVER", "<client> <server name> :No such server"},
+	ERR_NOSUCHCHANNEL:     {"ERR_NOSUCHCHANNEL", "<client> <channel> :No such channel"},
+	ERR_CANNOTSENDTOCHAN:  {"ERR_CANNOTSENDTOCHAN", "<client> <channel> :Cannot send to channel"},
+	ERR_TOOMANYCHANNELS:   {"ERR_TOOMANYCHANNELS", "<client> <channel> :You have joined too many channels"},
+	ERR_WASNOSUCHNICK:     {"ERR_WASNOSUCHNICK", "<client> <nickname> :There was no such nickname"},
+	ERR_TOOMANYTARGETS:    {"ERR_TOOMANYTARGETS", "<client> <target> :Duplicate recipients. No message delivered"},
+	ERR_NOORIGIN:          {"ERR_NOORIGIN", "<client> :No origin specified"},
+	ERR_NORECIPIENT:       {"ERR_NORECIPIENT", "<client> :No recipient given (<command>)"},
+	ERR_NOTEXTTOSEND:      {"ERR_NOTEXTTOSEND", "<client> :No text to send"},
+	ERR_TOOMANYMATCHES:    {"ERR_TOOMANYMATCHES", "<client> <command> [<mask>] :<info>"},
+	ERR_INPUTTOOLONG:      {"ERR_INPUTTOOLONG", "<client> :Input line was too long"},
+	ERR_UNKNOWNCOMMAND:    {"ERR_UNKNOWNCOMMAND", "<client> <command> :Unknown command"},
+	ERR_NOMOTD:            {"ERR_NOMOTD", "<client> :MOTD File is missing"},
+	ERR_NONICKNAMEGIVEN:   {"ERR_NONICKNAMEGIVEN", "<client> :No nickname given"},
+	ERR_ERRONEUSNICKNAME:  {"ERR_ERRONEUSNICKNAME", "<client> <nick> :Erroneus nickname"},
+	ERR_NICKNAMEINUSE:     {"ERR_NICKNAMEINUSE", "<client> <nick> :Nickname is already in use"},
+	ERR_NICKCOLLISION:     {"ERR_NICKCOLLISION", "<client> <nick> :Nickname collision KILL from <user>@<host>"},
+	ERR_UNAVAILRESOURCE:   {"ERR_UNAVAILRESOURCE", "<client> <nick/channel> :Nick/channel is temporarily unavailable"},
+	ERR_USERNOTINCHANNEL:  {"ERR_USERNOTINCHANNEL", "<client> <nick> <channel> :They aren't on that channel"},
+	ERR_NOTONCHANNEL:      {"ERR_NOTONCHANNEL", "<client> <channel> :You're not on that channel"},
+	ERR_USERONCHANNEL:     {"ERR_USERONCHANNEL", "<client> <nick> <channel> :is already on channel"},
+	ERR_NOTREGISTERED:     {"ERR_NOTREGISTERED", "<client> :You have not registered"},
+	ERR_NEEDMOREPARAMS:    {"ERR_NEEDMOREPARAMS", "<client> <command> :Not enough parameters"},
+	ERR_ALREADYREGISTERED: {"ERR_ALREADYREGISTERED", "<client> :You may not reregister"},
+	ERR_PASSWDMISMATCH:    {"ERR_PASSWDMISMATCH", "<client> :Password incorrect"},
+	ERR_YOUREBANNEDCREEP:  {"ERR_YOUREBANNEDCREEP", "<client> :You are banned from this server."},
+	ERR_CHANNELISFULL:     {"ERR_CHANNELISFULL", "<client> <channel> :Cannot join channel (+l)"},
+	ERR_UNKNOWNMODE:       {"ERR_UNKNOWNMODE", "<client> <modechar> :is unknown mode char to me"},
+	ERR_INVITEONLYCHAN:    {"ERR_INVITEONLYCHAN", "<client> <channel> :Cannot join channel (+i)"},
+	ERR_BANNEDFROMCHAN:    {"ERR_BANNEDFROMCHAN", "<client> <channel> :Cannot join channel (+b)"},
+	ERR_BADCHANNELKEY:     {"ERR_BADCHANNELKEY", "<client> <channel> :Cannot join channel (+k)"},
+	ERR_BADCHANMASK:       {"ERR_BADCHANMASK", "<channel> :Bad Channel Mask"},
+	ERR_NOCHANMODES:       {"ERR_NOCHANMODES", "<client> <channel> :Channel doesn't support modes"},
+	ERR_BANLISTFULL:       {"ERR_BANLISTFULL", "<client> <channel> <char> :Channel list is full"},
+	ERR_NOPRIVILEGES:      {"ERR_NOPRIVILEGES", "<client> :Permission Denied- You're not an IRC operator"},
+	ERR_CHANOPRIVSNEEDED:  {"ERR_CHANOPRIVSNEEDED", "<client> <channel> :You're not channel operator"},
+	ERR_CANTKILLSERVER:    {"ERR_CANTKILLSERVER", "<client> :You cant kill a server!"},
+	ERR_RESTRICTED:        {"ERR_RESTRICTED", "<client> :Your connection is restricted!"},
+	ERR_UNIQOPPRIVSNEEDED: {"ERR_UNIQOPPRIVSNEEDED", "<client> :You're not the original channel operator"},
+	ERR_NOOPERHOST:        {"ERR_NOOPERHOST", "<client> :No O-lines for your host"},
+	ERR_UMODEUNKNOWNFLAG:  {"ERR_UMODEUNKNOWNFLAG", "<client> :Unknown MODE flag"},
+	ERR_USERSDONTMATCH:    {"ERR_USERSDONTMATCH", "<client> :Cant change mode for other users"},
+	ERR_HELPNOTFOUND:      {"ERR_HELPNOTFOUND", "<client> <subject> :No help available on this topic"},
+	ERR_INVALIDKEY:        {"ERR_INVALIDKEY", "<client> <target chan> :Key is not well-formed"},
+	RPL_STARTTLS:          {"RPL_STARTTLS", "<client> :STARTTLS successful, proceed with TLS handshake"},
+	RPL_WHOISSECURE:       {"RPL_WHOISSECURE", "<client> <nick> :is using a secure connection"},
+	ERR_STARTTLS:          {"ERR_STARTTLS", "<client> :STARTTLS failed (Wrong moon phase)"},
+	ERR_INVALIDMODEPARAM:  {"ERR_INVALIDMODEPARAM", "<client> <target chan/user> <mode char> <parameter> :<description>"},
+	RPL_HELPSTART:         {"RPL_HELPSTART", "<client> <subject> :<first line of help section>"},
+	RPL_HELPTXT:           {"RPL_HELPTXT", "<client> <subject> :<line of help text>"},
+	RPL_ENDOFHELP:         {"RPL_ENDOFHELP", "<client> <subject> :<last line of help text>"},
+	ERR_NOPRIVS:           {"ERR_NOPRIVS", "<client> <priv> :Insufficient oper privileges."},
+	RPL_MONONLINE:         {"RPL_MONONLINE", "<client> :target[!user@host][,target[!user@host]]*"},
+	RPL_MONOFFLINE:        {"RPL_MONOFFLINE", "<client> :target[,target2]*"},
+	RPL_MONLIST:           {"RPL_MONLIST", "<client> :target[,target2]*"},
+	RPL_ENDOFMONLIST:      {"RPL_ENDOFMONLIST", "<client> :End of MONITOR list"},
+	ERR_MONLISTFULL:       {"ERR_MONLISTFULL", "<client> <limit> <targets> :Monitor list is full."},
+	RPL_LOGGEDIN:          {"RPL_LOGGEDIN", "<client> <nick>!<user>@<host> <account> :You are now logged in as <username>"},
+	RPL_LOGGEDOUT:         {"RPL_LOGGEDOUT", "<client> <nick>!<user>@<host> :You are now logged out"},
+	ERR_NICKLOCKED:        {"ERR_NICKLOCKED", "<client> :You must use a nick assigned to you"},
+	RPL_SASLSUCCESS:       {"RPL_SASLSUCCESS", "<client> :SASL authentication successful"},
+	ERR_SASLFAIL:          {"ERR_SASLFAIL", "<client> :SASL authentication failed"},
+	ERR_SASLTOOLONG:       {"ERR_SASLTOOLONG", "<client> :SASL message too long"},
+	ERR_SASLABORTED:       {"ERR_SASLABORTED", "<client> :SASL authentication aborted"},
+	ERR_SASLALREADY:       {"ERR_SASLALREADY", "<client> :You have already authenticated using SASL"},
+	RPL_SASLMECHS:         {"RPL_SASLMECHS", "<client> <mechanisms> :are available SASL mechanisms"},
+}
+
+// LookupNumeric returns the symbolic name and typical argument layout of a
+// numeric reply code. ok is false for codes not in the catalogue.
+func LookupNumeric(code string) (info NumericInfo, ok bool) {
+	info, ok = numerics[code]
+	return info, ok
+}
+
+// NumericName returns the symbolic name of a numeric reply code, e.g.
+// NumericName("433") == "ERR_NICKNAMEINUSE", or an empty string when the
+// code is unknown.
+func NumericName(code string) string {
+	return numerics[code].Name
+}
+
+// eventCodeName formats an event code for logging: numerics get their
+// symbolic name appended ("433 ERR_NICKNAMEINUSE"), other codes are
+// returned unchanged.
+func eventCodeName(code string) string {
+	if name := NumericName(code); name != "" {
+		return code + " " + name
+	}
+	return code
+}
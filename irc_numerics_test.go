@@ -0,0 +1,60 @@
+package irc
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestNumericCatalogue(t *testing.T) {
+	seen := make(map[string]string)
+	for code, info := range numerics {
+		if len(code) != 3 || strings.Trim(code, "0123456789") != "" {
+			t.Errorf("invalid numeric code %q", code)
+		}
+		if !strings.HasPrefix(info.Name, "RPL_") && !strings.HasPrefix(info.Name, "ERR_") {
+			t.Errorf("%s: unexpected name %q", code, info.Name)
+		}
+		if info.Format == "" {
+			t.Errorf("%s: missing format", code)
+		}
+		if other, ok := seen[info.Name]; ok {
+			t.Errorf("%s and %s share the name %s", code, other, info.Name)
+		}
+		seen[info.Name] = code
+	}
+
+	constants := map[string]string{
+		RPL_WELCOME:       "RPL_WELCOME",
+		RPL_ISUPPORT:      "RPL_ISUPPORT",
+		RPL_ENDOFMOTD:     "RPL_ENDOFMOTD",
+		ERR_NICKNAMEINUSE: "ERR_NICKNAMEINUSE",
+		RPL_LOGGEDOUT:     "RPL_LOGGEDOUT",
+		RPL_SASLSUCCESS:   "RPL_SASLSUCCESS",
+		RPL_MONONLINE:     "RPL_MONONLINE",
+	}
+	for code, name := range constants {
+		if got := NumericName(code); got != name {
+			t.Errorf("NumericName(%q) = %q, want %q", code, got, name)
+		}
+	}
+	if info, ok := LookupNumeric(ERR_NICKNAMEINUSE); !ok || !strings.Contains(info.Format, "<nick>") {
+		t.Errorf("unexpected LookupNumeric result %+v, %v", info, ok)
+	}
+	if _, ok := LookupNumeric("999"); ok || NumericName("PRIVMSG") != "" {
+		t.Error("expected unknown codes to have no entry")
+	}
+}
+
+func TestVerboseLoggingShowsNumericName(t *testing.T) {
+	var buf bytes.Buffer
+	irccon := IRC("me", "testuser")
+	irccon.Log = log.New(&buf, "", 0)
+	irccon.VerboseCallbackHandler = true
+
+	irccon.RunCallbacks(&Event{Code: ERR_NOMOTD, Arguments: []string{"me", "MOTD File is missing"}})
+	if !strings.Contains(buf.String(), "422 ERR_NOMOTD (") {
+		t.Fatalf("expected symbolic name in verbose log, got %q", buf.String())
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// EventCallbackPanic is run after a callback panicked. Its Arguments are
+// the code of the event being handled, the name of the callback and the
+// panic value; Raw is the raw line of the event. A panic in a callback of
+// EventCallbackPanic itself is logged but does not run it again.
+//
+// A panic in one of the library's own handlers leaves the connection state
+// in doubt, so the connection is then failed: the CallbackPanic is sent to
+// the Error channel, as for a read error, and Loop reconnects.
+const EventCallbackPanic = "CALLBACK_PANIC"
+
+// CallbackPanic describes a panic recovered from a callback.
+type CallbackPanic struct {
+	Code     string      // event code the callback was running for
+	ID       int         // callback ID as returned by AddCallback
+	Callback string      // function name of the callback
+	Value    interface{} // value passed to panic
+	Stack    []byte      // stack trace of the panicking goroutine
+	Event    *Event      // copy of the event being handled
+	Disabled bool        // the callback was removed, see MaxCallbackPanics
+}
+
+func (p *CallbackPanic) Error() string {
+	return fmt.Sprintf("callback %s (id %d) panicked on %s: %v", p.Callback, p.ID, p.Code, p.Value)
+}
+
+// invokeCallback runs one callback, recovering a panic in it so that it
+// cannot take down the whole process.
+func (irc *Connection) invokeCallback(c callbackEntry, event *Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			irc.callbackPanicked(c, event, r, debug.Stack())
+		}
+	}()
+	c.cb(event)
+}
+
+// callbackPanicked logs a recovered panic, removes the callback when it
+// reached MaxCallbackPanics and reports the panic to the handler and to
+// the EventCallbackPanic callbacks.
+func (irc *Connection) callbackPanicked(c callbackEntry, event *Event, value interface{}, stack []byte) {
+	p := &CallbackPanic{
+		Code:     event.Code,
+		ID:       c.id,
+		Callback: getFunctionName(c.cb),
+		Value:    value,
+		Stack:    stack,
+		Event:    event.Clone(),
+	}
+	irc.Log.Printf("Recovered panic in callback %s for %s: %v\n%s>> %#v\n", p.Callback, p.Code, value, stack, p.Event)
+
+	if irc.MaxCallbackPanics > 0 && !c.internal && irc.countCallbackPanic(c.id) {
+		p.Disabled = irc.RemoveCallback(c.code, c.id)
+		if p.Disabled {
+			irc.Log.Printf("Removed callback %s (id %d) after %d panics\n", p.Callback, c.id, irc.MaxCallbackPanics)
+		}
+	}
+
+	if handler := irc.CallbackPanicHandler; handler != nil {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					irc.Log.Printf("Recovered panic in CallbackPanicHandler: %v\n%s", r, debug.Stack())
+				}
+			}()
+			handler(p)
+		}()
+	}
+
+	if event.Code != EventCallbackPanic {
+		irc.RunCallbacks(&Event{
+			Code:       EventCallbackPanic,
+			Raw:        p.Event.Raw,
+			Arguments:  []string{p.Code, p.Callback, fmt.Sprint(value)},
+			Connection: irc,
+		})
+	}
+
+	if c.internal {
+		irc.failConnection(p)
+	}
+}
+
+// failConnection reports err on the Error channel and closes the socket,
+// so that the read loop stops and Loop reconnects.
+func (irc *Connection) failConnection(err error) {
+	irc.Log.Printf("Failing connection: %s\n", err)
+	select {
+	case irc.ErrorChan() <- err:
+	default:
+		// Full: the connection is already failing
+	}
+	irc.closeSocket()
+}
+
+// countCallbackPanic records a panic of callback id and reports whether it
+// reached MaxCallbackPanics.
+func (irc *Connection) countCallbackPanic(id int) bool {
+	irc.eventsMutex.Lock()
+	defer irc.eventsMutex.Unlock()
+
+	if irc.callbackPanics == nil {
+		irc.callbackPanics = make(map[int]int)
+	}
+	irc.callbackPanics[id]++
+	return irc.callbackPanics[id] >= irc.MaxCallbackPanics
+}
@@ -0,0 +1,214 @@
+package irc
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCallbackPanicRecovered(t *testing.T) {
+	for _, mode := range []string{"default", "no-timeout", "sync", "dispatcher"} {
+		t.Run(mode, func(t *testing.T) {
+			irccon := IRC("me", "testuser")
+			var opts CallbackOptions
+			switch mode {
+			case "no-timeout":
+				irccon.CallbackTimeout = 0
+			case "sync":
+				opts.Sync = true
+			case "dispatcher":
+				irccon.DispatchWorkers = 2
+				irccon.PoolEvents = true
+			}
+
+			panics := make(chan *CallbackPanic, 1)
+			irccon.CallbackPanicHandler = func(p *CallbackPanic) { panics <- p }
+			events := make(chan *Event, 1)
+			irccon.AddCallback(EventCallbackPanic, func(e *Event) { events <- e })
+			id := irccon.AddCallbackWithOptions("PRIVMSG", func(*Event) { panic("boom") }, opts)
+			done := make(chan string, 1)
+			irccon.AddCallback("PRIVMSG", func(e *Event) { done <- e.Message() })
+
+			server := startReadLoop(t, irccon)
+			go server.Write([]byte(":nick!u@h PRIVMSG #go :hello\r\n"))
+
+			select {
+			case p := <-panics:
+				if p.ID != id || p.Code != "PRIVMSG" || p.Value != "boom" || p.Disabled {
+					t.Fatalf("unexpected panic report %+v", p)
+				}
+				if p.Event.Message() != "hello" || !strings.Contains(string(p.Stack), "irc_panic_test.go") {
+					t.Fatalf("panic report misses event or stack: %q\n%s", p.Event.Raw, p.Stack)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("panic was not reported")
+			}
+			select {
+			case e := <-events:
+				if len(e.Arguments) != 3 || e.Arguments[0] != "PRIVMSG" || e.Arguments[2] != "boom" {
+					t.Fatalf("unexpected %s arguments %q", EventCallbackPanic, e.Arguments)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%s was not run", EventCallbackPanic)
+			}
+			select {
+			case got := <-done:
+				if got != "hello" {
+					t.Fatalf("other callback got %q", got)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("other callbacks did not run")
+			}
+		})
+	}
+}
+
+func TestCallbackPanicDisablesAfterMax(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.MaxCallbackPanics = 2
+
+	var mu sync.Mutex
+	var reports []*CallbackPanic
+	irccon.CallbackPanicHandler = func(p *CallbackPanic) {
+		mu.Lock()
+		reports = append(reports, p)
+		mu.Unlock()
+	}
+	calls := 0
+	irccon.AddCallbackWithOptions("FOO", func(*Event) {
+		calls++
+		panic("always")
+	}, CallbackOptions{Sync: true})
+
+	for i := 0; i < 4; i++ {
+		feedLines(t, irccon, ":srv FOO me")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 || len(reports) != 2 {
+		t.Fatalf("callback ran %d times with %d reports, want 2 and 2", calls, len(reports))
+	}
+	if reports[0].Disabled || !reports[1].Disabled {
+		t.Fatalf("want only the second report disabled, got %v and %v", reports[0].Disabled, reports[1].Disabled)
+	}
+	if len(irccon.events["FOO"]) != 0 || len(irccon.callbackPanics) != 0 {
+		t.Fatalf("callback not removed: %v, counts %v", irccon.events["FOO"], irccon.callbackPanics)
+	}
+}
+
+func TestCallbackPanicInPanicCallbackDoesNotRecurse(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.CallbackTimeout = 0
+
+	var mu sync.Mutex
+	codes := []string{}
+	irccon.CallbackPanicHandler = func(p *CallbackPanic) {
+		mu.Lock()
+		codes = append(codes, p.Code)
+		mu.Unlock()
+		panic("handler too")
+	}
+	irccon.AddCallback("*", func(*Event) { panic("everywhere") })
+
+	feedLines(t, irccon, ":srv FOO me")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(codes) != 2 || codes[0] != "FOO" || codes[1] != EventCallbackPanic {
+		t.Fatalf("unexpected panic reports %q", codes)
+	}
+}
+
+func TestMalformedWelcomeDoesNotPanic(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	panics := make(chan *CallbackPanic, 1)
+	irccon.CallbackPanicHandler = func(p *CallbackPanic) { panics <- p }
+
+	feedLines(t, irccon, ":server 001")
+
+	select {
+	case p := <-panics:
+		t.Fatalf("malformed 001 panicked: %v", p)
+	default:
+	}
+	if !irccon.IsFullyConnected() || irccon.GetNick() != "me" {
+		t.Fatalf("after 001: fully connected %v, nick %q", irccon.IsFullyConnected(), irccon.GetNick())
+	}
+}
+
+func TestInternalCallbackPanicFailsConnection(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	events := make(chan *Event, 1)
+	irccon.AddCallback(EventCallbackPanic, func(e *Event) { events <- e })
+	irccon.addInternalCallback("FOO", func(*Event) {
+		irccon.Lock()
+		defer irccon.Unlock()
+		panic("internal boom")
+	})
+
+	server := startReadLoop(t, irccon)
+	go server.Write([]byte(":server FOO\r\n"))
+
+	select {
+	case e := <-events:
+		if len(e.Arguments) != 3 || e.Arguments[0] != "FOO" || e.Arguments[2] != "internal boom" {
+			t.Fatalf("unexpected %s arguments %q", EventCallbackPanic, e.Arguments)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s was not run", EventCallbackPanic)
+	}
+	select {
+	case err := <-irccon.ErrorChan():
+		if p, ok := err.(*CallbackPanic); !ok || p.Code != "FOO" {
+			t.Fatalf("Error channel got %v, want the CallbackPanic", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection was not failed")
+	}
+
+	// The handler's lock was released while unwinding
+	locked := make(chan struct{})
+	go func() {
+		irccon.GetNick()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection lock still held after the panic")
+	}
+}
+
+func TestReplacedBuiltinCTCPHandlerPanicKeepsConnection(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+	events := make(chan *Event, 1)
+	irccon.AddCallback(EventCallbackPanic, func(e *Event) { events <- e })
+	irccon.HandleCTCP("VERSION", func(*Event, string) (string, bool) { panic("user boom") })
+
+	server := startReadLoop(t, irccon)
+	go server.Write([]byte(":nick!u@h PRIVMSG me :\x01VERSION\x01\r\n"))
+
+	select {
+	case e := <-events:
+		if e.Arguments[2] != "user boom" {
+			t.Fatalf("unexpected %s arguments %q", EventCallbackPanic, e.Arguments)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s was not run", EventCallbackPanic)
+	}
+
+	go server.Write([]byte(":srv PING :still-up\r\n"))
+	select {
+	case line := <-irccon.pwrite:
+		if line != "PONG :still-up\r\n" {
+			t.Fatalf("sent %q, want the PONG", line)
+		}
+	case err := <-irccon.ErrorChan():
+		t.Fatalf("a user CTCP handler panic failed the connection: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no PONG after the panic")
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"strings"
+	"sync"
+)
+
+var errMalformedMsg = errors.New("malformed msg from server")
+
+// parseEventLine parses a line read from the server into e. The line is
+// converted to a string once, for Raw; Code, Source, Nick, User, Host and
+// Arguments are substrings of it and Arguments reuses e's backing array, so
+// a pooled event usually costs a single allocation per line. Tags are only
+// decoded into e.Tags when decodeTags is set.
+func parseEventLine(e *Event, line []byte, decodeTags bool) error {
+	e.Raw = string(bytes.TrimRight(line, "\r\n"))
+	_, err := e.parseRaw(decodeTags)
+	return err
+}
+
+// parseRaw parses e.Raw into the other fields of e and reports whether the
+// last argument was a trailing parameter.
+func (e *Event) parseRaw(decodeTags bool) (trailing bool, err error) {
+	rest := e.Raw
+
+	if strings.HasPrefix(rest, "@") {
+		i := strings.IndexByte(rest, ' ')
+		if i < 0 {
+			return false, errMalformedMsg
+		}
+		e.rawTags = rest[1:i]
+		if decodeTags {
+			e.Tags = parseTags(e.rawTags)
+		}
+		rest = strings.TrimLeft(rest[i+1:], " ")
+	}
+
+	if strings.HasPrefix(rest, ":") {
+		i := strings.IndexByte(rest, ' ')
+		if i <= 1 {
+			return false, errMalformedMsg
+		}
+		e.Source = rest[1:i]
+		if !isServerSource(e.Source) {
+			e.Nick, e.User, e.Host = splitUserhost(e.Source)
+		}
+		rest = strings.TrimLeft(rest[i+1:], " ")
+	}
+
+	command, rest, _ := strings.Cut(rest, " ")
+	if command == "" || command[0] == ':' || command[0] == '@' {
+		return false, errMalformedMsg
+	}
+	e.Code = strings.ToUpper(command)
+
+	args := e.Arguments[:0]
+	for {
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "" {
+			break
+		}
+		if rest[0] == ':' {
+			args = append(args, rest[1:])
+			trailing = true
+			break
+		}
+		var arg string
+		arg, rest, _ = strings.Cut(rest, " ")
+		args = append(args, arg)
+	}
+	if args == nil {
+		args = []string{}
+	}
+	e.Arguments = args
+	return trailing, nil
+}
+
+// Tag returns the value of the IRCv3 message tag key and whether the line
+// carried it. Unlike indexing Tags it also works with Connection.LazyTags,
+// decoding only the requested tag.
+func (e *Event) Tag(key string) (string, bool) {
+	if e.Tags != nil || e.rawTags == "" {
+		value, ok := e.Tags[key]
+		return value, ok
+	}
+	value, found := "", false
+	for raw := e.rawTags; raw != ""; {
+		var tag string
+		tag, raw, _ = strings.Cut(raw, ";")
+		if k, v, _ := strings.Cut(tag, "="); k == key {
+			value, found = v, true // the last occurrence wins
+		}
+	}
+	if !found {
+		return "", false
+	}
+	return unescapeTagValue(value), true
+}
+
+// TagMap returns all message tags. With Connection.LazyTags it decodes them
+// into a new map on every call; use Tag to look up a single tag.
+func (e *Event) TagMap() map[string]string {
+	if e.Tags != nil || e.rawTags == "" {
+		return e.Tags
+	}
+	return parseTags(e.rawTags)
+}
+
+// Clone returns a copy of e that does not share Arguments or Tags with it,
+// for keeping events beyond their callback when Connection.PoolEvents is
+// enabled.
+func (e *Event) Clone() *Event {
+	c := *e
+	c.Arguments = append(make([]string, 0, len(e.Arguments)), e.Arguments...)
+	c.Tags = maps.Clone(e.Tags)
+	return &c
+}
+
+// maxPooledArgs caps the Arguments capacity kept by pooled events so that a
+// single huge line does not pin its array forever.
+const maxPooledArgs = 32
+
+var eventPool = sync.Pool{
+	New: func() any { return new(Event) },
+}
+
+func getEvent() *Event {
+	return eventPool.Get().(*Event)
+}
+
+// releaseEvent clears e and returns it to the pool. The caller must be the
+// only one still referring to it.
+func releaseEvent(e *Event) {
+	clear(e.Arguments)
+	args := e.Arguments[:0]
+	if cap(args) > maxPooledArgs {
+		args = nil
+	}
+	*e = Event{Arguments: args}
+	eventPool.Put(e)
+}
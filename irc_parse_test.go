@@ -1,7 +1,15 @@
 package irc
 
 import (
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"golang.org/x/text/encoding"
 )
 
 func checkResult(t *testing.T, event *Event) {
@@ -44,3 +52,408 @@ func TestParseTags(t *testing.T) {
 		t.Fatal("Parsing tag failed")
 	}
 }
+
+// msgSplitVectors are the msg-split cases of the IRCv3 parser test suite
+// (github.com/ircdocs/parser-tests), plus a few lines seen in the wild.
+var msgSplitVectors = []struct {
+	input  string
+	tags   map[string]string
+	source string
+	verb   string
+	params []string
+}{
+	// simple
+	{input: "foo bar baz asdf", verb: "foo", params: []string{"bar", "baz", "asdf"}},
+	{input: ":coolguy foo bar baz asdf", source: "coolguy", verb: "foo", params: []string{"bar", "baz", "asdf"}},
+
+	// with trailing param
+	{input: "foo bar baz :asdf quux", verb: "foo", params: []string{"bar", "baz", "asdf quux"}},
+	{input: "foo bar baz :", verb: "foo", params: []string{"bar", "baz", ""}},
+	{input: "foo bar baz ::asdf", verb: "foo", params: []string{"bar", "baz", ":asdf"}},
+	{input: ":coolguy foo bar baz :asdf quux", source: "coolguy", verb: "foo", params: []string{"bar", "baz", "asdf quux"}},
+	{input: ":coolguy foo bar baz :  asdf quux ", source: "coolguy", verb: "foo", params: []string{"bar", "baz", "  asdf quux "}},
+	{input: ":coolguy PRIVMSG bar :lol :) ", source: "coolguy", verb: "PRIVMSG", params: []string{"bar", "lol :) "}},
+	{input: ":coolguy foo bar baz :", source: "coolguy", verb: "foo", params: []string{"bar", "baz", ""}},
+	{input: ":coolguy foo bar baz :  ", source: "coolguy", verb: "foo", params: []string{"bar", "baz", "  "}},
+
+	// with tags
+	{input: "@a=b;c=32;k;rt=ql7 foo", tags: map[string]string{"a": "b", "c": "32", "k": "", "rt": "ql7"}, verb: "foo"},
+
+	// with escaped tags
+	{input: `@a=b\\and\nk;c=72\s45;d=gh\:764 foo`, tags: map[string]string{"a": "b\\and\nk", "c": "72 45", "d": "gh;764"}, verb: "foo"},
+
+	// with tags and source
+	{input: "@c;h=;a=b :quux ab cd", tags: map[string]string{"c": "", "h": "", "a": "b"}, source: "quux", verb: "ab", params: []string{"cd"}},
+
+	// different forms of last param
+	{input: ":src JOIN #chan", source: "src", verb: "JOIN", params: []string{"#chan"}},
+	{input: ":src JOIN :#chan", source: "src", verb: "JOIN", params: []string{"#chan"}},
+
+	// with and without last param
+	{input: ":src AWAY", source: "src", verb: "AWAY"},
+	{input: ":src AWAY ", source: "src", verb: "AWAY"},
+
+	// tab is not considered <SPACE>
+	{input: ":cool\tguy foo bar baz", source: "cool\tguy", verb: "foo", params: []string{"bar", "baz"}},
+
+	// with weird control codes in the source
+	{input: ":coolguy!ag@net\x035w\x03ork.admin PRIVMSG foo :bar baz", source: "coolguy!ag@net\x035w\x03ork.admin", verb: "PRIVMSG", params: []string{"foo", "bar baz"}},
+	{input: ":coolguy!~ag@n\x02et\x0305w\x0fork.admin PRIVMSG foo :bar baz", source: "coolguy!~ag@n\x02et\x0305w\x0fork.admin", verb: "PRIVMSG", params: []string{"foo", "bar baz"}},
+
+	{input: "@tag1=value1;tag2;vendor1/tag3=value2;vendor2/tag4= :irc.example.com COMMAND param1 param2 :param3 param3",
+		tags:   map[string]string{"tag1": "value1", "tag2": "", "vendor1/tag3": "value2", "vendor2/tag4": ""},
+		source: "irc.example.com", verb: "COMMAND", params: []string{"param1", "param2", "param3 param3"}},
+	{input: ":irc.example.com COMMAND param1 param2 :param3 param3", source: "irc.example.com", verb: "COMMAND", params: []string{"param1", "param2", "param3 param3"}},
+	{input: "@tag1=value1;tag2;vendor1/tag3=value2;vendor2/tag4 COMMAND param1 param2 :param3 param3",
+		tags: map[string]string{"tag1": "value1", "tag2": "", "vendor1/tag3": "value2", "vendor2/tag4": ""},
+		verb: "COMMAND", params: []string{"param1", "param2", "param3 param3"}},
+	{input: "COMMAND", verb: "COMMAND"},
+
+	// yaml encoding + slashes is fun
+	{input: `@foo=\\\\\:\\s\s\r\n COMMAND`, tags: map[string]string{"foo": "\\\\;\\s \r\n"}, verb: "COMMAND"},
+
+	// broken messages from unreal
+	{input: ":gravel.mozilla.org 432  #momo :Erroneous Nickname: Illegal characters", source: "gravel.mozilla.org", verb: "432", params: []string{"#momo", "Erroneous Nickname: Illegal characters"}},
+	{input: ":gravel.mozilla.org MODE #tckk +n ", source: "gravel.mozilla.org", verb: "MODE", params: []string{"#tckk", "+n"}},
+	{input: ":services.esper.net MODE #foo-bar +o foobar  ", source: "services.esper.net", verb: "MODE", params: []string{"#foo-bar", "+o", "foobar"}},
+
+	// tag values should be parsed char-at-a-time to prevent wayward replacements
+	{input: `@tag1=value\\ntest COMMAND`, tags: map[string]string{"tag1": `value\ntest`}, verb: "COMMAND"},
+
+	// If a tag value has a slash followed by a character which doesn't need
+	// to be escaped, the slash should be dropped.
+	{input: `@tag1=value\1 COMMAND`, tags: map[string]string{"tag1": "value1"}, verb: "COMMAND"},
+
+	// A slash at the end of a tag value should be dropped
+	{input: `@tag1=value1\ COMMAND`, tags: map[string]string{"tag1": "value1"}, verb: "COMMAND"},
+
+	// Duplicate tags: Parsers SHOULD disregard all but the final occurrence
+	{input: "@tag1=1;tag2=3;tag3=4;tag1=5 COMMAND", tags: map[string]string{"tag1": "5", "tag2": "3", "tag3": "4"}, verb: "COMMAND"},
+
+	// vendored tags can have the same name as a non-vendored tag
+	{input: "@tag1=1;tag2=3;tag3=4;tag1=5;vendor/tag2=8 COMMAND", tags: map[string]string{"tag1": "5", "tag2": "3", "tag3": "4", "vendor/tag2": "8"}, verb: "COMMAND"},
+
+	// some parsers handle /MODE in a special way, make sure they do it right
+	{input: ":SomeOp MODE #channel :+i", source: "SomeOp", verb: "MODE", params: []string{"#channel", "+i"}},
+	{input: ":SomeOp MODE #channel +oo SomeUser :AnotherUser", source: "SomeOp", verb: "MODE", params: []string{"#channel", "+oo", "SomeUser", "AnotherUser"}},
+
+	// " :" inside a middle parameter after tags must not start the trailing parameter
+	{input: "@a=b :nick!u@h PRIVMSG #chan:x :hello", tags: map[string]string{"a": "b"}, source: "nick!u@h", verb: "PRIVMSG", params: []string{"#chan:x", "hello"}},
+	{input: "@a=x\\s:y :nick!u@h PRIVMSG #chan :hello", tags: map[string]string{"a": "x :y"}, source: "nick!u@h", verb: "PRIVMSG", params: []string{"#chan", "hello"}},
+}
+
+func TestParseMsgSplitVectors(t *testing.T) {
+	for _, v := range msgSplitVectors {
+		m, err := ParseMessage(v.input)
+		if err != nil {
+			t.Errorf("ParseMessage(%q) failed: %v", v.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(m.Tags, v.tags) && (len(m.Tags) != 0 || len(v.tags) != 0) {
+			t.Errorf("ParseMessage(%q) tags = %q, want %q", v.input, m.Tags, v.tags)
+		}
+		if m.Source != v.source {
+			t.Errorf("ParseMessage(%q) source = %q, want %q", v.input, m.Source, v.source)
+		}
+		if m.Command != strings.ToUpper(v.verb) {
+			t.Errorf("ParseMessage(%q) command = %q, want %q", v.input, m.Command, strings.ToUpper(v.verb))
+		}
+		if !reflect.DeepEqual(m.Params, v.params) && (len(m.Params) != 0 || len(v.params) != 0) {
+			t.Errorf("ParseMessage(%q) params = %q, want %q", v.input, m.Params, v.params)
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, line := range []string{"", "\r\n", "@a=b", "@a=b ", ":src", ":src ", "@a=b :src", "@a=b :src  ", ": :", ": PING", "@ @x", ":src :x"} {
+		if _, err := parseToEvent(line); err == nil {
+			t.Errorf("parseToEvent(%q) succeeded, want error", line)
+		}
+	}
+}
+
+// userhostSplitVectors are the userhost-split cases of the IRCv3 parser
+// test suite.
+var userhostSplitVectors = []struct {
+	source, nick, user, host string
+}{
+	{"coolguy", "coolguy", "", ""},
+	{"coolguy!ag@127.0.0.1", "coolguy", "ag", "127.0.0.1"},
+	{"coolguy!~ag@localhost", "coolguy", "~ag", "localhost"},
+
+	// without user
+	{"coolguy@127.0.0.1", "coolguy", "", "127.0.0.1"},
+
+	// without host
+	{"coolguy!ag", "coolguy", "ag", ""},
+
+	// with weird control codes
+	{"coolguy!ag@net\x035w\x03ork.admin", "coolguy", "ag", "net\x035w\x03ork.admin"},
+	{"coolguy!~ag@n\x02et\x0305w\x0fork.admin", "coolguy", "~ag", "n\x02et\x0305w\x0fork.admin"},
+}
+
+func TestParseUserhostSplitVectors(t *testing.T) {
+	for _, v := range userhostSplitVectors {
+		event, err := parseToEvent(":" + v.source + " PRIVMSG #chan :hi")
+		if err != nil {
+			t.Fatalf("parseToEvent with source %q failed: %v", v.source, err)
+		}
+		if event.Nick != v.nick || event.User != v.user || event.Host != v.host {
+			t.Errorf("source %q split into %q/%q/%q, want %q/%q/%q",
+				v.source, event.Nick, event.User, event.Host, v.nick, v.user, v.host)
+		}
+	}
+
+	event, err := parseToEvent(":irc.example.net NOTICE * :hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Nick != "" || event.Source != "irc.example.net" {
+		t.Errorf("server source parsed as nick %q, source %q", event.Nick, event.Source)
+	}
+}
+
+// FuzzParseMessage checks that parsing never panics, that anything parsed
+// and valid for sending serializes back to a line that parses identically,
+// that lazily decoded tags match the eager ones, and that events built
+// from it survive the typed accessors. Seeds come from the vectors above and
+// testdata/fuzz/FuzzParseMessage.
+func FuzzParseMessage(f *testing.F) {
+	for _, v := range msgSplitVectors {
+		f.Add(v.input)
+	}
+	for _, v := range userhostSplitVectors {
+		f.Add(":" + v.source + " PRIVMSG #chan :hi")
+	}
+
+	irccon := IRC("fuzz", "fuzz")
+	f.Fuzz(func(t *testing.T, line string) {
+		m, err := ParseMessage(line)
+		if err != nil {
+			return
+		}
+
+		if text, err := m.MarshalText(); err == nil {
+			again, err := ParseMessage(string(text))
+			if err != nil {
+				t.Fatalf("reparsing %q (from %q) failed: %v", text, line, err)
+			}
+			if again.Command != m.Command || again.Source != m.Source ||
+				!reflect.DeepEqual(again.Params, m.Params) ||
+				(len(m.Tags) > 0 || len(again.Tags) > 0) && !reflect.DeepEqual(again.Tags, m.Tags) {
+				t.Fatalf("round trip of %q changed the message:\n got %#v\nwant %#v", line, again, m)
+			}
+		}
+
+		event, err := parseToEvent(line)
+		if err != nil {
+			t.Fatalf("parseToEvent(%q) failed after ParseMessage succeeded: %v", line, err)
+		}
+		lazy := getEvent()
+		if err := parseEventLine(lazy, []byte(line), false); err != nil {
+			t.Fatalf("parseEventLine(%q) failed: %v", line, err)
+		}
+		for key, value := range m.Tags {
+			if got, ok := lazy.Tag(key); !ok || got != value {
+				t.Fatalf("lazy Tag(%q) of %q = %q, %v; want %q", key, line, got, ok, value)
+			}
+		}
+		releaseEvent(lazy)
+
+		event.Connection = irccon
+		event.Message()
+		event.Target()
+		event.ReplyTarget()
+		event.CTCP()
+		event.StandardReply()
+		event.AsKick()
+		event.AsMode()
+		event.AsTopic()
+		event.AsInvite()
+		event.AsNick()
+	})
+}
+
+// startReadLoop runs readLoop on one end of a pipe and returns the other.
+func startReadLoop(t *testing.T, irccon *Connection) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	irccon.socket = client
+	irccon.Encoding = encoding.Nop
+	irccon.end = make(chan struct{})
+	irccon.Error = make(chan error, 10)
+	irccon.Add(1)
+	go irccon.readLoop()
+	t.Cleanup(func() {
+		server.Close()
+		irccon.Wait()
+		client.Close()
+	})
+	return server
+}
+
+func TestReadLoopPooledLazyEvents(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.PoolEvents = true
+	irccon.LazyTags = true
+
+	kept := make(chan *Event, 2)
+	irccon.AddCallback("PRIVMSG", func(e *Event) {
+		if e.Tags != nil {
+			t.Errorf("LazyTags event has decoded Tags %v", e.Tags)
+		}
+		kept <- e.Clone()
+	})
+	server := startReadLoop(t, irccon)
+
+	long := strings.Repeat("x", 2000)
+	lines := "@msgid=abc;+draft/reply=a\\sb :nick!u@h PRIVMSG #go :hello\r\n" +
+		"@+long=" + long + " :nick!u@h PRIVMSG #go :" + long + "\r\n"
+	go server.Write([]byte(lines))
+
+	first, second := <-kept, <-kept
+	if first.MsgID() != "abc" || first.Message() != "hello" || first.Nick != "nick" {
+		t.Fatalf("unexpected first event %#v", first)
+	}
+	if reply, ok := first.Tag("+draft/reply"); !ok || reply != "a b" {
+		t.Fatalf("Tag(+draft/reply) = %q, %v", reply, ok)
+	}
+	if got := first.TagMap(); !reflect.DeepEqual(got, map[string]string{"msgid": "abc", "+draft/reply": "a b"}) {
+		t.Fatalf("TagMap() = %v", got)
+	}
+	if second.Message() != long {
+		t.Fatalf("long line truncated to %d bytes", len(second.Message()))
+	}
+	if tag, _ := second.Tag("+long"); tag != long {
+		t.Fatalf("long tag truncated to %d bytes", len(tag))
+	}
+}
+
+func TestEventCloneAndRelease(t *testing.T) {
+	e := getEvent()
+	if err := parseEventLine(e, []byte("@a=1 :n!u@h PRIVMSG #go :hi\r\n"), true); err != nil {
+		t.Fatal(err)
+	}
+	c := e.Clone()
+	releaseEvent(e)
+
+	if c.Code != "PRIVMSG" || !reflect.DeepEqual(c.Arguments, []string{"#go", "hi"}) || c.Tags["a"] != "1" {
+		t.Fatalf("clone changed after release: %#v", c)
+	}
+	if e.Code != "" || len(e.Arguments) != 0 || e.Tags != nil || e.rawTags != "" {
+		t.Fatalf("released event not cleared: %#v", e)
+	}
+}
+
+func TestCallbackSnapshotInvalidation(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	var calls []string
+	var mu sync.Mutex
+	record := func(name string) func(*Event) {
+		return func(*Event) {
+			mu.Lock()
+			calls = append(calls, name)
+			mu.Unlock()
+		}
+	}
+	run := func() []string {
+		mu.Lock()
+		calls = nil
+		mu.Unlock()
+		irccon.RunCallbacks(parseTestEvent(t, irccon, ":srv FOO me"))
+		mu.Lock()
+		defer mu.Unlock()
+		sort.Strings(calls)
+		return calls
+	}
+
+	id := irccon.AddCallback("FOO", record("foo"))
+	if got := run(); !reflect.DeepEqual(got, []string{"foo"}) {
+		t.Fatalf("calls = %v", got)
+	}
+	irccon.AddCallback("*", record("all"))
+	if got := run(); !reflect.DeepEqual(got, []string{"all", "foo"}) {
+		t.Fatalf("calls after adding * = %v", got)
+	}
+	irccon.ReplaceCallback("FOO", id, record("replaced"))
+	if got := run(); !reflect.DeepEqual(got, []string{"all", "replaced"}) {
+		t.Fatalf("calls after replace = %v", got)
+	}
+	irccon.RemoveCallback("FOO", id)
+	if got := run(); !reflect.DeepEqual(got, []string{"all"}) {
+		t.Fatalf("calls after remove = %v", got)
+	}
+}
+
+func TestRunCallbacksTimeoutKeepsEvent(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.CallbackTimeout = 10 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	irccon.AddCallback("FOO", func(*Event) { <-release })
+	irccon.AddCallback("FOO", func(*Event) {})
+
+	if irccon.runCallbacks(parseTestEvent(t, irccon, ":srv FOO me")) {
+		t.Fatal("runCallbacks reported completion while a callback was still running")
+	}
+}
+
+var benchLine = []byte("@time=2024-01-01T00:00:00.000Z;msgid=Qx1vK2bQ;account=alice :alice!~alice@user/alice PRIVMSG #go-nuts :has anyone benchmarked the new parser yet?\r\n")
+
+func BenchmarkParseToEvent(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseToEvent(string(benchLine)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseEventLinePooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e := getEvent()
+		if err := parseEventLine(e, benchLine, true); err != nil {
+			b.Fatal(err)
+		}
+		releaseEvent(e)
+	}
+}
+
+func BenchmarkParseEventLinePooledLazy(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e := getEvent()
+		if err := parseEventLine(e, benchLine, false); err != nil {
+			b.Fatal(err)
+		}
+		e.Account()
+		releaseEvent(e)
+	}
+}
+
+func BenchmarkRunCallbacks(b *testing.B) {
+	benchmarkRunCallbacks(b, DefaultCallbackTimeout)
+}
+
+func BenchmarkRunCallbacksNoTimeout(b *testing.B) {
+	benchmarkRunCallbacks(b, 0)
+}
+
+func benchmarkRunCallbacks(b *testing.B, timeout time.Duration) {
+	irccon := IRC("me", "testuser")
+	irccon.CallbackTimeout = timeout
+	irccon.ClearCallback("PRIVMSG")
+	irccon.AddCallback("PRIVMSG", func(*Event) {})
+	event, err := parseToEvent(string(benchLine))
+	if err != nil {
+		b.Fatal(err)
+	}
+	event.Connection = irccon
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		irccon.RunCallbacks(event)
+	}
+}
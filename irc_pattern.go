@@ -0,0 +1,118 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import "regexp"
+
+// MessagePattern selects the events passed to a pattern callback. Empty
+// fields match anything.
+type MessagePattern struct {
+	// Command is the event code, PRIVMSG when empty. CTCP messages have
+	// their own codes, e.g. CTCP_ACTION.
+	Command string
+
+	// Target is a mask with '*' and '?' wildcards for the channel or nick
+	// the message was sent to, e.g. "#go*". STATUSMSG prefixes ("@#go")
+	// are ignored.
+	Target string
+
+	// Source is a nick!user@host mask with wildcards, e.g. "*!*@*.example.net".
+	Source string
+
+	// Text is matched against the message text; its submatches are passed
+	// to the callback.
+	Text *regexp.Regexp
+}
+
+// AddPatternCallback registers callback for the events matching pattern.
+// match holds the submatches of pattern.Text, or is nil without one:
+//
+//	conn.AddPatternCallback(irc.MessagePattern{
+//		Target: "#*",
+//		Text:   regexp.MustCompile(`^!seen (\S+)`),
+//	}, func(e *irc.Event, match []string) {
+//		e.Reply(lastSeen(match[1]))
+//	})
+//
+// Patterns are matched once per event before any callback runs, so an
+// event only takes up a goroutine or CallbackTimeout for the callbacks it
+// matches. It returns the callback ID; remove it with RemoveCallback and
+// the pattern's Command ("PRIVMSG" when empty).
+func (irc *Connection) AddPatternCallback(pattern MessagePattern, callback func(e *Event, match []string)) int {
+	command := pattern.Command
+	if command == "" {
+		command = "PRIVMSG"
+	}
+	p := &patternCallback{pattern: pattern, fn: callback}
+	return irc.addCallback(command, p.run, callbackOptions{pattern: p})
+}
+
+// patternCallback is a callback added with AddPatternCallback.
+type patternCallback struct {
+	pattern MessagePattern
+	fn      func(e *Event, match []string)
+}
+
+// run is the registered callback, used when the event was not matched
+// beforehand.
+func (p *patternCallback) run(e *Event) {
+	if match, ok := p.pattern.match(e); ok {
+		p.fn(e, match)
+	}
+}
+
+// matching returns callbacks without the pattern callbacks that do not
+// match event; those that do are bound to their submatches.
+func (plan *callbackPlan) matching(event *Event, callbacks []callbackEntry) []callbackEntry {
+	if !plan.patterns {
+		return callbacks
+	}
+	matched := make([]callbackEntry, 0, len(callbacks))
+	for _, c := range callbacks {
+		if c.pattern == nil {
+			matched = append(matched, c)
+			continue
+		}
+		match, ok := c.pattern.pattern.match(event)
+		if !ok {
+			continue
+		}
+		fn := c.pattern.fn
+		c.cb = func(e *Event) { fn(e, match) }
+		c.pattern = nil
+		matched = append(matched, c)
+	}
+	return matched
+}
+
+// match reports whether e matches the pattern and returns the submatches
+// of Text.
+func (p *MessagePattern) match(e *Event) ([]string, bool) {
+	if p.Target != "" && !wildcardMatch(p.Target, e.Connection.stripStatusPrefix(e.Target())) {
+		return nil, false
+	}
+	if p.Source != "" && !wildcardMatch(p.Source, e.Source) {
+		return nil, false
+	}
+	if p.Text == nil {
+		return nil, true
+	}
+	match := p.Text.FindStringSubmatch(e.Message())
+	return match, match != nil
+}
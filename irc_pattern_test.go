@@ -0,0 +1,102 @@
+package irc
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPatternCallback(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	feedLines(t, irccon, ":srv 005 me STATUSMSG=@+ :are supported by this server")
+
+	var mu sync.Mutex
+	var got []string
+	record := func(name string) func(*Event, []string) {
+		return func(e *Event, match []string) {
+			mu.Lock()
+			got = append(got, fmt.Sprintf("%s:%s:%q", name, e.Nick, match))
+			mu.Unlock()
+		}
+	}
+	irccon.AddPatternCallback(MessagePattern{
+		Target: "#GO*",
+		Text:   regexp.MustCompile(`^!seen (\S+)`),
+	}, record("seen"))
+	irccon.AddPatternCallback(MessagePattern{Source: "*!*@*.example.net"}, record("trusted"))
+	irccon.AddPatternCallback(MessagePattern{Command: "ctcp_action", Target: "me"}, record("action"))
+
+	feedLines(t, irccon,
+		":alice!a@host.example.net PRIVMSG #go-nuts :!seen bob",
+		":carol!c@elsewhere PRIVMSG @#go :!seen dave",
+		":carol!c@elsewhere PRIVMSG #rust :!seen erin",
+		":carol!c@elsewhere PRIVMSG #go :hello",
+		":carol!c@elsewhere PRIVMSG me :\x01ACTION waves\x01",
+		":carol!c@elsewhere PRIVMSG #go :\x01ACTION waves\x01",
+	)
+
+	want := map[string]bool{
+		`seen:alice:["!seen bob" "bob"]`:   true,
+		`trusted:alice:[]`:                 true,
+		`seen:carol:["!seen dave" "dave"]`: true,
+		`action:carol:[]`:                  true,
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %d matches", got, len(want))
+	}
+	for _, g := range got {
+		if !want[g] {
+			t.Fatalf("unexpected match %s in %q", g, got)
+		}
+	}
+}
+
+func TestPatternCallbacksMatchedBeforeRunning(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	for i := 0; i < 50; i++ {
+		irccon.AddPatternCallback(MessagePattern{
+			Text: regexp.MustCompile(fmt.Sprintf(`^!cmd%d\b`, i)),
+		}, func(*Event, []string) {})
+	}
+	irccon.AddCallback("PRIVMSG", func(*Event) {})
+
+	e, err := parseToEvent(":a!u@h PRIVMSG #go :!cmd7 now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Connection = irccon
+	plan := irccon.callbacksFor("PRIVMSG")
+	if got := len(plan.matching(e, plan.plain)); got != 2 {
+		t.Fatalf("%d callbacks to run, want the matching pattern and the plain callback", got)
+	}
+}
+
+func TestPatternCallbackOnWorkers(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.DispatchWorkers = 2
+	irccon.pwrite = make(chan string, 10)
+
+	got := make(chan string, 2)
+	irccon.AddPatternCallback(MessagePattern{Text: regexp.MustCompile(`^!seen (\S+)`)}, func(e *Event, match []string) {
+		got <- match[1]
+	})
+	irccon.AddPatternCallback(MessagePattern{Text: regexp.MustCompile(`^!never`)}, func(e *Event, match []string) {
+		got <- "never"
+	})
+
+	server := startReadLoop(t, irccon)
+	go server.Write([]byte(":a!u@h PRIVMSG #go :!seen bob\r\n"))
+
+	select {
+	case nick := <-got:
+		if nick != "bob" {
+			t.Fatalf("matched %q, want bob", nick)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("pattern callback was not run")
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import "time"
+
+// RegainState describes the progress of automatic nick regain (see
+// Connection.NickRegain).
+type RegainState string
+
+const (
+	// RegainIdle - no regain in progress
+	RegainIdle RegainState = ""
+	// RegainWaiting - watching the desired nick until it becomes free
+	RegainWaiting RegainState = "waiting"
+	// RegainServices - asked NickServ to release the desired nick
+	RegainServices RegainState = "services"
+	// RegainAttempting - NICK sent for the desired nick, awaiting confirmation
+	RegainAttempting RegainState = "attempting"
+)
+
+// NickServ is the services nickname used for NickRegainCommand requests.
+const NickServ = "NickServ"
+
+// noteRegainFallbackLocked remembers the nick we wanted when the server
+// rejected it and the library falls back to an alternative.
+// The caller must hold irc.Lock.
+func (irc *Connection) noteRegainFallbackLocked(attemptedNick string) {
+	if !irc.NickRegain || irc.regainNick != "" || !ircNickEqual(attemptedNick, irc.nick) {
+		return
+	}
+	irc.regainNick = irc.nick
+	irc.regainState = RegainWaiting
+	irc.regainServicesSent = false
+
+	// Presence tracking only starts after registration, so watching now is
+	// safe both before and after 001.
+	irc.watchInternal(irc.regainNick)
+}
+
+// regainRejectedLocked handles a nick error for our own regain attempt:
+// instead of falling back again we keep the nick we have and wait for the
+// next offline notification. It reports whether the error was handled.
+// The caller must hold irc.Lock.
+func (irc *Connection) regainRejectedLocked(attemptedNick string) bool {
+	if irc.regainNick == "" || !irc.fullyConnected ||
+		!ircNickEqual(attemptedNick, irc.regainNick) || !ircNickEqual(attemptedNick, irc.nickPending) {
+		return false
+	}
+	irc.nickPending = ""
+	irc.nickChangeInProgress = false
+	if irc.nickcurrent != "" {
+		irc.nick = irc.nickcurrent
+	}
+	irc.regainState = RegainWaiting
+	irc.monitor.forgetPresence(irc.regainNick)
+
+	if irc.Debug {
+		irc.Log.Printf("NICK regain of %s rejected, waiting for it to become free", attemptedNick)
+	}
+	return true
+}
+
+// stopRegainLocked abandons regain, e.g. because it succeeded or the user
+// asked for a different nick. The caller must hold irc.Lock.
+func (irc *Connection) stopRegainLocked() {
+	if irc.regainNick == "" {
+		return
+	}
+	nick := irc.regainNick
+	irc.regainNick = ""
+	irc.regainState = RegainIdle
+	irc.regainServicesSent = false
+	irc.unwatchInternal(nick)
+}
+
+// startRegain runs after registration: it makes sure the desired nick is
+// watched and, when we are identified to services, asks NickServ to
+// release it.
+func (irc *Connection) startRegain() {
+	nick, servicesCommand := irc.prepareRegain()
+	if nick == "" {
+		return
+	}
+
+	irc.watchInternal(nick)
+	if servicesCommand != "" {
+		irc.sendText("PRIVMSG", NickServ, servicesCommand)
+	}
+	if online, known := irc.MonitorStatus(nick); known && !online {
+		irc.tryRegain()
+	}
+}
+
+// prepareRegain updates the regain state for a new session. It returns the
+// nick still to regain, if any, and the services command to send.
+func (irc *Connection) prepareRegain() (nick, servicesCommand string) {
+	irc.Lock()
+	defer irc.Unlock()
+
+	if irc.regainNick == "" {
+		return "", ""
+	}
+	if ircNickEqual(irc.nickcurrent, irc.regainNick) {
+		irc.stopRegainLocked()
+		return "", ""
+	}
+	nick = irc.regainNick
+	if irc.NickRegainCommand != "" && irc.account != "" && !irc.regainServicesSent {
+		irc.regainServicesSent = true
+		irc.regainState = RegainServices
+		servicesCommand = irc.NickRegainCommand + " " + nick
+	} else if irc.regainState != RegainAttempting {
+		irc.regainState = RegainWaiting
+	}
+	return nick, servicesCommand
+}
+
+// regainPresenceChanged is called by the presence tracker for internal
+// watches.
+func (irc *Connection) regainPresenceChanged(nick string, online bool) {
+	if !online && irc.regaining(nick) {
+		irc.tryRegain()
+	}
+}
+
+// regaining reports whether nick is the nick we are trying to regain.
+func (irc *Connection) regaining(nick string) bool {
+	irc.Lock()
+	defer irc.Unlock()
+	return irc.regainNick != "" && ircNickEqual(nick, irc.regainNick)
+}
+
+// tryRegain sends NICK for the desired nick once it has been reported free.
+func (irc *Connection) tryRegain() {
+	nick := irc.claimRegain()
+	if nick == "" {
+		return
+	}
+
+	if irc.Debug {
+		irc.Log.Printf("NICK regain: %s is free, trying to take it", nick)
+	}
+	irc.send("NICK", nick)
+}
+
+// claimRegain marks a regain attempt as in progress and returns the nick
+// to send, or "" if no attempt should be made now.
+func (irc *Connection) claimRegain() string {
+	irc.Lock()
+	defer irc.Unlock()
+
+	if irc.regainNick == "" || !irc.fullyConnected || ircNickEqual(irc.nickcurrent, irc.regainNick) ||
+		(irc.nickChangeInProgress && ircNickEqual(irc.nickPending, irc.regainNick)) {
+		return ""
+	}
+	nick := irc.regainNick
+	irc.nickPending = nick
+	irc.nickChangeInProgress = true
+	irc.nickChangeTimeout = time.Now()
+	irc.lastNickChange = time.Now()
+	irc.regainState = RegainAttempting
+	return nick
+}
+
+// setupRegainCallbacks installs the handlers driving nick regain and
+// services account tracking.
+func (irc *Connection) setupRegainCallbacks() {
+	irc.AddCallback(RPL_ENDOFMOTD, func(e *Event) { irc.startRegain() })
+	irc.AddCallback(ERR_NOMOTD, func(e *Event) { irc.startRegain() })
+
+	// RPL_LOGGEDIN (900): :server 900 <nick> <nick>!<user>@<host> <account> :You are now logged in as <account>
+	irc.AddCallback(RPL_LOGGEDIN, func(e *Event) {
+		if len(e.Arguments) < 3 {
+			return
+		}
+		irc.Lock()
+		defer irc.Unlock()
+		irc.account = e.Arguments[2]
+	})
+
+	// RPL_LOGGEDOUT (901): :server 901 <nick> <nick>!<user>@<host> :You are now logged out
+	irc.AddCallback(RPL_LOGGEDOUT, func(e *Event) {
+		irc.Lock()
+		defer irc.Unlock()
+		irc.account = ""
+	})
+}
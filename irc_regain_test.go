@@ -0,0 +1,116 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+// drainRawCommands collects the commands currently queued on pwrite.
+func drainRawCommands(t *testing.T, pwrite <-chan string, n int) map[string]bool {
+	t.Helper()
+	got := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		got[nextRawCommand(t, pwrite)] = true
+	}
+	return got
+}
+
+func registerWithFallback(t *testing.T, irccon *Connection) {
+	t.Helper()
+	irccon.RunCallbacks(&Event{Code: "433", Arguments: []string{"*", "wanted", "Nickname is already in use"}})
+	alternative := generateAlternativeNick("wanted")
+	if got := nextRawCommand(t, irccon.pwrite); got != "NICK "+alternative+"\r\n" {
+		t.Fatalf("fallback command = %q", got)
+	}
+	irccon.RunCallbacks(&Event{Code: "001", Arguments: []string{alternative, "Welcome"}})
+	irccon.RunCallbacks(&Event{Code: "005", Arguments: []string{alternative, "MONITOR=100", "are supported by this server"}})
+}
+
+func TestNickRegainWhenDesiredNickGoesOffline(t *testing.T) {
+	irccon := IRC("wanted", "regainuser")
+	irccon.pwrite = make(chan string, 8)
+	irccon.NickRegain = true
+
+	registerWithFallback(t, irccon)
+	irccon.RunCallbacks(&Event{Code: "376", Arguments: []string{"wanted_", "End of MOTD"}})
+
+	if got := nextRawCommand(t, irccon.pwrite); got != "MONITOR + wanted\r\n" {
+		t.Fatalf("command = %q, want MONITOR + wanted", got)
+	}
+	status := irccon.GetNickStatus()
+	if status.RegainNick != "wanted" || status.RegainState != RegainWaiting {
+		t.Fatalf("regain status = %q/%q", status.RegainNick, status.RegainState)
+	}
+	if len(irccon.MonitorList()) != 0 {
+		t.Fatalf("internal watch leaked into MonitorList: %v", irccon.MonitorList())
+	}
+
+	irccon.RunCallbacks(&Event{Code: "731", Arguments: []string{"wanted_", "wanted"}})
+	if got := nextRawCommand(t, irccon.pwrite); got != "NICK wanted\r\n" {
+		t.Fatalf("command = %q, want NICK wanted", got)
+	}
+	if state := irccon.GetNickStatus().RegainState; state != RegainAttempting {
+		t.Fatalf("RegainState = %q, want %q", state, RegainAttempting)
+	}
+
+	event, _ := parseToEvent(":wanted_!regainuser@host NICK wanted")
+	event.Connection = irccon
+	irccon.RunCallbacks(event)
+
+	if got := nextRawCommand(t, irccon.pwrite); got != "MONITOR - wanted\r\n" {
+		t.Fatalf("command = %q, want MONITOR - wanted", got)
+	}
+	status = irccon.GetNickStatus()
+	if status.Current != "wanted" || status.RegainNick != "" || status.RegainState != RegainIdle {
+		t.Fatalf("status after regain = %+v", status)
+	}
+}
+
+func TestNickRegainUsesServicesAndWaitsAfterRejection(t *testing.T) {
+	irccon := IRC("wanted", "regainuser")
+	irccon.pwrite = make(chan string, 8)
+	irccon.NickRegain = true
+	irccon.NickRegainCommand = "REGAIN"
+
+	irccon.RunCallbacks(&Event{Code: "900", Arguments: []string{"*", "wanted_!regainuser@host", "wantedacct", "You are now logged in"}})
+	registerWithFallback(t, irccon)
+	irccon.RunCallbacks(&Event{Code: "376", Arguments: []string{"wanted_", "End of MOTD"}})
+
+	got := drainRawCommands(t, irccon.pwrite, 2)
+	if !got["MONITOR + wanted\r\n"] || !got["PRIVMSG NickServ :REGAIN wanted\r\n"] {
+		t.Fatalf("commands after registration = %v", got)
+	}
+	if state := irccon.GetNickStatus().RegainState; state != RegainServices {
+		t.Fatalf("RegainState = %q, want %q", state, RegainServices)
+	}
+
+	irccon.RunCallbacks(&Event{Code: "731", Arguments: []string{"wanted_", "wanted"}})
+	if cmd := nextRawCommand(t, irccon.pwrite); cmd != "NICK wanted\r\n" {
+		t.Fatalf("command = %q, want NICK wanted", cmd)
+	}
+
+	// Someone else grabbed it first: keep our nick and wait.
+	irccon.RunCallbacks(&Event{Code: "433", Arguments: []string{"wanted_", "wanted", "Nickname is already in use"}})
+	select {
+	case cmd := <-irccon.pwrite:
+		t.Fatalf("unexpected command after rejected regain: %q", cmd)
+	case <-time.After(50 * time.Millisecond):
+	}
+	status := irccon.GetNickStatus()
+	if status.Current != "wanted_" || status.Desired != "wanted_" || status.RegainState != RegainWaiting {
+		t.Fatalf("status after rejected regain = %+v", status)
+	}
+}
+
+func TestExplicitNickCancelsRegain(t *testing.T) {
+	irccon := IRC("wanted", "regainuser")
+	irccon.pwrite = make(chan string, 8)
+	irccon.NickRegain = true
+
+	registerWithFallback(t, irccon)
+	irccon.Nick("other")
+
+	if status := irccon.GetNickStatus(); status.RegainNick != "" || status.RegainState != RegainIdle {
+		t.Fatalf("regain still active after Nick(): %+v", status)
+	}
+}
@@ -12,10 +12,11 @@ type SASLResult struct {
 	Err    error
 }
 
-// Check if a space-separated list of arguments contains a value.
+// Check if a space-separated list of capabilities contains a value.
+// CAP 302 values ("sasl=PLAIN,EXTERNAL") are ignored when comparing.
 func listContains(list string, value string) bool {
 	for _, arg_name := range strings.Split(strings.TrimSpace(list), " ") {
-		if arg_name == value {
+		if capName(arg_name) == value {
 			return true
 		}
 	}
@@ -23,58 +24,63 @@ func listContains(list string, value string) bool {
 }
 
 func (irc *Connection) setupSASLCallbacks(result chan<- *SASLResult) (callbacks []CallbackID) {
-	id := irc.AddCallback("CAP", func(e *Event) {
-		if len(e.Arguments) == 3 {
-			if e.Arguments[1] == "LS" {
-				if !listContains(e.Arguments[2], "sasl") {
-					result <- &SASLResult{true, errors.New("no SASL capability " + e.Arguments[2])}
-				}
+	var advertised []string
+	id := irc.addInternalCallback("CAP", func(e *Event) {
+		if len(e.Arguments) >= 3 && e.Arguments[1] == "LS" {
+			// CAP 302 may split LS over several lines; only the last one
+			// lacks the "*" continuation marker.
+			advertised = append(advertised, e.Message())
+			if !isCapLSContinuation(e) && !listContains(strings.Join(advertised, " "), "sasl") {
+				result <- &SASLResult{true, errors.New("no SASL capability " + strings.Join(advertised, " "))}
 			}
+			return
+		}
+		if len(e.Arguments) == 3 {
 			if e.Arguments[1] == "ACK" && listContains(e.Arguments[2], "sasl") {
 				if irc.SASLMech != "PLAIN" && irc.SASLMech != "EXTERNAL" {
 					result <- &SASLResult{true, errors.New("only PLAIN and EXTERNAL supported")}
 				}
-				irc.SendRaw("AUTHENTICATE " + irc.SASLMech)
+				irc.send("AUTHENTICATE", irc.SASLMech)
 			}
 		}
 	})
 	callbacks = append(callbacks, CallbackID{"CAP", id})
 
-	id = irc.AddCallback("AUTHENTICATE", func(e *Event) {
+	id = irc.addInternalCallback("AUTHENTICATE", func(e *Event) {
 		if irc.SASLMech == "EXTERNAL" {
-			irc.SendRaw("AUTHENTICATE +")
+			irc.send("AUTHENTICATE", "+")
 			return
 		}
 		str := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s\x00%s\x00%s", irc.SASLLogin, irc.SASLLogin, irc.SASLPassword)))
-		irc.SendRaw("AUTHENTICATE " + str)
+		irc.send("AUTHENTICATE", str)
 	})
 	callbacks = append(callbacks, CallbackID{"AUTHENTICATE", id})
 
-	id = irc.AddCallback("901", func(e *Event) {
-		irc.SendRaw("CAP END")
-		irc.SendRaw("QUIT")
+	id = irc.addInternalCallback(RPL_LOGGEDOUT, func(e *Event) {
+		irc.send("CAP", "END")
+		irc.send("QUIT")
 		result <- &SASLResult{true, errors.New(e.Arguments[1])}
 	})
-	callbacks = append(callbacks, CallbackID{"901", id})
+	callbacks = append(callbacks, CallbackID{RPL_LOGGEDOUT, id})
 
-	id = irc.AddCallback("902", func(e *Event) {
-		irc.SendRaw("CAP END")
-		irc.SendRaw("QUIT")
+	id = irc.addInternalCallback(ERR_NICKLOCKED, func(e *Event) {
+		irc.send("CAP", "END")
+		irc.send("QUIT")
 		result <- &SASLResult{true, errors.New(e.Arguments[1])}
 	})
-	callbacks = append(callbacks, CallbackID{"902", id})
+	callbacks = append(callbacks, CallbackID{ERR_NICKLOCKED, id})
 
-	id = irc.AddCallback("903", func(e *Event) {
+	id = irc.addInternalCallback(RPL_SASLSUCCESS, func(e *Event) {
 		result <- &SASLResult{false, nil}
 	})
-	callbacks = append(callbacks, CallbackID{"903", id})
+	callbacks = append(callbacks, CallbackID{RPL_SASLSUCCESS, id})
 
-	id = irc.AddCallback("904", func(e *Event) {
-		irc.SendRaw("CAP END")
-		irc.SendRaw("QUIT")
+	id = irc.addInternalCallback(ERR_SASLFAIL, func(e *Event) {
+		irc.send("CAP", "END")
+		irc.send("QUIT")
 		result <- &SASLResult{true, errors.New(e.Arguments[1])}
 	})
-	callbacks = append(callbacks, CallbackID{"904", id})
+	callbacks = append(callbacks, CallbackID{ERR_SASLFAIL, id})
 
 	return
 }
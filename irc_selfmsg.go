@@ -0,0 +1,58 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+// hasCap reports whether the server acknowledged the given capability
+// during the current CAP negotiation.
+func (irc *Connection) hasCap(name string) bool {
+	irc.Lock()
+	defer irc.Unlock()
+	for _, acked := range irc.AcknowledgedCaps {
+		if acked == name {
+			return true
+		}
+	}
+	return false
+}
+
+// emitSelfMessage raises a synthetic event for a message we have just sent
+// when SelfMessageEvents is enabled. Nothing is emitted once the server has
+// acknowledged echo-message, because the server echo already carries the
+// same information and we would otherwise deliver every message twice.
+//
+// The event is queued for the read loop (see queueEvent), in the order the
+// messages were sent, so that a callback replying to a message does not run
+// the callbacks of its own reply from inside itself.
+func (irc *Connection) emitSelfMessage(code, target, message string) {
+	if !irc.SelfMessageEvents || irc.hasCap(CapEchoMessage) {
+		return
+	}
+
+	nick := irc.GetNick()
+	irc.queueEvent(&Event{
+		Code:       code,
+		Raw:        code + " " + target + " :" + message,
+		Nick:       nick,
+		User:       irc.user,
+		Source:     nick,
+		Arguments:  []string{target, message},
+		Connection: irc,
+		Self:       true,
+	})
+}
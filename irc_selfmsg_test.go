@@ -0,0 +1,105 @@
+package irc
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSelfMessageEventsForPrivmsgAndAction(t *testing.T) {
+	irccon := IRC("selfbot", "selfuser")
+	irccon.pwrite = make(chan string, 4)
+	irccon.SelfMessageEvents = true
+
+	events := make(chan *Event, 4)
+	irccon.AddCallback("PRIVMSG", func(e *Event) { events <- e })
+	irccon.AddCallback("CTCP_ACTION", func(e *Event) { events <- e })
+
+	irccon.Privmsg("#chan", "hello")
+	irccon.Action("#chan", "waves")
+
+	for _, want := range []struct{ code, msg string }{
+		{"PRIVMSG", "hello"},
+		{"CTCP_ACTION", "waves"},
+	} {
+		select {
+		case e := <-events:
+			if e.Code != want.code || e.Message() != want.msg {
+				t.Fatalf("got %s %q, want %s %q", e.Code, e.Message(), want.code, want.msg)
+			}
+			if !e.Self {
+				t.Fatalf("%s event not flagged as self", e.Code)
+			}
+			if e.Nick != "selfbot" || e.Arguments[0] != "#chan" {
+				t.Fatalf("unexpected source/target %q -> %q", e.Nick, e.Arguments[0])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no synthetic %s event", want.code)
+		}
+	}
+}
+
+func TestSelfMessageEventsDisabledByEchoMessage(t *testing.T) {
+	irccon := IRC("selfbot", "selfuser")
+	irccon.pwrite = make(chan string, 4)
+	irccon.SelfMessageEvents = true
+	irccon.AcknowledgedCaps = []string{"echo-message"}
+
+	events := make(chan *Event, 1)
+	irccon.AddCallback("NOTICE", func(e *Event) { events <- e })
+
+	irccon.Notice("#chan", "hello")
+
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected synthetic event with echo-message: %#v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSelfCTCPDoesNotTriggerBuiltinReplies(t *testing.T) {
+	irccon := IRC("selfbot", "selfuser")
+	irccon.pwrite = make(chan string, 4)
+	irccon.SelfMessageEvents = true
+
+	irccon.Privmsg("friend", "\x01VERSION\x01")
+
+	if got := nextRawCommand(t, irccon.pwrite); got != "PRIVMSG friend :\x01VERSION\x01\r\n" {
+		t.Fatalf("unexpected command %q", got)
+	}
+	select {
+	case got := <-irccon.pwrite:
+		t.Fatalf("built-in CTCP handler replied to our own request: %q", got)
+	default:
+	}
+}
+
+func TestSelfMessageEventsQueuedOnReadLoop(t *testing.T) {
+	irccon := IRC("selfbot", "selfuser")
+	irccon.pwrite = make(chan string, 10)
+	irccon.SelfMessageEvents = true
+
+	var active atomic.Int32
+	self := make(chan bool, 1)
+	irccon.AddCallback("PRIVMSG", func(e *Event) {
+		if e.Self {
+			self <- active.Load() == 0
+			return
+		}
+		active.Add(1)
+		defer active.Add(-1)
+		irccon.Privmsg(e.Nick, "pong")
+	})
+
+	server := startReadLoop(t, irccon)
+	go server.Write([]byte(":friend!u@h PRIVMSG selfbot :ping\r\n"))
+
+	select {
+	case outside := <-self:
+		if !outside {
+			t.Fatal("self event ran inside the callback that sent it")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no self event for the reply")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import "strings"
+
+// IRCv3 standard reply event codes.
+const (
+	EventFail = "FAIL"
+	EventWarn = "WARN"
+	EventNote = "NOTE"
+)
+
+// StandardReply is an IRCv3 standard reply
+// (https://ircv3.net/specs/extensions/standard-replies):
+//
+//	FAIL <command> <code> [<context>...] :<description>
+//
+// A FAIL reply also implements error, so helpers such as WhoisSync return
+// it directly when the server rejects their command; use errors.As to get
+// at the details.
+type StandardReply struct {
+	Type        string   // EventFail, EventWarn or EventNote
+	Command     string   // command the reply is about, "*" if none
+	Code        string   // machine-readable code, e.g. "ACCOUNT_REQUIRED"
+	Context     []string // optional context parameters
+	Description string   // human-readable description
+	Label       string   // labeled-response label tag, if any
+}
+
+// Error implements error.
+func (r *StandardReply) Error() string {
+	var b strings.Builder
+	b.WriteString(r.Type)
+	b.WriteByte(' ')
+	b.WriteString(r.Command)
+	b.WriteByte(' ')
+	b.WriteString(r.Code)
+	for _, c := range r.Context {
+		b.WriteByte(' ')
+		b.WriteString(c)
+	}
+	if r.Description != "" {
+		b.WriteString(": ")
+		b.WriteString(r.Description)
+	}
+	return b.String()
+}
+
+// StandardReply parses a FAIL, WARN or NOTE event. ok is false for other
+// events or when the reply lacks the command and code.
+func (e *Event) StandardReply() (reply *StandardReply, ok bool) {
+	if e.Code != EventFail && e.Code != EventWarn && e.Code != EventNote {
+		return nil, false
+	}
+	if len(e.Arguments) < 3 {
+		return nil, false
+	}
+	args := e.Arguments
+	label, _ := e.Tag("label")
+	return &StandardReply{
+		Type:        e.Code,
+		Command:     strings.ToUpper(args[0]),
+		Code:        args[1],
+		Context:     append([]string(nil), args[2:len(args)-1]...),
+		Description: args[len(args)-1],
+		Label:       label,
+	}, true
+}
+
+// AddStandardReplyCallback registers callback for EventFail, EventWarn or
+// EventNote replies and hands it the parsed reply. Malformed replies are
+// skipped. The returned ID can be passed to RemoveCallback together with
+// replyType.
+func (irc *Connection) AddStandardReplyCallback(replyType string, callback func(e *Event, reply *StandardReply)) int {
+	return irc.AddCallback(replyType, func(e *Event) {
+		if reply, ok := e.StandardReply(); ok {
+			callback(e, reply)
+		}
+	})
+}
+
+// routeStandardFail ends the synchronous request a FAIL refers to with the
+// reply as its error. A FAIL that cannot be tied to one request is left to
+// user callbacks rather than failing unrelated ones: the library does not
+// label its own commands, so a labeled FAIL answers one sent by the user.
+func (irc *Connection) routeStandardFail(reply *StandardReply) {
+	if reply.Label != "" {
+		return
+	}
+	var target string
+	if len(reply.Context) > 0 {
+		target = reply.Context[0]
+	}
+
+	switch reply.Command {
+	case "WHOIS":
+		if target != "" {
+			irc.whois.finish(target, reply)
+		}
+	case "WHO":
+		if target != "" {
+			irc.who.failMask(target, reply)
+		}
+	case "LIST":
+		irc.list.failAll(reply)
+	case "PRIVMSG", "NOTICE":
+		if target != "" {
+			irc.ctcp.failNick(target, reply)
+		}
+	}
+}
+
+// setupStandardReplyCallbacks routes FAIL replies to pending requests.
+func (irc *Connection) setupStandardReplyCallbacks() {
+	irc.AddStandardReplyCallback(EventFail, func(e *Event, reply *StandardReply) {
+		if irc.Debug {
+			irc.Log.Printf("Standard reply: %s", reply.Error())
+		}
+		irc.routeStandardFail(reply)
+	})
+}
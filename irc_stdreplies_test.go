@@ -0,0 +1,105 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStandardReplyParsing(t *testing.T) {
+	e := parseTestEvent(t, nil, "@label=abc :srv FAIL JOIN CHANNEL_FULL #go extra :Channel is full")
+	reply, ok := e.StandardReply()
+	if !ok {
+		t.Fatal("expected FAIL to parse")
+	}
+	want := &StandardReply{
+		Type:        EventFail,
+		Command:     "JOIN",
+		Code:        "CHANNEL_FULL",
+		Context:     []string{"#go", "extra"},
+		Description: "Channel is full",
+		Label:       "abc",
+	}
+	if !reflect.DeepEqual(reply, want) {
+		t.Fatalf("unexpected reply %+v", reply)
+	}
+	if reply.Error() != "FAIL JOIN CHANNEL_FULL #go extra: Channel is full" {
+		t.Fatalf("unexpected error text %q", reply.Error())
+	}
+
+	if r, ok := parseTestEvent(t, nil, ":srv NOTE * OPER_MESSAGE :hello").StandardReply(); !ok || r.Command != "*" || len(r.Context) != 0 {
+		t.Fatalf("unexpected NOTE parse %+v, %v", r, ok)
+	}
+	for _, line := range []string{":srv FAIL JOIN", ":srv PRIVMSG #go :FAIL"} {
+		if _, ok := parseTestEvent(t, nil, line).StandardReply(); ok {
+			t.Errorf("%q: expected no standard reply", line)
+		}
+	}
+}
+
+func TestStandardReplyCallbacks(t *testing.T) {
+	irccon := IRC("me", "testuser")
+
+	var got []string
+	irccon.AddStandardReplyCallback(EventWarn, func(e *Event, r *StandardReply) {
+		got = append(got, r.Code)
+	})
+	feedLines(t, irccon,
+		":srv WARN REHASH CERTS_EXPIRED :Certificate has expired",
+		":srv FAIL * UNKNOWN :not a warning",
+		":srv WARN",
+	)
+	if !reflect.DeepEqual(got, []string{"CERTS_EXPIRED"}) {
+		t.Fatalf("unexpected WARN callbacks %v", got)
+	}
+}
+
+func TestStandardFailEndsSyncRequests(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	whois := runWhoisSync(irccon, "alice")
+	nextRawCommand(t, irccon.pwrite)
+	who := make(chan error, 1)
+	go func() {
+		_, err := irccon.WhoSync(context.Background(), "#go", "")
+		who <- err
+	}()
+	nextRawCommand(t, irccon.pwrite)
+
+	// FAILs that cannot be tied to these requests are left alone
+	feedLines(t, irccon,
+		":srv FAIL WHOIS RATE_LIMITED bob :Slow down",
+		":srv FAIL WHOIS RATE_LIMITED :Slow down",
+		"@label=mine :srv FAIL WHOIS RATE_LIMITED alice :Slow down",
+		":srv FAIL WHO NEED_REGISTRATION #other :Register first",
+		":srv FAIL WHO NEED_REGISTRATION :Register first",
+	)
+	select {
+	case r := <-whois:
+		t.Fatalf("WhoisSync ended by an unrelated FAIL: %v", r.err)
+	case err := <-who:
+		t.Fatalf("WhoSync ended by an unrelated FAIL: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	feedLines(t, irccon,
+		":srv FAIL WHOIS RATE_LIMITED alice :Slow down",
+		":srv FAIL WHO NEED_REGISTRATION #GO :Register first",
+	)
+
+	var reply *StandardReply
+	if r := <-whois; !errors.As(r.err, &reply) || reply.Code != "RATE_LIMITED" {
+		t.Fatalf("expected WHOIS to fail with RATE_LIMITED, got %v", r.err)
+	}
+	select {
+	case err := <-who:
+		if !errors.As(err, &reply) || reply.Code != "NEED_REGISTRATION" {
+			t.Fatalf("expected WHO to fail with NEED_REGISTRATION, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("WhoSync did not return after FAIL")
+	}
+}
@@ -75,6 +75,7 @@ type Connection struct {
 	endClosed              bool
 	pwriteClosed           bool
 	disconnectedEmitted    bool
+	capsNegotiated         bool   // EventCapNegotiated was raised for this connection
 	nick                   string // The nickname we want.
 	nickcurrent            string // The nickname we currently have (confirmed by server).
 	nickPending            string // The nickname currently pending confirmation from the server.
@@ -125,6 +126,120 @@ type Connection struct {
 
 	// internal counter for recoverable reconnect attempts within current session
 	recoverableReconnects int
+
+	// SelfMessageEvents raises synthetic PRIVMSG, NOTICE and CTCP_ACTION events
+	// (with Event.Self set) for every message sent through Privmsg, Notice and
+	// Action, so bridges and loggers can see what the bot itself said. It is
+	// ignored while the server has acknowledged echo-message, since the server
+	// echo already delivers those lines. The events are delivered by the
+	// read loop, not by the goroutine sending the message. Defaults to false.
+	SelfMessageEvents bool
+
+	isupport map[string]string // RPL_ISUPPORT tokens of the current session
+
+	// MonitorPollInterval is the ISON polling interval used for Monitor()
+	// targets on servers without MONITOR support. Zero means
+	// DefaultMonitorPollInterval.
+	MonitorPollInterval time.Duration
+
+	monitor *monitorTracker // presence tracking (MONITOR/ISON)
+
+	// NickRegain makes the library win back the desired nick after it had to
+	// fall back to an alternative because of 433/436/437. The desired nick is
+	// watched with MONITOR (or ISON) and NICK is sent as soon as it is free.
+	// Progress is reported by GetNickStatus(). Defaults to false.
+	NickRegain bool
+
+	// NickRegainCommand is the NickServ command ("GHOST", "REGAIN" or
+	// "RECOVER") sent once per session to release the desired nick when we
+	// are identified to services. Empty disables the services request.
+	NickRegainCommand string
+
+	regainNick         string      // nick we want back after a fallback
+	regainState        RegainState // progress of the regain
+	regainServicesSent bool        // NickRegainCommand already sent this session
+	account            string      // services account we are logged in as (900/901)
+
+	// AltNick chooses the nick to try when the server rejects ours with
+	// 433/436/437/432. Use AltNickList for an ordered list of alternatives
+	// or PatternAltNick for NICKLEN-aware suffixes. Nil means RFCAltNick,
+	// the RFC 2812 9-character generator.
+	AltNick AltNickStrategy
+
+	altNickAttempt int // fallbacks since the last confirmed nick
+
+	whois *whoisTracker // WhoisSync requests in flight
+	who   *whoTracker   // WhoSync/WhoStream queries in flight
+	list  *listTracker  // List query in flight
+
+	// CTCPRequestInterval and CTCPRequestBurst rate limit outgoing CTCP
+	// requests (CTCP, CTCPPing): up to CTCPRequestBurst at once, then one
+	// per CTCPRequestInterval. Zero means DefaultCTCPRequestInterval and
+	// DefaultCTCPRequestBurst.
+	CTCPRequestInterval time.Duration
+	CTCPRequestBurst    int
+
+	ctcp *ctcpTracker // CTCP requests awaiting replies
+
+	// CTCPFloodInterval and CTCPFloodBurst limit the CTCP replies sent to a
+	// single sender: up to CTCPFloodBurst at once, then one per
+	// CTCPFloodInterval; requests over the limit are ignored. Zero means
+	// DefaultCTCPFloodInterval and DefaultCTCPFloodBurst.
+	CTCPFloodInterval time.Duration
+	CTCPFloodBurst    int
+
+	// CTCPSource is the CTCP SOURCE reply. Empty means DefaultCTCPSource.
+	CTCPSource string
+
+	ctcpHandlers *ctcpRegistry // incoming CTCP responders, see HandleCTCP
+
+	// PoolEvents recycles the Event of every incoming line once all of its
+	// callbacks have returned. Callbacks must then not keep the *Event, its
+	// Arguments or its Tags after returning; use Event.Clone to keep one.
+	// Strings taken from the event stay valid.
+	PoolEvents bool
+
+	// LazyTags leaves Event.Tags nil for incoming lines and decodes tags only
+	// when Event.Tag or Event.TagMap is called.
+	LazyTags bool
+
+	callbackCache map[string]*callbackPlan // RunCallbacks snapshots, reset when callbacks change
+	callbackOpts  map[int]callbackOptions  // options of callbacks that have any, by ID
+	setupInternal bool                     // setupCallbacks is registering our own handlers; guarded by eventsMutex
+
+	// DispatchWorkers runs the callbacks of incoming lines on a pool of this
+	// many workers, so a slow callback no longer stalls reading from the
+	// server. A worker runs the callbacks of one event one after another,
+	// each with its own CallbackTimeout context. Zero keeps the default of a
+	// goroutine per callback with the read loop waiting for all of them.
+	DispatchWorkers int
+
+	// DispatchQueueSize is the number of events that may wait for a worker
+	// before the read loop blocks. Zero means DefaultDispatchQueueSize.
+	DispatchQueueSize int
+
+	// DispatchOrdered sends all events for one channel, or from one sender
+	// for private messages and server replies, to the same worker so they
+	// are handled in the order they arrived.
+	DispatchOrdered bool
+
+	dispatch *dispatcher  // worker pool of the current connection
+	local    *localEvents // events raised by the library, delivered by the read loop
+
+	// CallbackPanicHandler is called with every panic recovered from a
+	// callback, after it has been logged and before EventCallbackPanic is
+	// run. It is called on the goroutine of the failed callback.
+	CallbackPanicHandler func(*CallbackPanic)
+
+	// MaxCallbackPanics removes a callback once it has panicked this many
+	// times. Zero keeps callbacks however often they panic. The library's
+	// own handlers are never removed.
+	MaxCallbackPanics int
+
+	callbackPanics map[int]int // panics per callback ID, for MaxCallbackPanics
+
+	eventMiddleware []EventMiddleware // see AddEventMiddleware, guarded by eventsMutex
+	lineMiddleware  []LineMiddleware  // see AddLineMiddleware, guarded by eventsMutex
 }
 
 // ErrorType represents different categories of IRC ERROR messages
@@ -176,6 +291,12 @@ type Event struct {
 	Tags       map[string]string
 	Connection *Connection
 	Ctx        context.Context
+
+	// Self is true for synthetic events describing a message we sent
+	// ourselves (see Connection.SelfMessageEvents).
+	Self bool
+
+	rawTags string // undecoded tag section of Raw, see Tag
 }
 
 // Message retrieves the last message from Event arguments.
@@ -232,4 +353,11 @@ type NickStatus struct {
 	// Error contains any error related to the nickname (e.g., already in use).
 	// This is set when the server rejects a nickname change.
 	Error string
+
+	// RegainNick is the nickname the library is trying to win back when
+	// NickRegain is enabled, or empty when no regain is in progress.
+	RegainNick string
+
+	// RegainState describes the progress of the regain.
+	RegainState RegainState
 }
@@ -0,0 +1,124 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// MatchCodes returns a filter for Subscribe and WaitFor accepting events
+// with any of the given codes.
+func MatchCodes(codes ...string) func(*Event) bool {
+	upper := make([]string, len(codes))
+	for i, code := range codes {
+		upper[i] = strings.ToUpper(code)
+	}
+	return func(e *Event) bool {
+		for _, code := range upper {
+			if e.Code == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// subscription buffers the events of one Subscribe call until they are read.
+type subscription struct {
+	mu     sync.Mutex
+	queue  []*Event
+	notify chan struct{}
+}
+
+// Subscribe returns a channel receiving every event accepted by filter, or
+// every event when filter is nil, until ctx is done; the channel is then
+// closed and the subscription removed. Events are buffered as they arrive,
+// so reading slowly never holds up other events, but the channel must be
+// read or ctx canceled.
+//
+// Subscriptions see events after the library's own handlers, including
+// synthetic events such as DISCONNECTED, and stay active across
+// reconnects. To wait for the reply to a command, subscribe before
+// sending it:
+//
+//	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+//	defer cancel()
+//	replies := conn.Subscribe(ctx, irc.MatchCodes(irc.RPL_TIME))
+//	conn.SendRaw("TIME")
+//	e, ok := <-replies
+func (irc *Connection) Subscribe(ctx context.Context, filter func(*Event) bool) <-chan *Event {
+	s := &subscription{notify: make(chan struct{}, 1)}
+	id := irc.addCallback("*", func(e *Event) {
+		if filter != nil && !filter(e) {
+			return
+		}
+		if irc.PoolEvents {
+			e = e.Clone()
+		}
+		s.mu.Lock()
+		s.queue = append(s.queue, e)
+		s.mu.Unlock()
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}, callbackOptions{CallbackOptions: CallbackOptions{AfterInternal: true}})
+
+	events := make(chan *Event)
+	go func() {
+		defer close(events)
+		defer irc.RemoveCallback("*", id)
+		for {
+			s.mu.Lock()
+			batch := s.queue
+			s.queue = nil
+			s.mu.Unlock()
+
+			for _, e := range batch {
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-s.notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// WaitFor returns the first event accepted by predicate, or ctx.Err() when
+// ctx is done first. Like Subscribe it keeps waiting across reconnects.
+func (irc *Connection) WaitFor(ctx context.Context, predicate func(*Event) bool) (*Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	e, ok := <-irc.Subscribe(ctx, predicate)
+	if !ok {
+		return nil, ctx.Err()
+	}
+	return e, nil
+}
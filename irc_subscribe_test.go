@@ -0,0 +1,105 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.PoolEvents = true
+
+	before := len(irccon.events["*"])
+	ctx, cancel := context.WithCancel(context.Background())
+	events := irccon.Subscribe(ctx, MatchCodes("privmsg", EventDisconnected))
+
+	server := startReadLoop(t, irccon)
+	go server.Write([]byte(":a!u@h PRIVMSG #go :one\r\n:srv NOTICE me :skip\r\n:b!u@h PRIVMSG #go :two\r\n"))
+
+	for _, want := range []string{"one", "two"} {
+		select {
+		case e := <-events:
+			if e.Code != "PRIVMSG" || e.Message() != want {
+				t.Fatalf("got %s %q, want PRIVMSG %q", e.Code, e.Message(), want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	irccon.emitDisconnected("gone")
+	if e := <-events; e.Code != EventDisconnected {
+		t.Fatalf("got %s, want %s", e.Code, EventDisconnected)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("received an event after cancel")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		irccon.eventsMutex.Lock()
+		n := len(irccon.events["*"])
+		irccon.eventsMutex.Unlock()
+		if n == before {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("subscription callback not removed, %d \"*\" callbacks left", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSubscribeSlowReaderDoesNotBlock(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.CallbackTimeout = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := irccon.Subscribe(ctx, nil)
+
+	for i := 0; i < 100; i++ {
+		feedLines(t, irccon, ":srv FOO me")
+	}
+	for i := 0; i < 100; i++ {
+		if e := <-events; e.Code != "FOO" {
+			t.Fatalf("event %d is %s", i, e.Code)
+		}
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		feedLines(t, irccon, ":srv 001 me :Welcome", ":srv 001 newnick :Welcome")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	e, err := irccon.WaitFor(ctx, func(e *Event) bool {
+		return e.Code == RPL_WELCOME && e.Arguments[0] == "newnick"
+	})
+	if err != nil {
+		t.Fatalf("WaitFor failed: %v", err)
+	}
+	if got := irccon.GetNick(); got != "newnick" || e.Arguments[0] != "newnick" {
+		t.Fatalf("WaitFor returned before the nick was updated: %q", got)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := irccon.WaitFor(ctx, MatchCodes("NEVER")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitFor error = %v, want DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,443 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrWhoUnanswered is returned by WhoSync and WhoStream when the server
+// answered a later WHO first, so the reply to this one was lost.
+var ErrWhoUnanswered = errors.New("WHO was not answered")
+
+// WHOX field letters, in the order the server sends them in RPL_WHOSPCRPL
+// (354). Combine them in the fields argument of WhoSync and WhoStream.
+const whoxFieldOrder = "tcuihsnfdlaor"
+
+// DefaultWhoFields is used by WhoSync and WhoStream when fields is empty:
+// channel, user, host, nick, flags, account and realname.
+const DefaultWhoFields = "cuhnfar"
+
+// WhoReply is one row of a WHO reply. Only the fields requested from the
+// server are set; with the plain WHO fallback (no WHOX support) Account,
+// IP, Idle and OpLevel are never available.
+type WhoReply struct {
+	Channel  string        // c, "*" when no common channel
+	User     string        // u
+	IP       string        // i
+	Host     string        // h
+	Server   string        // s
+	Nick     string        // n
+	Flags    string        // f, e.g. "H@" or "G*+"
+	Hops     int           // d
+	Idle     time.Duration // l
+	Account  string        // a, empty when not logged in
+	OpLevel  string        // o
+	RealName string        // r
+}
+
+// Away reports whether the user is marked away ("G" in Flags).
+func (r *WhoReply) Away() bool {
+	return strings.HasPrefix(r.Flags, "G")
+}
+
+// Operator reports whether the user is an IRC operator ("*" in Flags).
+func (r *WhoReply) Operator() bool {
+	return strings.Contains(r.Flags, "*")
+}
+
+// normalizeWhoFields returns the known WHOX letters of fields in server
+// order, always including the query token 't'. A leading '%' is accepted.
+func normalizeWhoFields(fields string) string {
+	fields = strings.TrimPrefix(fields, "%")
+	if fields == "" {
+		fields = DefaultWhoFields
+	}
+	var b strings.Builder
+	for i := 0; i < len(whoxFieldOrder); i++ {
+		f := whoxFieldOrder[i]
+		if f == 't' || strings.IndexByte(fields, f) >= 0 {
+			b.WriteByte(f)
+		}
+	}
+	return b.String()
+}
+
+// BuildWhoX returns a WHOX command for mask requesting fields (e.g.
+// "cuhnfar") and tagging the replies with token (1-999):
+//
+//	BuildWhoX("#chan", "cuhnfar", 42) == "WHO #chan %tcuhnfar,42"
+func BuildWhoX(mask, fields string, token int) string {
+	return "WHO " + mask + " %" + normalizeWhoFields(fields) + "," + strconv.Itoa(token)
+}
+
+type whoQuery struct {
+	mask      string
+	fields    string // normalized WHOX fields, empty for plain WHO
+	token     string
+	rows      []WhoReply // received but not yet handed to the caller
+	done      bool
+	err       error
+	abandoned bool          // caller gave up or it failed; drop rows until 315
+	notify    chan struct{} // signalled when rows arrive or the query ends
+}
+
+type whoTracker struct {
+	mu        sync.Mutex
+	queue     []*whoQuery // queries in flight, in the order they were sent
+	lastToken int
+}
+
+func newWhoTracker() *whoTracker {
+	return &whoTracker{}
+}
+
+// nextTokenLocked returns a WHOX token not used by any query in flight.
+func (w *whoTracker) nextTokenLocked() string {
+	for {
+		w.lastToken = w.lastToken%999 + 1
+		token := strconv.Itoa(w.lastToken)
+		inUse := false
+		for _, q := range w.queue {
+			if q.token == token {
+				inUse = true
+				break
+			}
+		}
+		if !inUse {
+			return token
+		}
+	}
+}
+
+func (q *whoQuery) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// endLocked completes the query with err unless it already ended. The
+// caller must hold w.mu.
+func (q *whoQuery) endLocked(err error) {
+	if !q.done {
+		q.done = true
+		q.err = err
+	}
+	q.signal()
+}
+
+// removeLocked takes q out of the queue. The caller must hold w.mu.
+func (w *whoTracker) removeLocked(q *whoQuery) {
+	for i, candidate := range w.queue {
+		if candidate == q {
+			w.queue = append(w.queue[:i:i], w.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// addRow queues a row for the query; rows for abandoned queries are dropped.
+// The caller must hold w.mu.
+func (q *whoQuery) addRowLocked(row WhoReply) {
+	if q.abandoned || q.done {
+		return
+	}
+	q.rows = append(q.rows, row)
+	q.signal()
+}
+
+// WhoSync sends a WHO query for mask and returns all rows once the server
+// sends RPL_ENDOFWHO (315). See WhoStream for fields and errors.
+func (irc *Connection) WhoSync(ctx context.Context, mask, fields string) ([]WhoReply, error) {
+	var rows []WhoReply
+	err := irc.WhoStream(ctx, mask, fields, func(row WhoReply) {
+		rows = append(rows, row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// WhoStream sends a WHO query for mask and calls fn for every row as it
+// arrives, returning when the server sends RPL_ENDOFWHO (315). fn runs on
+// the caller's goroutine, so a slow fn never holds up other events.
+//
+// fields selects the WHOX fields (see WhoReply, e.g. "cuhnfar"; empty means
+// DefaultWhoFields). When the server does not advertise WHOX in ISUPPORT
+// a plain WHO is sent instead and the rows come from RPL_WHOREPLY (352).
+//
+// It returns the SendMessage error when mask cannot be sent,
+// ErrDisconnected when the connection is closed first,
+// ErrTryAgain, ErrTooManyMatches or ErrNoSuchServer when the server
+// refuses the query, ErrWhoUnanswered when the server skipped it and
+// ctx.Err() when ctx is done; use a ctx with a deadline. Plain WHO replies
+// carry no token, so avoid calling Who yourself while a fallback query is
+// in flight.
+func (irc *Connection) WhoStream(ctx context.Context, mask string, fields string, fn func(WhoReply)) error {
+	if mask == "" {
+		return errors.New("empty WHO mask")
+	}
+
+	_, whox := irc.ISupport("WHOX")
+
+	w := irc.who
+	q := &whoQuery{mask: mask, notify: make(chan struct{}, 1)}
+	command := NewMessage("WHO", mask)
+	w.mu.Lock()
+	if whox {
+		q.fields = normalizeWhoFields(fields)
+		q.token = w.nextTokenLocked()
+		command.Params = append(command.Params, "%"+q.fields+","+q.token)
+	}
+	w.queue = append(w.queue, q)
+	w.mu.Unlock()
+
+	if err := irc.SendMessage(command); err != nil {
+		w.mu.Lock()
+		w.removeLocked(q)
+		w.mu.Unlock()
+		return err
+	}
+
+	for {
+		w.mu.Lock()
+		rows := q.rows
+		q.rows = nil
+		done, err := q.done, q.err
+		w.mu.Unlock()
+
+		for _, row := range rows {
+			fn(row)
+		}
+		if done {
+			return err
+		}
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			w.mu.Lock()
+			// Keep it queued so that its 315 is not taken for a later
+			// query for the same mask
+			q.abandoned = true
+			q.rows = nil
+			w.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// failAll ends every query in flight with err.
+func (w *whoTracker) failAll(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, q := range w.queue {
+		q.endLocked(err)
+	}
+	w.queue = nil
+}
+
+// failMask ends the oldest query in flight for mask with err.
+func (w *whoTracker) failMask(mask string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failMaskLocked(mask, err)
+}
+
+func (w *whoTracker) failMaskLocked(mask string, err error) {
+	mask = canonicalizeRFCNick(mask)
+	for _, q := range w.queue {
+		if !q.done && canonicalizeRFCNick(q.mask) == mask {
+			w.removeLocked(q)
+			q.endLocked(err)
+			return
+		}
+	}
+}
+
+// handleWhoXReply routes an RPL_WHOSPCRPL (354) row to the query with the
+// matching token.
+//
+// Format: :server 354 <me> <token> <fields in whoxFieldOrder...> [:<realname>]
+func (w *whoTracker) handleWhoXReply(e *Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var q *whoQuery
+	for _, candidate := range w.queue {
+		if candidate.token != "" && candidate.token == e.Arguments[1] {
+			q = candidate
+			break
+		}
+	}
+	if q == nil {
+		return
+	}
+
+	values := e.Arguments[1:]
+	if len(values) != len(q.fields) {
+		return
+	}
+	var row WhoReply
+	for i := 1; i < len(q.fields); i++ {
+		v := values[i]
+		switch q.fields[i] {
+		case 'c':
+			row.Channel = v
+		case 'u':
+			row.User = v
+		case 'i':
+			row.IP = v
+		case 'h':
+			row.Host = v
+		case 's':
+			row.Server = v
+		case 'n':
+			row.Nick = v
+		case 'f':
+			row.Flags = v
+		case 'd':
+			row.Hops, _ = strconv.Atoi(v)
+		case 'l':
+			if idle, err := strconv.Atoi(v); err == nil {
+				row.Idle = time.Duration(idle) * time.Second
+			}
+		case 'a':
+			if v != "0" {
+				row.Account = v
+			}
+		case 'o':
+			row.OpLevel = v
+		case 'r':
+			row.RealName = v
+		}
+	}
+	q.addRowLocked(row)
+}
+
+// handleWhoReply routes an RPL_WHOREPLY (352) row to the oldest plain WHO
+// query in flight.
+//
+// Format: :server 352 <me> <channel> <user> <host> <server> <nick> <flags> :<hops> <realname>
+func (w *whoTracker) handleWhoReply(e *Event) {
+	if len(e.Arguments) < 8 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.queue) == 0 || w.queue[0].token != "" {
+		return
+	}
+	row := WhoReply{
+		Channel: e.Arguments[1],
+		User:    e.Arguments[2],
+		Host:    e.Arguments[3],
+		Server:  e.Arguments[4],
+		Nick:    e.Arguments[5],
+		Flags:   e.Arguments[6],
+	}
+	hops, realname, _ := strings.Cut(e.Arguments[7], " ")
+	row.Hops, _ = strconv.Atoi(hops)
+	row.RealName = realname
+	w.queue[0].addRowLocked(row)
+}
+
+// handleEndOfWho completes the oldest query in flight for the mask of the
+// RPL_ENDOFWHO (315). The server answers in order, so older queries still
+// waiting were skipped and fail with ErrWhoUnanswered.
+//
+// Format: :server 315 <me> <mask> :End of WHO list
+func (w *whoTracker) handleEndOfWho(e *Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	mask := canonicalizeRFCNick(e.Arguments[1])
+	for i, q := range w.queue {
+		if canonicalizeRFCNick(q.mask) != mask {
+			continue
+		}
+		for _, skipped := range w.queue[:i] {
+			skipped.endLocked(ErrWhoUnanswered)
+		}
+		w.queue = append([]*whoQuery(nil), w.queue[i+1:]...)
+		q.endLocked(nil)
+		return
+	}
+}
+
+// handleWhoError fails the query a WHO error numeric refers to.
+//
+// Formats:
+//
+//	:server 263 <me> WHO :Please wait a while and try again.
+//	:server 416 <me> WHO :output too large, truncated
+//	:server 402 <me> <mask> :No such server
+func (w *whoTracker) handleWhoError(e *Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch e.Code {
+	case RPL_TRYAGAIN:
+		// The server ignored the command, no 315 follows
+		if strings.EqualFold(e.Arguments[1], "WHO") && len(w.queue) > 0 {
+			q := w.queue[0]
+			w.queue = w.queue[1:]
+			q.endLocked(ErrTryAgain)
+		}
+	case ERR_TOOMANYMATCHES:
+		// Keep the query until its 315 so the rest of the reply is
+		// not taken for a later one
+		if strings.EqualFold(e.Arguments[1], "WHO") && len(w.queue) > 0 {
+			q := w.queue[0]
+			q.abandoned = true
+			q.rows = nil
+			q.endLocked(ErrTooManyMatches)
+		}
+	case ERR_NOSUCHSERVER:
+		w.failMaskLocked(e.Arguments[1], ErrNoSuchServer)
+	}
+}
+
+// setupWhoCallbacks installs the reply handlers used by WhoSync and
+// WhoStream.
+func (irc *Connection) setupWhoCallbacks() {
+	irc.AddCallback(RPL_WHOREPLY, irc.who.handleWhoReply)
+	irc.AddCallback(RPL_WHOSPCRPL, irc.who.handleWhoXReply)
+	irc.AddCallback(RPL_ENDOFWHO, irc.who.handleEndOfWho)
+	irc.AddCallback(RPL_TRYAGAIN, irc.who.handleWhoError)
+	irc.AddCallback(ERR_TOOMANYMATCHES, irc.who.handleWhoError)
+	irc.AddCallback(ERR_NOSUCHSERVER, irc.who.handleWhoError)
+}
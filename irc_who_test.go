@@ -0,0 +1,197 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildWhoX(t *testing.T) {
+	if got := BuildWhoX("#chan", "%nuhcraf", 42); got != "WHO #chan %tcuhnfar,42" {
+		t.Fatalf("unexpected WHOX command %q", got)
+	}
+	if got := BuildWhoX("alice", "", 7); got != "WHO alice %t"+DefaultWhoFields+",7" {
+		t.Fatalf("unexpected default WHOX command %q", got)
+	}
+}
+
+func TestWhoSyncWithWhoX(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+	feedLines(t, irccon, ":srv 005 me WHOX :are supported by this server")
+
+	result := make(chan []WhoReply, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		rows, err := irccon.WhoSync(ctx, "#chan", "cuhnfar")
+		if err != nil {
+			t.Errorf("WhoSync failed: %v", err)
+		}
+		result <- rows
+	}()
+
+	if got := nextRawCommand(t, irccon.pwrite); got != "WHO #chan %tcuhnfar,1\r\n" {
+		t.Fatalf("unexpected command %q", got)
+	}
+	feedLines(t, irccon,
+		":srv 354 me 999 #chan ~x host.x x H :foreign token",
+		":srv 354 me 1 #chan ~a host.a alice H@ alice_acct :Alice A",
+		":srv 354 me 1 #chan ~b host.b bob G*+ 0 :Bob B",
+		":srv 315 me #chan :End of /WHO list.",
+	)
+
+	rows := <-result
+	want := []WhoReply{
+		{Channel: "#chan", User: "~a", Host: "host.a", Nick: "alice", Flags: "H@", Account: "alice_acct", RealName: "Alice A"},
+		{Channel: "#chan", User: "~b", Host: "host.b", Nick: "bob", Flags: "G*+", RealName: "Bob B"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("unexpected rows:\n got %+v\nwant %+v", rows, want)
+	}
+	if rows[0].Away() || !rows[1].Away() || rows[0].Operator() || !rows[1].Operator() {
+		t.Fatal("unexpected Away/Operator flags")
+	}
+}
+
+func TestWhoStreamFallsBackToPlainWho(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	rows := make(chan WhoReply, 10)
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		done <- irccon.WhoStream(ctx, "#chan", "", func(row WhoReply) { rows <- row })
+	}()
+
+	if got := nextRawCommand(t, irccon.pwrite); got != "WHO #chan\r\n" {
+		t.Fatalf("unexpected command %q", got)
+	}
+	feedLines(t, irccon, ":srv 352 me #chan ~a host.a irc.srv alice H@ :0 Alice A")
+
+	select {
+	case row := <-rows:
+		want := WhoReply{Channel: "#chan", User: "~a", Host: "host.a", Server: "irc.srv", Nick: "alice", Flags: "H@", RealName: "Alice A"}
+		if row != want {
+			t.Fatalf("unexpected row %+v", row)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected row to be streamed before the end of the reply")
+	}
+
+	feedLines(t, irccon, ":srv 315 me #CHAN :End of /WHO list.")
+	if err := <-done; err != nil {
+		t.Fatalf("WhoStream failed: %v", err)
+	}
+}
+
+func TestWhoSyncFailsOnDisconnect(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := irccon.WhoSync(context.Background(), "#chan", "")
+		done <- err
+	}()
+	nextRawCommand(t, irccon.pwrite)
+
+	irccon.Lock()
+	irccon.resetRegistrationStateLocked()
+	irccon.Unlock()
+
+	if err := <-done; !errors.Is(err, ErrDisconnected) {
+		t.Fatalf("expected ErrDisconnected, got %v", err)
+	}
+}
+
+func TestWhoQueueDoesNotBlockOnLostReplies(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+	feedLines(t, irccon, ":srv 005 me WHOX :are supported by this server")
+
+	who := func(mask string) chan error {
+		done := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			_, err := irccon.WhoSync(ctx, mask, "n")
+			done <- err
+		}()
+		nextRawCommand(t, irccon.pwrite)
+		return done
+	}
+	result := func(done chan error) error {
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(3 * time.Second):
+			t.Fatal("WhoSync did not return")
+			return nil
+		}
+	}
+
+	limited := who("#a")
+	feedLines(t, irccon, ":srv 263 me WHO :Please wait a while and try again.")
+	if err := result(limited); !errors.Is(err, ErrTryAgain) {
+		t.Fatalf("expected ErrTryAgain, got %v", err)
+	}
+
+	lost, next := who("#lost"), who("#next")
+	feedLines(t, irccon, ":srv 315 me #next :End of /WHO list.")
+	if err := result(next); err != nil {
+		t.Fatalf("expected #next to complete, got %v", err)
+	}
+	if err := result(lost); !errors.Is(err, ErrWhoUnanswered) {
+		t.Fatalf("expected ErrWhoUnanswered, got %v", err)
+	}
+
+	remote := who("nick.srv")
+	feedLines(t, irccon, ":srv 402 me nick.srv :No such server")
+	if err := result(remote); !errors.Is(err, ErrNoSuchServer) {
+		t.Fatalf("expected ErrNoSuchServer, got %v", err)
+	}
+}
+
+func TestWhoCancelledQueryKeepsItsReply(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+	feedLines(t, irccon, ":srv 005 me WHOX :are supported by this server")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := irccon.WhoSync(ctx, "#go", "n"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	nextRawCommand(t, irccon.pwrite)
+
+	result := make(chan []WhoReply, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		rows, err := irccon.WhoSync(ctx, "#go", "n")
+		if err != nil {
+			t.Errorf("WhoSync failed: %v", err)
+		}
+		result <- rows
+	}()
+	if got := nextRawCommand(t, irccon.pwrite); got != "WHO #go %tn,2\r\n" {
+		t.Fatalf("unexpected command %q", got)
+	}
+
+	// The cancelled query's late reply comes first
+	feedLines(t, irccon,
+		":srv 354 me 1 old",
+		":srv 315 me #go :End of /WHO list.",
+		":srv 354 me 2 alice",
+		":srv 315 me #go :End of /WHO list.",
+	)
+	rows := <-result
+	if len(rows) != 1 || rows[0].Nick != "alice" {
+		t.Fatalf("got rows %+v, want only alice", rows)
+	}
+}
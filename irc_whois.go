@@ -0,0 +1,310 @@
+// Copyright (c) 2024 Jerzy Dąbrowski. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided
+// that the following conditions are met:
+//
+//   - Redistributions of source code must retain the above copyright notice, this list of conditions,
+//     and the following disclaimer.
+//   - Redistributions in binary form must reproduce the above copyright notice, this list of conditions,
+//     and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//   - Neither the name of the original authors nor the names of its contributors may be used to endorse
+//     or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED "AS IS" WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT
+// LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE, AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDERS OR CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES, OR OTHER LIABILITY,
+// WHETHER IN AN ACTION OF CONTRACT, TORT, OR OTHERWISE, ARISING FROM, OUT OF, OR IN CONNECTION WITH THE SOFTWARE
+// OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package irc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoSuchNick is returned by WhoisSync when the server replies with
+	// ERR_NOSUCHNICK (401).
+	ErrNoSuchNick = errors.New("no such nick")
+	// ErrNoSuchServer is returned by WhoisSync when the server replies with
+	// ERR_NOSUCHSERVER (402).
+	ErrNoSuchServer = errors.New("no such server")
+)
+
+// WhoisInfo is the aggregated result of a WHOIS query.
+type WhoisInfo struct {
+	Nick     string // 311
+	User     string // 311
+	Host     string // 311
+	RealName string // 311
+
+	Server     string // 312
+	ServerInfo string // 312
+
+	Operator bool   // 313
+	Secure   bool   // 671, connected over TLS
+	Account  string // 330, services account; empty if not logged in
+	Away     string // 301, away message; empty if not away
+
+	Idle   time.Duration // 317
+	SignOn time.Time     // 317, zero if the server did not send it
+
+	// Channels lists the channels from 319 with their membership prefixes,
+	// e.g. "@#ops" or "+#chat".
+	Channels []string
+
+	ActualHost string // 338, real host if visible to us
+	ActualIP   string // 338, real IP if visible to us
+}
+
+type whoisRequest struct {
+	info      *WhoisInfo
+	err       error
+	done      chan struct{}
+	waiters   int  // WhoisSync calls sharing the request
+	abandoned bool // every waiter gave up; drop replies until 318
+}
+
+type whoisTracker struct {
+	mu sync.Mutex
+	// canonical nick -> requests in flight, in the order they were sent;
+	// the server answers them in the same order
+	pending map[string][]*whoisRequest
+}
+
+func newWhoisTracker() *whoisTracker {
+	return &whoisTracker{pending: make(map[string][]*whoisRequest)}
+}
+
+// WhoisSync sends WHOIS for nick and collects the replies until
+// RPL_ENDOFWHOIS (318). It returns ErrNoSuchNick or ErrNoSuchServer when the
+// server reports the nick does not exist, ErrDisconnected when the
+// connection is closed first, and ctx.Err() when ctx is done.
+//
+// Use a ctx with a deadline: a server that never answers would otherwise
+// block forever. Concurrent calls for different nicks are independent;
+// concurrent calls for the same nick share a single WHOIS and its result,
+// which stays pending until the last of them returns.
+func (irc *Connection) WhoisSync(ctx context.Context, nick string) (*WhoisInfo, error) {
+	if nick == "" {
+		return nil, errors.New("empty nick")
+	}
+
+	w := irc.whois
+	key := canonicalizeRFCNick(nick)
+	w.mu.Lock()
+	var req *whoisRequest
+	queue := w.pending[key]
+	if n := len(queue); n > 0 && !queue[n-1].abandoned {
+		req = queue[n-1]
+	}
+	inFlight := req != nil
+	if !inFlight {
+		// An abandoned request stays queued ahead of this one until its
+		// own replies are over
+		req = &whoisRequest{info: &WhoisInfo{Nick: nick}, done: make(chan struct{})}
+		w.pending[key] = append(queue, req)
+	}
+	req.waiters++
+	w.mu.Unlock()
+
+	if !inFlight {
+		irc.Whois(nick)
+	}
+
+	select {
+	case <-req.done:
+		if req.err != nil {
+			return nil, req.err
+		}
+		return req.info, nil
+	case <-ctx.Done():
+		w.mu.Lock()
+		req.waiters--
+		if req.waiters == 0 {
+			req.abandoned = true
+		}
+		w.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// update applies fn to the oldest request pending for nick, if any.
+func (w *whoisTracker) update(nick string, fn func(info *WhoisInfo)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if queue := w.pending[canonicalizeRFCNick(nick)]; len(queue) > 0 && !queue[0].abandoned {
+		fn(queue[0].info)
+	}
+}
+
+// finish completes the oldest request pending for nick with err (nil on
+// success).
+func (w *whoisTracker) finish(nick string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := canonicalizeRFCNick(nick)
+	queue := w.pending[key]
+	if len(queue) == 0 {
+		return
+	}
+	req := queue[0]
+	if len(queue) == 1 {
+		delete(w.pending, key)
+	} else {
+		w.pending[key] = queue[1:]
+	}
+	req.err = err
+	close(req.done)
+}
+
+// failAll completes every pending request with err.
+func (w *whoisTracker) failAll(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key, queue := range w.pending {
+		delete(w.pending, key)
+		for _, req := range queue {
+			req.err = err
+			close(req.done)
+		}
+	}
+}
+
+// setupWhoisCallbacks installs the reply handlers used by WhoisSync.
+// Replies for nicks nobody is waiting for are left to user callbacks.
+func (irc *Connection) setupWhoisCallbacks() {
+	w := irc.whois
+
+	// RPL_WHOISUSER (311): <me> <nick> <user> <host> * :<realname>
+	irc.AddCallback(RPL_WHOISUSER, func(e *Event) {
+		if len(e.Arguments) < 6 {
+			return
+		}
+		w.update(e.Arguments[1], func(info *WhoisInfo) {
+			info.Nick = e.Arguments[1]
+			info.User = e.Arguments[2]
+			info.Host = e.Arguments[3]
+			info.RealName = e.Arguments[5]
+		})
+	})
+
+	// RPL_WHOISSERVER (312): <me> <nick> <server> :<server info>
+	irc.AddCallback(RPL_WHOISSERVER, func(e *Event) {
+		if len(e.Arguments) < 4 {
+			return
+		}
+		w.update(e.Arguments[1], func(info *WhoisInfo) {
+			info.Server = e.Arguments[2]
+			info.ServerInfo = e.Arguments[3]
+		})
+	})
+
+	// RPL_WHOISOPERATOR (313): <me> <nick> :is an IRC operator
+	irc.AddCallback(RPL_WHOISOPERATOR, func(e *Event) {
+		if len(e.Arguments) < 2 {
+			return
+		}
+		w.update(e.Arguments[1], func(info *WhoisInfo) { info.Operator = true })
+	})
+
+	// RPL_WHOISIDLE (317): <me> <nick> <idle> [<signon>] :seconds idle[, signon time]
+	irc.AddCallback(RPL_WHOISIDLE, func(e *Event) {
+		if len(e.Arguments) < 3 {
+			return
+		}
+		w.update(e.Arguments[1], func(info *WhoisInfo) {
+			if idle, err := strconv.ParseInt(e.Arguments[2], 10, 64); err == nil {
+				info.Idle = time.Duration(idle) * time.Second
+			}
+			if len(e.Arguments) >= 5 {
+				if signon, err := strconv.ParseInt(e.Arguments[3], 10, 64); err == nil {
+					info.SignOn = time.Unix(signon, 0)
+				}
+			}
+		})
+	})
+
+	// RPL_WHOISCHANNELS (319): <me> <nick> :{[prefix]<channel> }, may repeat
+	irc.AddCallback(RPL_WHOISCHANNELS, func(e *Event) {
+		if len(e.Arguments) < 3 {
+			return
+		}
+		w.update(e.Arguments[1], func(info *WhoisInfo) {
+			info.Channels = append(info.Channels, strings.Fields(e.Arguments[2])...)
+		})
+	})
+
+	// RPL_WHOISACCOUNT (330): <me> <nick> <account> :is logged in as
+	irc.AddCallback(RPL_WHOISACCOUNT, func(e *Event) {
+		if len(e.Arguments) < 3 {
+			return
+		}
+		w.update(e.Arguments[1], func(info *WhoisInfo) { info.Account = e.Arguments[2] })
+	})
+
+	// RPL_WHOISACTUALLY (338), which differs between servers:
+	//   <me> <nick> <user@host> <ip> :Actually using host
+	//   <me> <nick> <ip> :Actually using host
+	irc.AddCallback(RPL_WHOISACTUALLY, func(e *Event) {
+		if len(e.Arguments) < 4 {
+			return
+		}
+		w.update(e.Arguments[1], func(info *WhoisInfo) {
+			if len(e.Arguments) >= 5 {
+				info.ActualHost = e.Arguments[2]
+				info.ActualIP = e.Arguments[3]
+			} else if net.ParseIP(e.Arguments[2]) != nil {
+				info.ActualIP = e.Arguments[2]
+			} else {
+				info.ActualHost = e.Arguments[2]
+			}
+		})
+	})
+
+	// RPL_WHOISSECURE (671): <me> <nick> :is using a secure connection
+	irc.AddCallback(RPL_WHOISSECURE, func(e *Event) {
+		if len(e.Arguments) < 2 {
+			return
+		}
+		w.update(e.Arguments[1], func(info *WhoisInfo) { info.Secure = true })
+	})
+
+	// RPL_AWAY (301): <me> <nick> :<away message>
+	irc.AddCallback(RPL_AWAY, func(e *Event) {
+		if len(e.Arguments) < 3 {
+			return
+		}
+		w.update(e.Arguments[1], func(info *WhoisInfo) { info.Away = e.Arguments[2] })
+	})
+
+	// RPL_ENDOFWHOIS (318): <me> <nick> :End of /WHOIS list
+	irc.AddCallback(RPL_ENDOFWHOIS, func(e *Event) {
+		if len(e.Arguments) < 2 {
+			return
+		}
+		w.finish(e.Arguments[1], nil)
+	})
+
+	// ERR_NOSUCHNICK (401): <me> <nick> :No such nick/channel
+	irc.AddCallback(ERR_NOSUCHNICK, func(e *Event) {
+		if len(e.Arguments) < 2 {
+			return
+		}
+		w.finish(e.Arguments[1], ErrNoSuchNick)
+	})
+
+	// ERR_NOSUCHSERVER (402): <me> <server> :No such server
+	irc.AddCallback(ERR_NOSUCHSERVER, func(e *Event) {
+		if len(e.Arguments) < 2 {
+			return
+		}
+		w.finish(e.Arguments[1], ErrNoSuchServer)
+	})
+}
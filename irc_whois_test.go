@@ -0,0 +1,190 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type whoisResult struct {
+	info *WhoisInfo
+	err  error
+}
+
+func runWhoisSync(irccon *Connection, nick string) <-chan whoisResult {
+	result := make(chan whoisResult, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		info, err := irccon.WhoisSync(ctx, nick)
+		result <- whoisResult{info, err}
+	}()
+	return result
+}
+
+func feedLines(t *testing.T, irccon *Connection, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		event, err := parseToEvent(line)
+		if err != nil {
+			t.Fatalf("parseToEvent(%q) failed: %v", line, err)
+		}
+		event.Connection = irccon
+		irccon.RunCallbacks(event)
+	}
+}
+
+func TestWhoisSyncAggregatesReplies(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	alice := runWhoisSync(irccon, "Alice")
+	if got := nextRawCommand(t, irccon.pwrite); got != "WHOIS Alice\r\n" {
+		t.Fatalf("unexpected command %q", got)
+	}
+	bob := runWhoisSync(irccon, "bob")
+	if got := nextRawCommand(t, irccon.pwrite); got != "WHOIS bob\r\n" {
+		t.Fatalf("unexpected command %q", got)
+	}
+
+	feedLines(t, irccon,
+		":srv 311 me alice ~a example.org * :Alice Liddell",
+		":srv 401 me bob :No such nick/channel",
+		":srv 312 me alice irc.example.org :Example server",
+		":srv 313 me alice :is an IRC operator",
+		":srv 319 me alice :@#ops +#chat",
+		":srv 319 me alice :#lobby",
+		":srv 330 me alice alice_acct :is logged in as",
+		":srv 338 me alice ~a@10.0.0.1 10.0.0.1 :Actually using host",
+		":srv 671 me alice :is using a secure connection",
+		":srv 301 me alice :gone fishing",
+		":srv 317 me alice 42 1700000000 :seconds idle, signon time",
+		":srv 318 me alice :End of /WHOIS list.",
+	)
+
+	r := <-bob
+	if !errors.Is(r.err, ErrNoSuchNick) {
+		t.Fatalf("expected ErrNoSuchNick for bob, got %v", r.err)
+	}
+
+	r = <-alice
+	if r.err != nil {
+		t.Fatalf("WhoisSync failed: %v", r.err)
+	}
+	want := &WhoisInfo{
+		Nick:       "alice",
+		User:       "~a",
+		Host:       "example.org",
+		RealName:   "Alice Liddell",
+		Server:     "irc.example.org",
+		ServerInfo: "Example server",
+		Operator:   true,
+		Secure:     true,
+		Account:    "alice_acct",
+		Away:       "gone fishing",
+		Idle:       42 * time.Second,
+		SignOn:     time.Unix(1700000000, 0),
+		Channels:   []string{"@#ops", "+#chat", "#lobby"},
+		ActualHost: "~a@10.0.0.1",
+		ActualIP:   "10.0.0.1",
+	}
+	if !reflect.DeepEqual(r.info, want) {
+		t.Fatalf("unexpected WHOIS result:\n got %+v\nwant %+v", r.info, want)
+	}
+}
+
+func TestWhoisSyncSharesRequestAndFailsOnDisconnect(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	first := runWhoisSync(irccon, "carol")
+	nextRawCommand(t, irccon.pwrite)
+	second := runWhoisSync(irccon, "CAROL")
+
+	select {
+	case got := <-irccon.pwrite:
+		t.Fatalf("expected a single WHOIS for concurrent requests, got %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	irccon.Lock()
+	irccon.resetRegistrationStateLocked()
+	irccon.Unlock()
+
+	for _, result := range []<-chan whoisResult{first, second} {
+		if r := <-result; !errors.Is(r.err, ErrDisconnected) {
+			t.Fatalf("expected ErrDisconnected, got %v", r.err)
+		}
+	}
+}
+
+func TestWhoisSyncContextCancel(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := irccon.WhoisSync(ctx, "dave"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	nextRawCommand(t, irccon.pwrite)
+
+	// The abandoned WHOIS keeps its replies; a new one waits for its own
+	result := runWhoisSync(irccon, "dave")
+	if got := nextRawCommand(t, irccon.pwrite); got != "WHOIS dave\r\n" {
+		t.Fatalf("expected a new WHOIS, got %q", got)
+	}
+	feedLines(t, irccon,
+		":srv 311 me dave old host * :Old Reply",
+		":srv 318 me dave :End of /WHOIS list.",
+		":srv 311 me dave new host * :New Reply",
+		":srv 318 me dave :End of /WHOIS list.",
+	)
+	if r := <-result; r.err != nil || r.info.RealName != "New Reply" {
+		t.Fatalf("got %+v, %v; want the second reply", r.info, r.err)
+	}
+	if len(irccon.whois.pending) != 0 {
+		t.Fatal("expected no request left")
+	}
+}
+
+func TestWhoisSyncCancelKeepsOtherWaiters(t *testing.T) {
+	irccon := IRC("me", "testuser")
+	irccon.pwrite = make(chan string, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := irccon.WhoisSync(ctx, "erin")
+		cancelled <- err
+	}()
+	nextRawCommand(t, irccon.pwrite)
+	waiting := runWhoisSync(irccon, "erin")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		irccon.whois.mu.Lock()
+		waiters := irccon.whois.pending["erin"][0].waiters
+		irccon.whois.mu.Unlock()
+		if waiters == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second WhoisSync did not join the request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	feedLines(t, irccon,
+		":srv 311 me erin e host * :Erin",
+		":srv 318 me erin :End of /WHOIS list.",
+	)
+	if r := <-waiting; r.err != nil || r.info.RealName != "Erin" {
+		t.Fatalf("got %+v, %v; want the reply", r.info, r.err)
+	}
+}